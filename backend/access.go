@@ -0,0 +1,131 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+
+	"golang.org/x/net/context"
+)
+
+// role is a level of access to debug and admin handlers.
+// Each role includes all permissions of the lower ones.
+type role int
+
+const (
+	roleNone role = iota
+	roleViewer
+	roleOperator
+	roleAdmin
+)
+
+// roleNames maps role names, as used in config.Roles, to their values.
+var roleNames = map[string]role{
+	"viewer":   roleViewer,
+	"operator": roleOperator,
+	"admin":    roleAdmin,
+}
+
+func (rl role) String() string {
+	for k, v := range roleNames {
+		if v == rl {
+			return k
+		}
+	}
+	return "none"
+}
+
+// userRole returns the highest role granted to email in config.Roles,
+// either directly or by its @domain.
+func userRole(email string) role {
+	rl := roleNone
+	for name, list := range config().Roles {
+		if v := roleNames[name]; v > rl && emailInList(list, email) {
+			rl = v
+		}
+	}
+	return rl
+}
+
+// requireRole returns a handle func which allows only users with at least role rl
+// to access fn. Users are identified by the login session cookie.
+// Requests other than GET and HEAD must also come from a page of this server,
+// see sameOrigin, so that other sites can't make them on behalf of a logged in user.
+// Denied and allowed requests are audit logged.
+//
+// All requests are allowed in dev env, so that debug handlers work w/o login.
+func requireRole(rl role, fn func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := newContext(r)
+		if isDev() {
+			fn(w, r)
+			return
+		}
+		email, err := sessionEmail(r)
+		if err != nil {
+			auditf(c, r, "", "denied: not logged in")
+			if r.Method != "GET" {
+				writeJSONError(c, w, http.StatusUnauthorized, "login required")
+				return
+			}
+			// the prefix has been stripped by the handler
+			next := path.Join(config().Prefix, r.URL.Path)
+			if r.URL.RawQuery != "" {
+				next += "?" + r.URL.RawQuery
+			}
+			redirectLogin(w, r, next)
+			return
+		}
+		if v := userRole(email); v < rl {
+			auditf(c, r, email, "denied: role %s; want %s", v, rl)
+			writeJSONError(c, w, http.StatusForbidden, "access denied")
+			return
+		}
+		if r.Method != "GET" && r.Method != "HEAD" && !sameOrigin(r) {
+			auditf(c, r, email, "denied: cross-origin; origin %q, referer %q",
+				r.Header.Get("origin"), r.Header.Get("referer"))
+			writeJSONError(c, w, http.StatusForbidden, "cross-origin request")
+			return
+		}
+		auditf(c, r, email, "allowed: role %s", rl)
+		fn(w, r)
+	}
+}
+
+// sameOrigin reports whether request r has been made from a page of this server,
+// according to its Origin header or, if it's missing, Referer.
+// Requests with neither of them are not considered same-origin.
+func sameOrigin(r *http.Request) bool {
+	src := r.Header.Get("origin")
+	if src == "" {
+		src = r.Header.Get("referer")
+	}
+	u, err := url.Parse(src)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == requestScheme(r) && u.Host == r.Host
+}
+
+// auditf logs a privileged call or access denial of the request r by user email.
+func auditf(c context.Context, r *http.Request, email, format string, args ...interface{}) {
+	if email == "" {
+		email = "anonymous"
+	}
+	args = append([]interface{}{r.Method, r.URL.Path, email}, args...)
+	logf(c, "AUDIT: %s %s by %s: "+format, args...)
+}
@@ -0,0 +1,114 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestUserRole(t *testing.T) {
+	defer preserveConfig()()
+	config().Roles = map[string][]string{
+		"viewer":   {"@example.org"},
+		"operator": {"@ops.example.org", "op@example.org"},
+		"admin":    {"admin@example.org"},
+	}
+	for _, list := range config().Roles {
+		sort.Strings(list)
+	}
+
+	tests := []struct {
+		email string
+		role  role
+	}{
+		{"", roleNone},
+		{"dude@example.com", roleNone},
+		{"dude@example.org", roleViewer},
+		{"op@example.org", roleOperator},
+		{"dude@ops.example.org", roleOperator},
+		{"admin@example.org", roleAdmin},
+	}
+	for _, test := range tests {
+		if rl := userRole(test.email); rl != test.role {
+			t.Errorf("userRole(%q) = %s; want %s", test.email, rl, test.role)
+		}
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	defer preserveConfig()()
+	config().Env = "prod"
+	config().Google.Auth.CookieKey = "cookie-key"
+	config().Roles = map[string][]string{
+		"viewer":   {"viewer@example.org"},
+		"operator": {"op@example.org"},
+	}
+
+	fn := requireRole(roleOperator, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	tests := []struct {
+		method, email   string
+		origin, referer string
+		code            int
+	}{
+		{"GET", "", "", "", http.StatusFound},
+		{"POST", "", "https://example.org", "", http.StatusUnauthorized},
+		{"GET", "dude@example.org", "", "", http.StatusForbidden},
+		{"GET", "viewer@example.org", "", "", http.StatusForbidden},
+		{"GET", "op@example.org", "", "", http.StatusOK},
+		{"POST", "op@example.org", "https://example.org", "", http.StatusOK},
+		{"POST", "op@example.org", "", "https://example.org/io2016/debug/sync", http.StatusOK},
+		{"POST", "op@example.org", "", "", http.StatusForbidden},
+		{"POST", "op@example.org", "null", "", http.StatusForbidden},
+		{"POST", "op@example.org", "https://evil.example.com", "", http.StatusForbidden},
+		{"POST", "op@example.org", "http://example.org", "", http.StatusForbidden},
+		{"POST", "op@example.org", "", "https://evil.example.com/example.org", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(test.method, "/debug/sync", nil)
+		r.Host = "example.org"
+		if test.origin != "" {
+			r.Header.Set("origin", test.origin)
+		}
+		if test.referer != "" {
+			r.Header.Set("referer", test.referer)
+		}
+		if test.email != "" {
+			v, err := signCookie(sessionCookie, test.email, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.AddCookie(&http.Cookie{Name: sessionCookie, Value: v})
+		}
+		fn(w, r)
+		if w.Code != test.code {
+			t.Errorf("%+v: w.Code = %d; want %d", test, w.Code, test.code)
+		}
+	}
+
+	config().Env = "dev"
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/debug/sync", nil)
+	fn(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("dev: w.Code = %d; want 200", w.Code)
+	}
+}
@@ -22,7 +22,6 @@ import (
 	"path"
 
 	"golang.org/x/net/context"
-	"google.golang.org/appengine/taskqueue"
 )
 
 // notifySubscriberAsync creates an async job to begin notify subscribers.
@@ -31,39 +30,42 @@ func notifySubscribersAsync(c context.Context, d *dataChanges, all bool) error {
 	if err != nil {
 		return err
 	}
-	p := path.Join(config.Prefix, "/task/notify-subscribers")
-	t := taskqueue.NewPOSTTask(p, url.Values{
-		"changes": {string(changes)},
-		"all":     {fmt.Sprintf("%v", all)},
+	p := path.Join(config().Prefix, "/task/notify-subscribers")
+	t := newPOSTTask(p, url.Values{
+		"changes":        {string(changes)},
+		"all":            {fmt.Sprintf("%v", all)},
+		correlationParam: {correlationID(c)},
 	})
-	_, err = taskqueue.Add(c, t, "")
-	return err
+	return queue.add(c, t, "")
 }
 
 func notifyShardAsync(c context.Context, shard, changes string, all bool) error {
-	p := path.Join(config.Prefix, "/task/notify-shard")
-	t := taskqueue.NewPOSTTask(p, url.Values{
-		"shard":   {shard},
-		"changes": {changes},
-		"all":     {fmt.Sprintf("%v", all)},
+	p := path.Join(config().Prefix, "/task/notify-shard")
+	t := newPOSTTask(p, url.Values{
+		"shard":          {shard},
+		"changes":        {changes},
+		"all":            {fmt.Sprintf("%v", all)},
+		correlationParam: {correlationID(c)},
 	})
-	_, err := taskqueue.Add(c, t, "")
-	return err
+	return queue.add(c, t, "")
 }
 
-func notifyUserAsync(c context.Context, uid, shard string, m *pushMessage) error {
-	p := path.Join(config.Prefix, "/task/notify-user")
+// notifyUserAsync creates an async job to send push message m to user uid.
+// If subs is not empty, only subscriptions with these keys are notified.
+func notifyUserAsync(c context.Context, uid, shard string, m *pushMessage, subs ...string) error {
+	p := path.Join(config().Prefix, "/task/notify-user")
 	msg, err := json.Marshal(m)
 	if err != nil {
 		return err
 	}
-	t := taskqueue.NewPOSTTask(p, url.Values{
-		"uid":     {uid},
-		"shard":   {shard},
-		"message": {string(msg)},
+	t := newPOSTTask(p, url.Values{
+		"uid":            {uid},
+		"shard":          {shard},
+		"message":        {string(msg)},
+		"sub":            subs,
+		correlationParam: {correlationID(c)},
 	})
-	_, err = taskqueue.Add(c, t, "")
-	return err
+	return queue.add(c, t, "")
 }
 
 // submitSurveyAsync schedules an async job to submit feedback survey s for session sid.
@@ -72,12 +74,11 @@ func submitSurveyAsync(c context.Context, sid string, s *sessionSurvey) error {
 	if err != nil {
 		return err
 	}
-	t := &taskqueue.Task{
-		Path:    path.Join(config.Prefix, "/task/survey", sid),
+	t := &task{
+		Path:    path.Join(config().Prefix, "/task/survey", sid),
 		Payload: payload,
 		Header:  http.Header{"Content-Type": {"application/json"}},
 		Method:  "POST",
 	}
-	_, err = taskqueue.Add(c, t, "")
-	return err
+	return queue.add(c, t, "")
 }
@@ -45,7 +45,7 @@ func (t *twitterCredentials) Token() (*oauth2.Token, error) {
 	basic = base64.StdEncoding.EncodeToString([]byte(basic))
 
 	params := url.Values{"grant_type": {"client_credentials"}}
-	req, err := http.NewRequest("POST", config.Twitter.TokenURL, strings.NewReader(params.Encode()))
+	req, err := http.NewRequest("POST", config().Twitter.TokenURL, strings.NewReader(params.Encode()))
 	if err != nil {
 		return nil, err
 	}
@@ -80,14 +80,15 @@ func (t *twitterCredentials) Token() (*oauth2.Token, error) {
 
 // serviceCredentials returns a token source for config.Google.ServiceAccount.
 func serviceCredentials(c context.Context, scopes ...string) (oauth2.TokenSource, error) {
-	if config.Google.ServiceAccount.Key == "" || config.Google.ServiceAccount.Email == "" {
+	cfg := config()
+	if cfg.Google.ServiceAccount.Key == "" || cfg.Google.ServiceAccount.Email == "" {
 		return nil, errors.New("serviceCredentials: key or email is empty")
 	}
 	cred := &jwt.Config{
-		Email:      config.Google.ServiceAccount.Email,
-		PrivateKey: []byte(config.Google.ServiceAccount.Key),
+		Email:      cfg.Google.ServiceAccount.Email,
+		PrivateKey: []byte(cfg.Google.ServiceAccount.Key),
 		Scopes:     scopes,
-		TokenURL:   config.Google.TokenURL,
+		TokenURL:   cfg.Google.TokenURL,
 	}
 	return cred.TokenSource(c), nil
 }
@@ -15,8 +15,12 @@
 package backend
 
 import (
+	"container/list"
 	"errors"
+	"fmt"
 	"math/rand"
+	"strconv"
+	"sync"
 	"time"
 
 	"google.golang.org/appengine/memcache"
@@ -31,6 +35,8 @@ var (
 
 	// TODO: rename this to errNotFound and move to errors.go
 	errCacheMiss = errors.New("cache: miss")
+	// errCacheNotNumber is returned by inc when the cached value is not a decimal number.
+	errCacheNotNumber = errors.New("cache: value is not a decimal number")
 
 	// shard the memcache keys across multiple instances
 	cachedEventDataKey     string
@@ -107,3 +113,227 @@ func (mc *gaeMemcache) deleteMulti(c context.Context, keys []string) error {
 func (mc *gaeMemcache) flush(c context.Context) error {
 	return memcache.Flush(c)
 }
+
+// kinds of values put by setCachedBlob, stored in the first byte of a cached value
+const (
+	cachedBlobInline = 'i' // followed by the blob itself
+	cachedBlobParts  = 'p' // followed by "<number of parts> <checksum>"
+)
+
+// setCachedBlob puts b into the cache under key, similar to cache.set,
+// splitting it into multiple items if b is larger than blobPartSize.
+// The parts are keyed by b's checksum and put before the item of key,
+// so that readers never mix up parts of values put concurrently.
+// Use getCachedBlob to retrieve b.
+func setCachedBlob(c context.Context, key string, b []byte, exp time.Duration) error {
+	if len(b) <= blobPartSize {
+		return cache.set(c, key, append([]byte{cachedBlobInline}, b...), exp)
+	}
+	sum := blobSum(b)
+	n := 0
+	for i := 0; i < len(b); i += blobPartSize {
+		end := i + blobPartSize
+		if end > len(b) {
+			end = len(b)
+		}
+		if err := cache.set(c, cachedBlobPartKey(key, sum, n), b[i:end], exp); err != nil {
+			return err
+		}
+		n++
+	}
+	return cache.set(c, key, []byte(fmt.Sprintf("%c%d %s", cachedBlobParts, n, sum)), exp)
+}
+
+// getCachedBlob returns a value put with setCachedBlob under key.
+// It returns errCacheMiss if the value or any of its parts are missing,
+// or the parts don't match the checksum.
+func getCachedBlob(c context.Context, key string) ([]byte, error) {
+	v, err := cache.get(c, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(v) == 0 || (v[0] != cachedBlobInline && v[0] != cachedBlobParts) {
+		// not put by setCachedBlob
+		return nil, errCacheMiss
+	}
+	if v[0] == cachedBlobInline {
+		return v[1:], nil
+	}
+	var n int
+	var sum string
+	if _, err := fmt.Sscanf(string(v[1:]), "%d %s", &n, &sum); err != nil {
+		return nil, errCacheMiss
+	}
+	var b []byte
+	for i := 0; i < n; i++ {
+		p, err := cache.get(c, cachedBlobPartKey(key, sum, i))
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, p...)
+	}
+	if blobSum(b) != sum {
+		return nil, errCacheMiss
+	}
+	return b, nil
+}
+
+// cachedBlobPartKey returns the cache key of i-th part of a blob with checksum sum,
+// put under key by setCachedBlob.
+func cachedBlobPartKey(key, sum string, i int) string {
+	if len(sum) > 16 {
+		sum = sum[:16]
+	}
+	return fmt.Sprintf("%s:%s:%d", key, sum, i)
+}
+
+// memoryCacheSize is the default max number of items in memoryCache.
+const memoryCacheSize = 1000
+
+// memoryCache is a cacheInterface implementation which keeps items in memory.
+// It is safe for concurrent use.
+// The number of items is bounded by size; least recently used items
+// are evicted first when the limit is reached.
+type memoryCache struct {
+	sync.Mutex
+	size  int
+	ll    *list.List               // front is the most recently used
+	items map[string]*list.Element // values are *memoryCacheItem
+	// now returns current time; useful for testing
+	now func() time.Time
+}
+
+type memoryCacheItem struct {
+	key  string
+	data []byte
+	exp  time.Time // zero value means the item never expires
+}
+
+// newMemoryCache creates a new memoryCache limited to size items.
+// If size <= 0, memoryCacheSize is used.
+func newMemoryCache(size int) *memoryCache {
+	if size <= 0 {
+		size = memoryCacheSize
+	}
+	return &memoryCache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+		now:   time.Now,
+	}
+}
+
+func (mc *memoryCache) set(c context.Context, key string, data []byte, exp time.Duration) error {
+	mc.Lock()
+	defer mc.Unlock()
+	b := make([]byte, len(data))
+	copy(b, data)
+	mc.put(key, b, mc.expiry(exp))
+	return nil
+}
+
+func (mc *memoryCache) inc(c context.Context, key string, delta int64, initial uint64) (uint64, error) {
+	mc.Lock()
+	defer mc.Unlock()
+	v := initial
+	var exp time.Time
+	if item := mc.lookup(key); item != nil {
+		n, err := strconv.ParseUint(string(item.data), 10, 64)
+		if err != nil {
+			return 0, errCacheNotNumber
+		}
+		v, exp = n, item.exp
+	}
+	switch {
+	case delta >= 0:
+		v += uint64(delta)
+	case uint64(-delta) > v:
+		v = 0
+	default:
+		v -= uint64(-delta)
+	}
+	mc.put(key, []byte(strconv.FormatUint(v, 10)), exp)
+	return v, nil
+}
+
+func (mc *memoryCache) get(c context.Context, key string) ([]byte, error) {
+	mc.Lock()
+	defer mc.Unlock()
+	item := mc.lookup(key)
+	if item == nil {
+		return nil, errCacheMiss
+	}
+	b := make([]byte, len(item.data))
+	copy(b, item.data)
+	return b, nil
+}
+
+func (mc *memoryCache) deleteMulti(c context.Context, keys []string) error {
+	mc.Lock()
+	defer mc.Unlock()
+	for _, k := range keys {
+		if e, ok := mc.items[k]; ok {
+			mc.remove(e)
+		}
+	}
+	return nil
+}
+
+func (mc *memoryCache) flush(c context.Context) error {
+	mc.Lock()
+	defer mc.Unlock()
+	mc.ll.Init()
+	mc.items = make(map[string]*list.Element)
+	return nil
+}
+
+// expiry converts relative expiration exp into an absolute time.
+// Zero exp results in zero time, meaning no expiration.
+// The caller must hold mc lock.
+func (mc *memoryCache) expiry(exp time.Duration) time.Time {
+	if exp <= 0 {
+		return time.Time{}
+	}
+	return mc.now().Add(exp)
+}
+
+// lookup returns an unexpired item stored under key and marks it as recently used.
+// Expired items are removed. It returns nil if no item is found.
+// The caller must hold mc lock.
+func (mc *memoryCache) lookup(key string) *memoryCacheItem {
+	e, ok := mc.items[key]
+	if !ok {
+		return nil
+	}
+	item := e.Value.(*memoryCacheItem)
+	if !item.exp.IsZero() && !mc.now().Before(item.exp) {
+		mc.remove(e)
+		return nil
+	}
+	mc.ll.MoveToFront(e)
+	return item
+}
+
+// put stores data under key and evicts least recently used items
+// if the cache exceeds its size.
+// The caller must hold mc lock.
+func (mc *memoryCache) put(key string, data []byte, exp time.Time) {
+	if e, ok := mc.items[key]; ok {
+		item := e.Value.(*memoryCacheItem)
+		item.data, item.exp = data, exp
+		mc.ll.MoveToFront(e)
+		return
+	}
+	item := &memoryCacheItem{key: key, data: data, exp: exp}
+	mc.items[key] = mc.ll.PushFront(item)
+	for mc.ll.Len() > mc.size {
+		mc.remove(mc.ll.Back())
+	}
+}
+
+// remove deletes element e from the cache.
+// The caller must hold mc lock.
+func (mc *memoryCache) remove(e *list.Element) {
+	mc.ll.Remove(e)
+	delete(mc.items, e.Value.(*memoryCacheItem).key)
+}
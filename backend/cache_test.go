@@ -0,0 +1,214 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestMemoryCacheSetGet(t *testing.T) {
+	t.Parallel()
+	c := context.Background()
+	now := time.Now()
+	mc := newMemoryCache(10)
+	mc.now = func() time.Time { return now }
+
+	if err := mc.set(c, "forever", []byte("one"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := mc.set(c, "short", []byte("two"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := mc.get(c, "forever"); err != nil || string(b) != "one" {
+		t.Errorf("get(forever) = %q, %v; want 'one', nil", b, err)
+	}
+	if b, err := mc.get(c, "short"); err != nil || string(b) != "two" {
+		t.Errorf("get(short) = %q, %v; want 'two', nil", b, err)
+	}
+	if _, err := mc.get(c, "missing"); err != errCacheMiss {
+		t.Errorf("get(missing) err = %v; want errCacheMiss", err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := mc.get(c, "short"); err != errCacheMiss {
+		t.Errorf("get(short) err = %v; want errCacheMiss after expiration", err)
+	}
+	if _, err := mc.get(c, "forever"); err != nil {
+		t.Errorf("get(forever): %v", err)
+	}
+}
+
+func TestMemoryCacheInc(t *testing.T) {
+	t.Parallel()
+	c := context.Background()
+	mc := newMemoryCache(10)
+
+	table := []struct {
+		key     string
+		delta   int64
+		initial uint64
+		out     uint64
+	}{
+		{"a", 1, 0, 1},
+		{"a", 1, 0, 2},
+		{"a", -1, 0, 1},
+		{"a", -10, 0, 0},
+		{"b", 5, 10, 15},
+		{"c", 1, math.MaxUint64, 0},
+	}
+	for i, test := range table {
+		v, err := mc.inc(c, test.key, test.delta, test.initial)
+		if err != nil {
+			t.Errorf("%d: inc(%q, %d, %d): %v", i, test.key, test.delta, test.initial, err)
+			continue
+		}
+		if v != test.out {
+			t.Errorf("%d: inc(%q, %d, %d) = %d; want %d", i, test.key, test.delta, test.initial, v, test.out)
+		}
+	}
+
+	if b, err := mc.get(c, "b"); err != nil || string(b) != "15" {
+		t.Errorf("get(b) = %q, %v; want '15', nil", b, err)
+	}
+	mc.set(c, "nan", []byte("not a number"), 0)
+	if _, err := mc.inc(c, "nan", 1, 0); err != errCacheNotNumber {
+		t.Errorf("inc(nan) err = %v; want errCacheNotNumber", err)
+	}
+}
+
+func TestMemoryCacheEvict(t *testing.T) {
+	t.Parallel()
+	c := context.Background()
+	mc := newMemoryCache(2)
+	mc.set(c, "one", []byte("1"), 0)
+	mc.set(c, "two", []byte("2"), 0)
+	// make "one" recently used
+	mc.get(c, "one")
+	mc.set(c, "three", []byte("3"), 0)
+
+	if _, err := mc.get(c, "two"); err != errCacheMiss {
+		t.Errorf("get(two) err = %v; want errCacheMiss", err)
+	}
+	for _, k := range []string{"one", "three"} {
+		if _, err := mc.get(c, k); err != nil {
+			t.Errorf("get(%q): %v", k, err)
+		}
+	}
+}
+
+func TestMemoryCacheDelete(t *testing.T) {
+	t.Parallel()
+	c := context.Background()
+	mc := newMemoryCache(10)
+	for _, k := range []string{"one", "two", "three"} {
+		mc.set(c, k, []byte(k), 0)
+	}
+
+	if err := mc.deleteMulti(c, []string{"one", "two", "missing"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"one", "two"} {
+		if _, err := mc.get(c, k); err != errCacheMiss {
+			t.Errorf("get(%q) err = %v; want errCacheMiss", k, err)
+		}
+	}
+	if _, err := mc.get(c, "three"); err != nil {
+		t.Errorf("get(three): %v", err)
+	}
+
+	if err := mc.flush(c); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mc.get(c, "three"); err != errCacheMiss {
+		t.Errorf("get(three) err = %v; want errCacheMiss", err)
+	}
+}
+
+func TestMemoryCacheConcurrentInc(t *testing.T) {
+	t.Parallel()
+	c := context.Background()
+	mc := newMemoryCache(10)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mc.inc(c, "counter", 1, 0)
+		}()
+	}
+	wg.Wait()
+	if b, err := mc.get(c, "counter"); err != nil || string(b) != "100" {
+		t.Errorf("get(counter) = %q, %v; want '100', nil", b, err)
+	}
+}
+
+func TestCachedBlob(t *testing.T) {
+	defer func(c cacheInterface) { cache = c }(cache)
+	mc := newMemoryCache(100)
+	cache = mc
+	c := context.Background()
+
+	small := []byte("small")
+	large := bytes.Repeat([]byte("0123456789"), blobPartSize/4)
+	for _, b := range [][]byte{small, large} {
+		if err := setCachedBlob(c, "blob", b, 0); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := getCachedBlob(c, "blob"); err != nil || !bytes.Equal(v, b) {
+			t.Errorf("getCachedBlob(%d bytes) = %d bytes, %v; want the same blob", len(b), len(v), err)
+		}
+	}
+	if n := len(mc.items); n != 4 {
+		t.Errorf("len(mc.items) = %d; want 4: the blob and its 3 parts", n)
+	}
+
+	mc.deleteMulti(c, []string{cachedBlobPartKey("blob", blobSum(large), 1)})
+	if _, err := getCachedBlob(c, "blob"); err != errCacheMiss {
+		t.Errorf("getCachedBlob(missing part) err = %v; want errCacheMiss", err)
+	}
+	mc.set(c, "raw", []byte("not a blob"), 0)
+	if _, err := getCachedBlob(c, "raw"); err != errCacheMiss {
+		t.Errorf("getCachedBlob(raw) err = %v; want errCacheMiss", err)
+	}
+
+	// concurrent writers of different blobs under the same key
+	other := bytes.Repeat([]byte("abcdefghij"), blobPartSize/4)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		b := large
+		if i%2 == 1 {
+			b = other
+		}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			setCachedBlob(c, "blob", b, 0)
+		}()
+		go func() {
+			defer wg.Done()
+			v, err := getCachedBlob(c, "blob")
+			if err == nil && !bytes.Equal(v, large) && !bytes.Equal(v, other) {
+				t.Errorf("getCachedBlob: got a mix of concurrently written blobs")
+			}
+		}()
+	}
+	wg.Wait()
+}
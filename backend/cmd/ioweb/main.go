@@ -0,0 +1,64 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !appengine
+
+// This program runs the backend as a standalone server, outside of App Engine.
+// It must be started from the backend dir so that relative paths
+// in server.config, like "dir", are resolved correctly:
+// cd backend && go run cmd/ioweb/main.go -addr :8080
+//
+// To validate server config and HTTP/2 preload manifest without starting
+// the server, run:
+// cd backend && go run cmd/ioweb/main.go -config server.config config check
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/GoogleChrome/ioweb2016/backend"
+)
+
+var (
+	// flags
+	configPath = flag.String("config", "server.config", "server config file path")
+	addr       = flag.String("addr", "", "address to listen on; overrides config addr")
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() > 0 {
+		runCommand(flag.Args())
+		return
+	}
+	if err := backend.ListenAndServe(*configPath, *addr); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runCommand executes a subcommand specified in args and exits.
+func runCommand(args []string) {
+	switch {
+	case len(args) == 2 && args[0] == "config" && args[1] == "check":
+		if !backend.CheckConfig(os.Stdout, *configPath) {
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q; supported: config check\n", args)
+		os.Exit(2)
+	}
+}
@@ -16,27 +16,61 @@ package backend
 
 import (
 	"encoding/json"
+	"fmt"
 	"hash/crc32"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/http2preload"
 )
 
 var (
-	// config is a global backend config,
+	// currentConfig holds *appConfig the backend is currently configured with,
 	// usually obtained by reading a server config file in an init() func.
-	config appConfig
+	// It is swapped as a whole on reload, see config and setConfig funcs.
+	currentConfig atomic.Value
 
-	// h2config is HTTP/2 preload manifest.
-	// It is initialized alongside config but from a separate file
-	// because it doesn't need to be encrypted.
-	h2config http2preload.Manifest
+	// configFile is the server config file path the config was initialized from.
+	configFile string
 )
 
+func init() {
+	currentConfig.Store(&appConfig{})
+}
+
+// config returns the current backend config.
+// The returned value must be treated as read-only: reloadConfig doesn't modify it
+// but swaps it for a new one, so code which needs several values consistent
+// with each other, like a request handler, should call config once and keep the result.
+func config() *appConfig {
+	return currentConfig.Load().(*appConfig)
+}
+
+// setConfig makes cfg the current backend config.
+func setConfig(cfg *appConfig) {
+	currentConfig.Store(cfg)
+}
+
+// configDuration is a time.Duration which appears in the config as a string, e.g. "10s".
+type configDuration time.Duration
+
+func (d *configDuration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = configDuration(v)
+	return nil
+}
+
+func (d configDuration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
 // isDev returns true if current app environment is in a dev mode.
 func isDev() bool {
 	return !isStaging() && !isProd()
@@ -44,12 +78,12 @@ func isDev() bool {
 
 // isStaging returns true if current app environment is "stage".
 func isStaging() bool {
-	return config.Env == "stage"
+	return config().Env == "stage"
 }
 
 // isProd returns true if current app environment is "prod".
 func isProd() bool {
-	return config.Env == "prod"
+	return config().Env == "prod"
 }
 
 // isDevServer returns true if the app is currently running in a dev server.
@@ -61,6 +95,8 @@ func isDevServer() bool {
 }
 
 // appConfig defines the backend config file structure.
+// Fields tagged with secret:"true" are masked when rendered, see appConfig.masked.
+// Any field can be overridden by an environment variable or a file, see overrideConfig.
 type appConfig struct {
 	// App environment: dev, stage or prod
 	Env string
@@ -68,22 +104,29 @@ type appConfig struct {
 	Dir string
 	// Standalone server address to listen on
 	Addr string
+	// Standalone server data file; data is kept only in memory if empty
+	DataFile string
 	// HTTP prefix
 	Prefix string
 
 	// User emails allowed in staging
 	Whitelist []string
+	// Emails or @domains granted viewer, operator or admin role
+	// to access debug and admin handlers
+	Roles map[string][]string
 	// I/O Extended events feed
 	IoExtFeedURL string `json:"ioExtFeedUrl"`
 	// A shared secret to identify requests from GCS and gdrive
-	SyncToken string `json:"synct"`
+	SyncToken string `json:"synct" secret:"true"`
+	// A shared secret to authorize admin requests, e.g. config reload
+	AdminToken string `json:"adminToken" secret:"true"`
 
 	// Twitter credentials
 	Twitter struct {
 		Accounts    []string
 		Filter      string
 		Key         string
-		Secret      string
+		Secret      string `secret:"true"`
 		TokenURL    string `json:"tokenUrl"`
 		TimelineURL string `json:"timelineUrl"`
 	}
@@ -92,15 +135,21 @@ type appConfig struct {
 	Google struct {
 		TokenURL       string `json:"tokenUrl"`
 		ServiceAccount struct {
-			Key   string `json:"private_key"`
+			Key   string `json:"private_key" secret:"true"`
 			Email string `json:"client_email"`
 		}
+		// OpenID Connect login for the whitelist
 		Auth struct {
-			Client string
+			Client   string
+			Secret   string `secret:"true"`
+			AuthURL  string `json:"authUrl"`
+			TokenURL string `json:"tokenUrl"`
+			// session cookies signing key
+			CookieKey string `json:"cookieKey" secret:"true"`
 		}
 		GCM struct {
 			Sender   string
-			Key      string
+			Key      string `secret:"true"`
 			Endpoint string
 		} `json:"gcm"`
 	}
@@ -111,11 +160,28 @@ type appConfig struct {
 		Timezone    string
 		Location    *time.Location
 		ManifestURL string `json:"manifest"`
+
+		// Data files fetching of a sync, see fetchEventData.
+		// Zero values mean defaults.
+		FetchConcurrency int            `json:"fetchConcurrency"`
+		FetchRetries     int            `json:"fetchRetries"`
+		FetchTimeout     configDuration `json:"fetchTimeout"`
+		// What to do when a data file fails to fetch:
+		// chunkErrorFail (default) or chunkErrorKeepLast
+		OnChunkError string `json:"onChunkError"`
+
+		// Max number of open /api/v1/schedule/stream connections per server instance.
+		// Zero means defaultStreamConns.
+		StreamConns int `json:"streamConns"`
+
+		// Push new sessions to all subscribers, regardless of their bookmarks.
+		// Off by default.
+		NotifyAdded bool `json:"notifyAdded"`
 	}
 
 	// Firebase settings
 	Firebase struct {
-		Secret string
+		Secret string `secret:"true"`
 		Shards []string
 	}
 
@@ -123,7 +189,7 @@ type appConfig struct {
 	Survey struct {
 		ID       string `json:"id"`
 		Endpoint string
-		Key      string
+		Key      string `secret:"true"`
 		Reg      string
 		// Session IDs map
 		Smap map[string]string
@@ -134,48 +200,102 @@ type appConfig struct {
 		Q4      string
 		Answers []string // valid answer values
 	}
+
+	// HTTP/2 preload manifest.
+	// It is read alongside the config but from a separate file
+	// because it doesn't need to be encrypted.
+	h2preload http2preload.Manifest
 }
 
-// initConfig reads server config file into the config global var.
+// initConfig reads server config file and makes it the current config.
 // Args provided to this func take precedence over config file values.
 func initConfig(configPath, addr string) error {
-	file, err := os.Open(configPath)
+	cfg, err := readConfig(configPath)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	if err := json.NewDecoder(file).Decode(&config); err != nil {
-		return err
+	if addr != "" {
+		cfg.Addr = addr
+	}
+	// init http/2 preload manifest even if the file doesn't exist
+	if cfg.h2preload, err = http2preload.ReadManifest(h2preloadPath(configPath)); err != nil {
+		cfg.h2preload = http2preload.Manifest{}
+	}
+	setConfig(cfg)
+	configFile = configPath
+	return nil
+}
+
+// readConfig reads server config file into a new appConfig
+// and normalizes its values.
+// Unlike initConfig, it doesn't modify any global vars.
+func readConfig(configPath string) (*appConfig, error) {
+	cfg, err := decodeConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := normalizeConfig(cfg); err != nil {
+		return nil, err
 	}
-	if config.Schedule.Location, err = time.LoadLocation(config.Schedule.Timezone); err != nil {
+	return cfg, nil
+}
+
+// normalizeConfig loads schedule location and brings cfg values
+// to the form expected by the rest of the app.
+func normalizeConfig(cfg *appConfig) error {
+	var err error
+	if cfg.Schedule.Location, err = time.LoadLocation(cfg.Schedule.Timezone); err != nil {
 		return err
 	}
-	if addr != "" {
-		config.Addr = addr
+	if cfg.Prefix == "" || cfg.Prefix[0] != '/' {
+		cfg.Prefix = "/" + cfg.Prefix
 	}
-	if config.Prefix == "" || config.Prefix[0] != '/' {
-		config.Prefix = "/" + config.Prefix
+	sort.Strings(cfg.Whitelist)
+	for _, list := range cfg.Roles {
+		sort.Strings(list)
 	}
-	sort.Strings(config.Whitelist)
-	sort.Strings(config.Survey.Answers)
+	sort.Strings(cfg.Survey.Answers)
+	return nil
+}
 
-	// init http/2 preload manifest even if the file doesn't exist
+// decodeConfig decodes server config file as is, without any normalization,
+// and overlays its values with overrides from the environment.
+// Secrets dir is taken from secretsDirEnv environment variable.
+func decodeConfig(configPath string) (*appConfig, error) {
+	file, err := os.Open(configPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	cfg := &appConfig{}
+	if err := json.NewDecoder(file).Decode(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", configPath, err)
+	}
+	if err := overrideConfig(cfg, os.Getenv(secretsDirEnv), os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// h2preloadPath returns location of the HTTP/2 preload manifest file
+// relative to the server config file at configPath.
+func h2preloadPath(configPath string) string {
 	p := filepath.Dir(configPath)
 	if p != "." {
 		p = filepath.Join(p, "..")
 	}
-	p = filepath.Join(p, "h2preload.json")
-	if h2config, err = http2preload.ReadManifest(p); err != nil {
-		h2config = http2preload.Manifest{}
-	}
-
-	return nil
+	return filepath.Join(p, "h2preload.json")
 }
 
 // isWhitelisted returns true if either email or its domain is in the config.Whitelist.
 func isWhitelisted(email string) bool {
-	i := sort.SearchStrings(config.Whitelist, email)
-	if i < len(config.Whitelist) && config.Whitelist[i] == email {
+	return emailInList(config().Whitelist, email)
+}
+
+// emailInList returns true if either email or its domain is in the sorted list.
+func emailInList(list []string, email string) bool {
+	i := sort.SearchStrings(list, email)
+	if i < len(list) && list[i] == email {
 		return true
 	}
 	// no more checks can be done if this is a @domain
@@ -185,18 +305,19 @@ func isWhitelisted(email string) bool {
 		return false
 	}
 	// check the @domain of this email
-	return isWhitelisted(email[i:])
+	return emailInList(list, email[i:])
 }
 
 // firebaseShard returns shard URL for user uid.
 // The uid must by a google user ID, with google: prefix stripped.
 func firebaseShard(uid string) string {
-	n := len(config.Firebase.Shards)
+	shards := config().Firebase.Shards
+	n := len(shards)
 	if n == 0 {
 		return ""
 	}
 
 	v := crc32.ChecksumIEEE([]byte(uid))
 	i := int(v) % n
-	return config.Firebase.Shards[i]
+	return shards[i]
 }
@@ -0,0 +1,303 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/http2preload"
+)
+
+// configIssue is a problem found in a config field.
+// Fatal issues prevent the app from working correctly,
+// while others are only warnings.
+type configIssue struct {
+	field string
+	msg   string
+	fatal bool
+}
+
+func (ci *configIssue) String() string {
+	level := "WARN "
+	if ci.fatal {
+		level = "ERROR"
+	}
+	return fmt.Sprintf("%s %s: %s", level, ci.field, ci.msg)
+}
+
+// configIssues collects issues found by validateConfig.
+type configIssues []*configIssue
+
+// errorf adds a fatal issue of the field.
+func (ci *configIssues) errorf(field, format string, args ...interface{}) {
+	*ci = append(*ci, &configIssue{field, fmt.Sprintf(format, args...), true})
+}
+
+// warnf adds a non-fatal issue of the field.
+func (ci *configIssues) warnf(field, format string, args ...interface{}) {
+	*ci = append(*ci, &configIssue{field, fmt.Sprintf(format, args...), false})
+}
+
+// required adds an issue if v is empty. The issue is fatal unless in dev env.
+func (ci *configIssues) required(env, field, v string) {
+	if v != "" {
+		return
+	}
+	if env == "stage" || env == "prod" {
+		ci.errorf(field, "required in %s", env)
+		return
+	}
+	ci.warnf(field, "empty")
+}
+
+// url adds an issue if v is not an absolute URL with one of the schemes.
+// Empty values are checked with required.
+func (ci *configIssues) url(env, field, v string, schemes ...string) {
+	if v == "" {
+		ci.required(env, field, v)
+		return
+	}
+	u, err := url.Parse(v)
+	if err != nil {
+		ci.errorf(field, "%v", err)
+		return
+	}
+	if !u.IsAbs() || u.Host == "" && u.Scheme != "file" {
+		ci.errorf(field, "%q is not an absolute URL", v)
+		return
+	}
+	for _, s := range schemes {
+		if u.Scheme == s {
+			return
+		}
+	}
+	ci.errorf(field, "%q scheme must be one of %s", v, strings.Join(schemes, ", "))
+}
+
+// fatal returns the number of fatal issues.
+func (ci configIssues) fatal() int {
+	n := 0
+	for _, i := range ci {
+		if i.fatal {
+			n++
+		}
+	}
+	return n
+}
+
+// CheckConfig validates server config file at configPath
+// along with HTTP/2 preload manifest, and writes a report to w.
+// It returns false if any fatal issues have been found.
+func CheckConfig(w io.Writer, configPath string) bool {
+	cfg, err := decodeConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(w, "ERROR %v\n", err)
+		return false
+	}
+	issues := validateConfig(cfg)
+
+	p := h2preloadPath(configPath)
+	if _, err := os.Stat(p); err != nil {
+		issues.warnf("h2preload", "%v", err)
+	} else if _, err := http2preload.ReadManifest(p); err != nil {
+		issues.errorf("h2preload", "%s: %v", p, err)
+	}
+
+	for _, i := range issues {
+		fmt.Fprintln(w, i)
+	}
+	n := issues.fatal()
+	fmt.Fprintf(w, "%s (%s): %d errors, %d warnings\n", configPath, cfg.Env, n, len(issues)-n)
+	return n == 0
+}
+
+// validateConfig checks all sections of cfg for missing or malformed values.
+// Credentials and other external services settings are required only in stage and prod.
+// cfg is expected to be decoded as is, with no normalization applied.
+func validateConfig(cfg *appConfig) configIssues {
+	var issues configIssues
+	env := cfg.Env
+	switch env {
+	case "dev", "stage", "prod":
+		// ok
+	default:
+		issues.errorf("env", "%q must be one of dev, stage or prod", env)
+	}
+
+	// server
+	if cfg.Dir == "" {
+		issues.errorf("dir", "empty")
+	} else if fi, err := os.Stat(filepath.Join(cfg.Dir, templatesDir)); err != nil || !fi.IsDir() {
+		issues.errorf("dir", "%q has no %s dir", cfg.Dir, templatesDir)
+	}
+	if cfg.Addr != "" {
+		if _, _, err := net.SplitHostPort(cfg.Addr); err != nil {
+			issues.errorf("addr", "%v", err)
+		}
+	}
+	if p := cfg.Prefix; p != "" && p != "/" && strings.HasSuffix(p, "/") {
+		issues.errorf("prefix", "%q must not end with /", p)
+	}
+	for i, e := range cfg.Whitelist {
+		if j := strings.Index(e, "@"); j < 0 || j == len(e)-1 {
+			issues.errorf(fmt.Sprintf("whitelist[%d]", i), "%q is neither an email nor @domain", e)
+		}
+	}
+	for name, list := range cfg.Roles {
+		if _, ok := roleNames[name]; !ok {
+			issues.errorf("roles", "%q must be one of viewer, operator or admin", name)
+		}
+		for i, e := range list {
+			if j := strings.Index(e, "@"); j < 0 || j == len(e)-1 {
+				issues.errorf(fmt.Sprintf("roles.%s[%d]", name, i), "%q is neither an email nor @domain", e)
+			}
+		}
+	}
+	if len(cfg.Roles) > 0 && cfg.Google.Auth.CookieKey == "" {
+		issues.errorf("google.auth.cookieKey", "required by roles")
+	}
+	issues.url(env, "ioExtFeedUrl", cfg.IoExtFeedURL, "https")
+	issues.required(env, "synct", cfg.SyncToken)
+	if cfg.AdminToken == "" {
+		issues.warnf("adminToken", "empty; admin endpoints are disabled")
+	}
+
+	// twitter
+	if len(cfg.Twitter.Accounts) == 0 {
+		issues.warnf("twitter.accounts", "empty")
+	}
+	issues.required(env, "twitter.key", cfg.Twitter.Key)
+	issues.required(env, "twitter.secret", cfg.Twitter.Secret)
+	issues.url(env, "twitter.tokenUrl", cfg.Twitter.TokenURL, "https")
+	issues.url(env, "twitter.timelineUrl", cfg.Twitter.TimelineURL, "https")
+
+	// google
+	issues.url(env, "google.tokenUrl", cfg.Google.TokenURL, "https")
+	sa := cfg.Google.ServiceAccount
+	issues.required(env, "google.serviceAccount.private_key", sa.Key)
+	if sa.Key != "" && !strings.Contains(sa.Key, "PRIVATE KEY") {
+		issues.errorf("google.serviceAccount.private_key", "not a PEM encoded private key")
+	}
+	issues.required(env, "google.serviceAccount.client_email", sa.Email)
+	if sa.Email != "" && !strings.Contains(sa.Email, "@") {
+		issues.errorf("google.serviceAccount.client_email", "%q is not an email", sa.Email)
+	}
+	issues.required(env, "google.auth.client", cfg.Google.Auth.Client)
+	if auth := cfg.Google.Auth; len(cfg.Whitelist) > 0 {
+		// login is required to check the whitelist
+		issues.required("prod", "google.auth.secret", auth.Secret)
+		issues.required("prod", "google.auth.cookieKey", auth.CookieKey)
+		if auth.AuthURL != "" {
+			issues.url(env, "google.auth.authUrl", auth.AuthURL, "http", "https")
+		}
+		if auth.TokenURL != "" {
+			issues.url(env, "google.auth.tokenUrl", auth.TokenURL, "http", "https")
+		}
+	}
+	issues.required(env, "google.gcm.sender", cfg.Google.GCM.Sender)
+	issues.required(env, "google.gcm.key", cfg.Google.GCM.Key)
+	issues.url(env, "google.gcm.endpoint", cfg.Google.GCM.Endpoint, "https")
+
+	// schedule
+	if cfg.Schedule.Start.IsZero() {
+		issues.errorf("schedule.start", "empty")
+	}
+	if _, err := time.LoadLocation(cfg.Schedule.Timezone); err != nil || cfg.Schedule.Timezone == "" {
+		issues.errorf("schedule.timezone", "%q is not a valid location", cfg.Schedule.Timezone)
+	}
+	if m := cfg.Schedule.ManifestURL; isLocalManifest(m) {
+		if _, err := os.Stat(localManifestPath(m)); err != nil {
+			issues.errorf("schedule.manifest", "%v", err)
+		}
+	} else {
+		issues.url(env, "schedule.manifest", m, "http", "https")
+	}
+	if cfg.Schedule.FetchConcurrency < 0 {
+		issues.errorf("schedule.fetchConcurrency", "%d is negative", cfg.Schedule.FetchConcurrency)
+	}
+	if cfg.Schedule.FetchRetries < 0 {
+		issues.errorf("schedule.fetchRetries", "%d is negative", cfg.Schedule.FetchRetries)
+	}
+	if cfg.Schedule.FetchTimeout < 0 {
+		issues.errorf("schedule.fetchTimeout", "%s is negative", time.Duration(cfg.Schedule.FetchTimeout))
+	}
+	if cfg.Schedule.StreamConns < 0 {
+		issues.errorf("schedule.streamConns", "%d is negative", cfg.Schedule.StreamConns)
+	}
+	switch v := cfg.Schedule.OnChunkError; v {
+	case "", chunkErrorFail, chunkErrorKeepLast:
+		// ok
+	default:
+		issues.errorf("schedule.onChunkError", "%q must be one of %s, %s", v, chunkErrorFail, chunkErrorKeepLast)
+	}
+
+	// firebase
+	issues.required(env, "firebase.secret", cfg.Firebase.Secret)
+	if len(cfg.Firebase.Shards) == 0 {
+		issues.required(env, "firebase.shards", "")
+	}
+	// dev may point to a local emulator
+	schemes := []string{"https"}
+	if env != "stage" && env != "prod" {
+		schemes = append(schemes, "http")
+	}
+	seen := make(map[string]int, len(cfg.Firebase.Shards))
+	for i, s := range cfg.Firebase.Shards {
+		field := fmt.Sprintf("firebase.shards[%d]", i)
+		if j, ok := seen[s]; ok {
+			issues.errorf(field, "same as firebase.shards[%d]", j)
+			continue
+		}
+		seen[s] = i
+		issues.url(env, field, s, schemes...)
+	}
+
+	// survey
+	sv := cfg.Survey
+	issues.required(env, "survey.id", sv.ID)
+	issues.required(env, "survey.reg", sv.Reg)
+	issues.required(env, "survey.key", sv.Key)
+	issues.url(env, "survey.endpoint", sv.Endpoint, "https")
+	issues.required(env, "survey.q1", sv.Q1)
+	issues.required(env, "survey.q2", sv.Q2)
+	issues.required(env, "survey.q3", sv.Q3)
+	issues.required(env, "survey.q4", sv.Q4)
+	if len(sv.Answers) == 0 {
+		if env == "stage" || env == "prod" {
+			issues.errorf("survey.answers", "empty; all feedback submissions will be rejected")
+		} else {
+			issues.warnf("survey.answers", "empty; all feedback submissions will be rejected")
+		}
+	}
+	for i, a := range sv.Answers {
+		if a == "" {
+			issues.errorf(fmt.Sprintf("survey.answers[%d]", i), "empty")
+		}
+	}
+	for k, v := range sv.Smap {
+		if k == "" || v == "" {
+			issues.errorf("survey.smap", "%q: %q: empty session ID", k, v)
+		}
+	}
+
+	return issues
+}
@@ -0,0 +1,138 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// configEnvPrefix is the prefix of environment variables overriding appConfig fields.
+	configEnvPrefix = "IOWEB_"
+	// secretsDirEnv is the environment variable pointing to a dir
+	// with files overriding appConfig fields.
+	secretsDirEnv = configEnvPrefix + "SECRETS_DIR"
+	// maskedSecret replaces secret config values when rendered.
+	maskedSecret = "********"
+)
+
+// overrideConfig overlays cfg fields with values from files in secretsDir
+// and environment variables, in that order.
+//
+// Each field is identified by its dotted path as in server.config,
+// e.g. "firebase.secret" or "google.serviceAccount.private_key".
+// A file in secretsDir must be named exactly as the path, while environment variable
+// name is the path in upper case, with dots replaced by underscores
+// and prefixed with configEnvPrefix, e.g. IOWEB_FIREBASE_SECRET.
+//
+// String values are used as is, with trailing newlines of file contents trimmed.
+// Values of other types, e.g. firebase.shards, must be JSON encoded.
+// An empty secretsDir disables file overrides. lookupEnv is usually os.LookupEnv.
+func overrideConfig(cfg *appConfig, secretsDir string, lookupEnv func(string) (string, bool)) error {
+	return walkConfig(reflect.ValueOf(cfg).Elem(), "", func(path string, v reflect.Value, _ reflect.StructField) error {
+		if secretsDir != "" {
+			name := filepath.Join(secretsDir, path)
+			b, err := ioutil.ReadFile(name)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err == nil {
+				if err := setConfigValue(v, strings.TrimRight(string(b), "\r\n")); err != nil {
+					return fmt.Errorf("%s: %v", name, err)
+				}
+			}
+		}
+		name := configEnvPrefix + strings.ToUpper(strings.Replace(path, ".", "_", -1))
+		if s, ok := lookupEnv(name); ok {
+			if err := setConfigValue(v, s); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// masked returns a copy of c with all fields tagged as secret
+// replaced by maskedSecret, unless empty.
+// It must be used whenever config values are rendered, e.g. in debug pages.
+func (c appConfig) masked() appConfig {
+	walkConfig(reflect.ValueOf(&c).Elem(), "", func(_ string, v reflect.Value, f reflect.StructField) error {
+		if f.Tag.Get("secret") == "true" && v.Kind() == reflect.String && v.String() != "" {
+			v.SetString(maskedSecret)
+		}
+		return nil
+	})
+	return c
+}
+
+// walkConfig calls fn for each leaf field of the struct v, recursively,
+// with path being the dotted field path, as in server config file.
+// Pointer and unexported fields are skipped since they are not part of the file.
+func walkConfig(v reflect.Value, path string, fn func(path string, v reflect.Value, f reflect.StructField) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		p := configFieldName(f)
+		if path != "" {
+			p = path + "." + p
+		}
+		fv := v.Field(i)
+		var err error
+		switch {
+		case f.Type.Kind() == reflect.Ptr || f.PkgPath != "":
+			continue
+		case f.Type.Kind() == reflect.Struct && f.Type != reflect.TypeOf(time.Time{}):
+			err = walkConfig(fv, p, fn)
+		default:
+			err = fn(p, fv, f)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configFieldName returns the name of the field f as it appears in server config file:
+// either the json tag name or the field name with its first letter lower cased.
+func configFieldName(f reflect.StructField) string {
+	if n := strings.Split(f.Tag.Get("json"), ",")[0]; n != "" && n != "-" {
+		return n
+	}
+	r, n := utf8.DecodeRuneInString(f.Name)
+	return string(unicode.ToLower(r)) + f.Name[n:]
+}
+
+// setConfigValue sets v to s, decoding it according to the type of v.
+func setConfigValue(v reflect.Value, s string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+	if v.Kind() == reflect.String {
+		v.SetString(s)
+		return nil
+	}
+	return json.Unmarshal([]byte(s), v.Addr().Interface())
+}
@@ -0,0 +1,111 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	html "html/template"
+	"os"
+	"sync"
+
+	"github.com/google/http2preload"
+
+	"golang.org/x/net/context"
+)
+
+// allowConfigReload enables config reloads.
+// Only the standalone server supports it since GAE instances
+// can't be reloaded all at once.
+var allowConfigReload bool
+
+// reloadMu serializes reloadConfig calls.
+var reloadMu sync.Mutex
+
+// reloadConfig reads server config file the current config was initialized from,
+// validates it and atomically swaps the current config for the new one,
+// including HTTP/2 preload manifest.
+// The current config is kept if the new one has fatal issues or changes fields
+// which require a restart, like env, dir, dataFile or prefix.
+// Caches depending on the config values are cleared and schedule streams
+// are ended on success.
+//
+// It returns all issues found during validation, including non-fatal ones.
+// Concurrent calls are serialized by reloadMu.
+func reloadConfig(c context.Context) (configIssues, error) {
+	cfg, err := decodeConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+	issues := validateConfig(cfg)
+	p := h2preloadPath(configFile)
+	h2, err := http2preload.ReadManifest(p)
+	switch {
+	case os.IsNotExist(err):
+		h2 = http2preload.Manifest{}
+	case err != nil:
+		issues.errorf("h2preload", "%s: %v", p, err)
+	}
+	if n := issues.fatal(); n > 0 {
+		return issues, fmt.Errorf("%s: %d errors; keeping current config", configFile, n)
+	}
+	if err := normalizeConfig(cfg); err != nil {
+		return issues, err
+	}
+
+	cfg.h2preload = h2
+
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	cur := config()
+	restart := []struct{ field, old, new string }{
+		{"env", cur.Env, cfg.Env},
+		{"dir", cur.Dir, cfg.Dir},
+		{"dataFile", cur.DataFile, cfg.DataFile},
+		{"prefix", cur.Prefix, cfg.Prefix},
+	}
+	for _, f := range restart {
+		if f.old != f.new {
+			return issues, fmt.Errorf("%s: %q => %q requires restart; keeping current config", f.field, f.old, f.new)
+		}
+	}
+	// addr may come from a command line flag and can't change w/o restart anyway
+	cfg.Addr = cur.Addr
+	setConfig(cfg)
+
+	// open streams keep using the config they started with
+	streams.endAll()
+	tmplCache.Lock()
+	tmplCache.templates = make(map[string]*html.Template)
+	tmplCache.Unlock()
+	// social feed depends on twitter accounts
+	if err := cache.deleteMulti(c, allCachedSocialKeys); err != nil {
+		errorf(c, "reloadConfig: %v", err)
+	}
+	return issues, nil
+}
+
+// reloadConfigLog calls reloadConfig and logs the outcome.
+// It is used by non-HTTP triggers, e.g. a signal or a file change.
+func reloadConfigLog(c context.Context) {
+	issues, err := reloadConfig(c)
+	for _, i := range issues {
+		logf(c, "reloadConfig: %s", i)
+	}
+	if err != nil {
+		errorf(c, "reloadConfig: %v", err)
+		return
+	}
+	logf(c, "reloadConfig: reloaded %s", configFile)
+}
@@ -0,0 +1,253 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"gopkg.in/yaml.v2"
+)
+
+// cron is the scheduler of periodic jobs,
+// initialized by the standalone server's main().
+// It is nil on GAE, where cron.yaml is handled by App Engine.
+var cron *cronScheduler
+
+// cronJob is a job definition of cron.yaml.
+type cronJob struct {
+	Desc     string `yaml:"description"`
+	URL      string `yaml:"url"`
+	Schedule string `yaml:"schedule"`
+
+	every time.Duration
+
+	mu      sync.Mutex // guards fields below
+	running bool
+	lastRun time.Time
+	lastDur time.Duration
+	lastErr error
+}
+
+// cronJobStatus is a snapshot of the cronJob state.
+type cronJobStatus struct {
+	Desc     string    `json:"description"`
+	URL      string    `json:"url"`
+	Schedule string    `json:"schedule"`
+	Running  bool      `json:"running"`
+	LastRun  time.Time `json:"lastRun,omitempty"`
+	Duration string    `json:"duration,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// readCronConfig parses job definitions of cron.yaml file.
+// A $PREFIX$ in job URLs is replaced with prefix, as gulp does when generating cron.yaml.
+func readCronConfig(filename, prefix string) ([]*cronJob, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var data struct {
+		Cron []*cronJob `yaml:"cron"`
+	}
+	if err := yaml.Unmarshal(b, &data); err != nil {
+		return nil, fmt.Errorf("readCronConfig(%q): %v", filename, err)
+	}
+	if prefix == "/" {
+		prefix = ""
+	}
+	for _, j := range data.Cron {
+		if j.every, err = parseCronSchedule(j.Schedule); err != nil {
+			return nil, err
+		}
+		j.URL = strings.Replace(j.URL, "$PREFIX$", prefix, -1)
+	}
+	return data.Cron, nil
+}
+
+// parseCronSchedule converts cron.yaml schedule of "every N minutes|hours" form
+// into a time.Duration.
+func parseCronSchedule(s string) (time.Duration, error) {
+	f := strings.Fields(s)
+	if len(f) != 3 || f[0] != "every" {
+		return 0, fmt.Errorf("parseCronSchedule(%q): unsupported format", s)
+	}
+	n, err := strconv.Atoi(f[1])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("parseCronSchedule(%q): invalid number", s)
+	}
+	switch f[2] {
+	case "minute", "minutes", "min", "mins":
+		return time.Duration(n) * time.Minute, nil
+	case "hour", "hours":
+		return time.Duration(n) * time.Hour, nil
+	}
+	return 0, fmt.Errorf("parseCronSchedule(%q): unknown time unit", s)
+}
+
+// cronScheduler runs cron jobs periodically by calling handler h directly,
+// similar to how GAE Cron Service would make HTTP requests.
+// Runs of the same job never overlap.
+type cronScheduler struct {
+	jobs    []*cronJob
+	handler http.Handler
+	stopc   chan struct{} // closed to stop scheduling new runs
+}
+
+// newCronScheduler creates a new scheduler of jobs.
+// Call start to begin running them.
+func newCronScheduler(jobs []*cronJob, h http.Handler) *cronScheduler {
+	return &cronScheduler{jobs: jobs, handler: h, stopc: make(chan struct{})}
+}
+
+// start spawns a goroutine for each job which runs it every job.every interval.
+// Similar to GAE, the first run happens after the first interval has passed.
+func (s *cronScheduler) start() {
+	for _, j := range s.jobs {
+		go func(j *cronJob) {
+			tick := time.NewTicker(j.every)
+			defer tick.Stop()
+			for {
+				select {
+				case <-tick.C:
+					go s.run(j)
+				case <-s.stopc:
+					return
+				}
+			}
+		}(j)
+	}
+}
+
+// stop stops scheduling new runs. Runs in progress are not affected.
+func (s *cronScheduler) stop() {
+	close(s.stopc)
+}
+
+// wait waits for all runs in progress to finish, or until c is done.
+// It returns URLs of the jobs which are still running.
+func (s *cronScheduler) wait(c context.Context) []string {
+	for {
+		var running []string
+		for _, j := range s.jobs {
+			j.mu.Lock()
+			if j.running {
+				running = append(running, j.URL)
+			}
+			j.mu.Unlock()
+		}
+		if len(running) == 0 {
+			return nil
+		}
+		select {
+		case <-c.Done():
+			return running
+		case <-time.After(drainPollInterval):
+		}
+	}
+}
+
+// restore runs jobs with URLs returned by wait right away,
+// so that runs interrupted by a shutdown are not postponed until the next interval.
+func (s *cronScheduler) restore(urls []string) {
+	for _, u := range urls {
+		for _, j := range s.jobs {
+			if j.URL == u {
+				go s.run(j)
+			}
+		}
+	}
+}
+
+// run executes job j unless a previous run of j is still in progress.
+func (s *cronScheduler) run(j *cronJob) {
+	c := context.Background()
+	j.mu.Lock()
+	if j.running {
+		j.mu.Unlock()
+		logf(c, "cron %s: previous run is still in progress; skipping", j.URL)
+		return
+	}
+	j.running = true
+	j.mu.Unlock()
+
+	start := time.Now()
+	var err error
+	defer func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		j.running = false
+		j.lastRun = start
+		j.lastDur = time.Since(start)
+		j.lastErr = err
+	}()
+	if err = s.exec(j); err != nil {
+		errorf(c, "cron %s: %v", j.URL, err)
+	}
+}
+
+// exec makes a GET request to job j URL with x-appengine-cron header set.
+// It returns an error if the handler responds with a non-2xx status code or panics.
+func (s *cronScheduler) exec(j *cronJob) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("panic: %v\n%s", v, debug.Stack())
+		}
+	}()
+	r, err := http.NewRequest("GET", j.URL, nil)
+	if err != nil {
+		return err
+	}
+	r.Host = config().Addr
+	r.RemoteAddr = "0.1.0.1:0"
+	r.Header.Set("X-AppEngine-Cron", "true")
+	w := &taskResponse{header: make(http.Header)}
+	s.handler.ServeHTTP(w, r)
+	if w.code != 0 && (w.code < 200 || w.code > 299) {
+		return fmt.Errorf("%d %s", w.code, http.StatusText(w.code))
+	}
+	return nil
+}
+
+// status returns current state of all jobs.
+func (s *cronScheduler) status() []*cronJobStatus {
+	res := make([]*cronJobStatus, len(s.jobs))
+	for i, j := range s.jobs {
+		j.mu.Lock()
+		st := &cronJobStatus{
+			Desc:     j.Desc,
+			URL:      j.URL,
+			Schedule: j.Schedule,
+			Running:  j.running,
+			LastRun:  j.lastRun,
+		}
+		if !j.lastRun.IsZero() {
+			st.Duration = j.lastDur.String()
+		}
+		if j.lastErr != nil {
+			st.Error = j.lastErr.Error()
+		}
+		j.mu.Unlock()
+		res[i] = st
+	}
+	return res
+}
@@ -0,0 +1,137 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadCronConfig(t *testing.T) {
+	t.Parallel()
+	jobs, err := readCronConfig("cron.yaml.template", "/myprefix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]time.Duration{
+		"/myprefix/api/v1/extended?refresh": time.Hour,
+		"/myprefix/task/social":             8 * time.Minute,
+		"/myprefix/sync/gcs":                30 * time.Minute,
+		"/myprefix/task/wipeout":            24 * time.Hour,
+		"/myprefix/task/clock":              time.Minute,
+	}
+	if len(jobs) != len(want) {
+		t.Errorf("len(jobs) = %d; want %d", len(jobs), len(want))
+	}
+	for _, j := range jobs {
+		if d, ok := want[j.URL]; !ok || j.every != d {
+			t.Errorf("%s: every = %s; want %s", j.URL, j.every, d)
+		}
+	}
+}
+
+func TestParseCronSchedule(t *testing.T) {
+	t.Parallel()
+	table := []struct {
+		in  string
+		out time.Duration
+	}{
+		{"every 1 minutes", time.Minute},
+		{"every 8 minutes", 8 * time.Minute},
+		{"every 5 mins", 5 * time.Minute},
+		{"every 24 hours", 24 * time.Hour},
+	}
+	for _, test := range table {
+		d, err := parseCronSchedule(test.in)
+		if err != nil {
+			t.Errorf("parseCronSchedule(%q): %v", test.in, err)
+		}
+		if d != test.out {
+			t.Errorf("parseCronSchedule(%q) = %s; want %s", test.in, d, test.out)
+		}
+	}
+	for _, s := range []string{"", "every day 00:00", "every 0 minutes", "every 5 days"} {
+		if _, err := parseCronSchedule(s); err == nil {
+			t.Errorf("parseCronSchedule(%q): want error", s)
+		}
+	}
+}
+
+func TestCronSchedulerRun(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	calls := 0
+	release := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := r.Header.Get("x-appengine-cron"); v != "true" {
+			t.Errorf("x-appengine-cron = %q; want true", v)
+		}
+		if r.URL.Path != "/task/clock" || r.URL.RawQuery != "now" {
+			t.Errorf("r.URL = %s; want /task/clock?now", r.URL)
+		}
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		<-release
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	job := &cronJob{URL: "/task/clock?now", every: time.Minute}
+	s := newCronScheduler([]*cronJob{job}, h)
+
+	done := make(chan struct{})
+	go func() {
+		s.run(job)
+		close(done)
+	}()
+	for !s.status()[0].Running {
+		time.Sleep(time.Millisecond)
+	}
+	// must not overlap with the one in progress
+	s.run(job)
+	close(release)
+	<-done
+
+	if calls != 1 {
+		t.Errorf("calls = %d; want 1", calls)
+	}
+	st := s.status()[0]
+	if st.Running || st.LastRun.IsZero() || st.Error == "" {
+		t.Errorf("status = %+v; want finished run with an error", st)
+	}
+}
+
+func TestCronSchedulerRunPanic(t *testing.T) {
+	t.Parallel()
+	calls := 0
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		panic("test panic")
+	})
+	job := &cronJob{URL: "/task/clock", every: time.Minute}
+	s := newCronScheduler([]*cronJob{job}, h)
+	for i := 0; i < 2; i++ {
+		s.run(job)
+	}
+	if calls != 2 {
+		t.Errorf("calls = %d; want 2", calls)
+	}
+	st := s.status()[0]
+	if st.Running || !strings.Contains(st.Error, "test panic") {
+		t.Errorf("status = %+v; want finished run with a panic error", st)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"crypto/md5"
 	"encoding/gob"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -25,27 +26,46 @@ import (
 	"time"
 
 	"golang.org/x/net/context"
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/datastore"
 )
 
 const (
 	kindEventData = "EventData"
 	kindChanges   = "Changes"
 	kindNext      = "Next"
+	kindReport    = "ValidationReport"
+	kindSequences = "SessionSequences"
+	kindBlobPart  = "BlobPart"
+
+	// eventDataTimeCacheKey is the cache key prefix of getEventDataTime results,
+	// followed by an etag.
+	eventDataTimeCacheKey     = "etag:ts:"
+	eventDataTimeCacheTimeout = 24 * time.Hour
 )
 
 type eventDataCache struct {
 	Etag      string    `datastore:"-"`
 	Timestamp time.Time `datastore:"ts"`
 	Bytes     []byte    `datastore:"data"`
+	// Parts and Sum are set when Bytes don't fit into a single entity; see putBlob.
+	Parts int    `datastore:"parts,noindex"`
+	Sum   string `datastore:"sum,noindex"`
+	// Number of items in Bytes, so that versions can be listed w/o decoding them.
+	Sessions int `datastore:"sessions,noindex"`
+	Speakers int `datastore:"speakers,noindex"`
+	Videos   int `datastore:"videos,noindex"`
+	Tags     int `datastore:"tags,noindex"`
 }
 
-// RunInTransaction runs f in a transaction.
+// runInTransaction runs f in a store transaction.
 // It calls f with a transaction context tc that f should use for all operations.
-func runInTransaction(c context.Context, f func(context.Context) error) error {
-	opts := &datastore.TransactionOptions{XG: true}
-	return datastore.RunInTransaction(c, f, opts)
+// Schedule streams are woken up once the transaction commits,
+// since changes stored by f aren't visible until then.
+func runInTransaction(c context.Context, f func(tc context.Context) error) error {
+	if err := store.runInTransaction(c, f); err != nil {
+		return err
+	}
+	streams.notify()
+	return nil
 }
 
 // TODO: port to firebase
@@ -161,27 +181,28 @@ func listAllUserSessions(c context.Context, shard string) (map[string][]string,
 	return userSessions, nil
 }
 
-// storeEventData saves d in the datastore with auto-generated ID
-// and a common ancestor provided by eventDataParent().
+// storeEventData saves d in the store with auto-generated etag, which is set in d.etag.
 // All fields are unindexed except for d.modified.
 // Unexported fields other than d.modified are not stored.
+// Large data is split across multiple entities by the store; see putBlob.
 func storeEventData(c context.Context, d *eventData) error {
 	perr := prefixedErr("storeEventData")
 	var b bytes.Buffer
 	if err := gob.NewEncoder(&b).Encode(d); err != nil {
 		return perr(err)
 	}
-	// TODO: handle a case where b.Bytes() is > 1Mb
 	ent := &eventDataCache{
 		Timestamp: d.modified,
 		Bytes:     b.Bytes(),
+		Sessions:  len(d.Sessions),
+		Speakers:  len(d.Speakers),
+		Videos:    len(d.Videos),
+		Tags:      len(d.Tags),
 	}
-	key := datastore.NewIncompleteKey(c, kindEventData, eventDataParent(c))
-	key, err := datastore.Put(c, key, ent)
-	if err != nil {
+	if err := store.putEventData(c, ent); err != nil {
 		return perr(err)
 	}
-	ent.Etag = hexKey(key)
+	d.etag = ent.Etag
 	cache.deleteMulti(c, allCachedEventDataKeys)
 	return nil
 }
@@ -191,18 +212,14 @@ func clearEventData(c context.Context) error {
 	if err := cache.flush(c); err != nil {
 		return err
 	}
-	q := datastore.NewQuery(kindEventData).
-		Ancestor(eventDataParent(c)).
-		KeysOnly()
-	keys, err := q.GetAll(c, nil)
-	if err != nil {
+	if err := store.clearEventData(c); err != nil {
 		return fmt.Errorf("clearEventData: %v", err)
 	}
-	return datastore.DeleteMulti(c, keys)
+	return nil
 }
 
 func getCachedEventData(c context.Context) (*eventDataCache, error) {
-	b, err := cache.get(c, cachedEventDataKey)
+	b, err := getCachedBlob(c, cachedEventDataKey)
 	if err != nil {
 		return nil, err
 	}
@@ -215,7 +232,7 @@ func cacheEventData(c context.Context, d *eventDataCache) error {
 	if err := gob.NewEncoder(&b).Encode(d); err != nil {
 		return err
 	}
-	return cache.set(c, cachedEventDataKey, b.Bytes(), 1*time.Hour)
+	return setCachedBlob(c, cachedEventDataKey, b.Bytes(), 1*time.Hour)
 }
 
 // getLatestEventData fetches most recent version of eventData previously saved with storeEventData().
@@ -225,39 +242,87 @@ func cacheEventData(c context.Context, d *eventDataCache) error {
 // along with errNotModified error.
 //
 // This func guarantees for the returned eventData to have a non-zero value etag,
-// unless no entities exist in the datastore.
+// unless no entities exist in the store.
 func getLatestEventData(c context.Context, etags []string) (*eventData, error) {
 	res, err := getCachedEventData(c)
 	if err != nil {
-		q := datastore.NewQuery(kindEventData).
-			Ancestor(eventDataParent(c)).
-			Order("-ts").
-			Limit(1)
-		var dbres []*eventDataCache
-		keys, err := q.GetAll(c, &dbres)
+		res, err = store.latestEventData(c)
+		if err == errNotFound {
+			return &eventData{}, nil
+		}
 		if err != nil {
 			return nil, err
 		}
-		if len(keys) == 0 {
-			return &eventData{}, nil
-		}
-		res = dbres[0]
-		res.Etag = hexKey(keys[0])
 		if err := cacheEventData(c, res); err != nil {
 			errorf(c, "getLatestEventData: %v", err)
 		}
 	}
 
-	data := &eventData{
-		etag:     res.Etag,
-		modified: res.Timestamp,
-	}
 	for _, t := range etags {
-		if data.etag == strings.Trim(t, `"`) {
-			return data, errNotModified
+		if res.Etag == strings.Trim(t, `"`) {
+			return &eventData{etag: res.Etag, modified: res.Timestamp}, errNotModified
 		}
 	}
-	return data, gob.NewDecoder(bytes.NewReader(res.Bytes)).Decode(data)
+	return decodeEventData(res)
+}
+
+// getEventDataVersions returns at most limit most recent versions of eventData
+// previously saved with storeEventData(), latest first.
+// The first version is the one returned by getLatestEventData.
+// Event data itself is not loaded: only Etag, Timestamp and item counts are set.
+func getEventDataVersions(c context.Context, limit int) ([]*eventDataCache, error) {
+	return store.eventDataVersions(c, limit)
+}
+
+// getEventDataVersion returns a version of eventData identified by etag,
+// or errNotFound if there's no such version.
+func getEventDataVersion(c context.Context, etag string) (*eventData, error) {
+	ent, err := store.eventDataVersion(c, etag)
+	if err != nil {
+		return nil, err
+	}
+	return decodeEventData(ent)
+}
+
+// getEventDataTime returns modification time of the version of eventData identified by etag,
+// or errNotFound if there's no such version. Unlike getEventDataVersion, it doesn't load
+// the data itself. Since versions never change, the result is cached.
+func getEventDataTime(c context.Context, etag string) (time.Time, error) {
+	key := eventDataTimeCacheKey + etag
+	var t time.Time
+	if b, err := cache.get(c, key); err == nil && t.UnmarshalBinary(b) == nil {
+		return t, nil
+	}
+	t, err := store.eventDataTime(c, etag)
+	if err != nil {
+		return t, err
+	}
+	b, err := t.MarshalBinary()
+	if err == nil {
+		err = cache.set(c, key, b, eventDataTimeCacheTimeout)
+	}
+	if err != nil {
+		errorf(c, "getEventDataTime: cache.set(%q): %v", key, err)
+	}
+	return t, nil
+}
+
+// isEtag reports whether s is in the format of eventData etags: a hex encoded MD5 sum.
+func isEtag(s string) bool {
+	if len(s) != 2*md5.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// decodeEventData decodes eventData stored in ent by storeEventData.
+func decodeEventData(ent *eventDataCache) (*eventData, error) {
+	data := &eventData{
+		etag:     ent.Etag,
+		modified: ent.Timestamp,
+	}
+	return data, gob.NewDecoder(bytes.NewReader(ent.Bytes)).Decode(data)
 }
 
 // getSessionByID returns the session from getLatestEventData() if it exists,
@@ -269,52 +334,113 @@ func getSessionByID(c context.Context, id string) (*eventSession, error) {
 	}
 	s, ok := d.Sessions[id]
 	if !ok {
-		err = datastore.ErrNoSuchEntity
+		err = errNotFound
 	}
 	return s, err
 }
 
-// storeChanges saves d in the datastore with auto-generated ID
-// and a common ancestor provided by changesParent().
+// storeChanges saves d in the store change log
+// and counts its details updates in the session sequences, see getSessionSequences.
 // All fields are unindexed except for d.Changed.
 // Even though d.Token is stored, its value must not be used when
-// retrieved from the datastore later on.
+// retrieved from the store later on.
 func storeChanges(c context.Context, d *dataChanges) error {
 	b, err := json.Marshal(d)
 	if err != nil {
 		return err
 	}
-	// TODO: handle a case where len(b) > 1Mb
-	ent := &struct {
-		Timestamp time.Time `datastore:"ts"`
-		Bytes     []byte    `datastore:"data"`
-	}{d.Updated, b}
-	key := datastore.NewIncompleteKey(c, kindChanges, changesParent(c))
-	_, err = datastore.Put(c, key, ent)
-	return err
+	var updated []string
+	for id, s := range d.Sessions {
+		if s.Update == updateDetails {
+			updated = append(updated, id)
+		}
+	}
+	if len(updated) > 0 {
+		// read before putChanges so that a count from the change log doesn't include d
+		seq, err := getSessionSequences(c)
+		if err != nil {
+			return err
+		}
+		for _, id := range updated {
+			seq[id]++
+		}
+		if err := storeSessionSequences(c, d.Updated, seq); err != nil {
+			return err
+		}
+	}
+	if err := store.putChanges(c, &changesEntity{Timestamp: d.Updated, Bytes: b}); err != nil {
+		return err
+	}
+	// streams of a rolled back transaction find nothing new
+	streams.notify()
+	return nil
 }
 
-// getChangesSince queries datastore for all changes occurred since time t
-// and returns them all combined in one dataChanges result.
-// In a case where multiple changes have been introduced in the same data items,
-// older changes will be overwritten by the most recent ones.
-// At most 1000 changes will be returned.
-// Resulting dataChanges.Changed time will be set to the most recent one.
-func getChangesSince(c context.Context, t time.Time) (*dataChanges, error) {
-	q := datastore.NewQuery(kindChanges).
-		Ancestor(changesParent(c)).
-		Filter("ts > ", t).
-		Order("ts").
-		Limit(1000)
+// storeValidationReport replaces the event data validation report with r.
+func storeValidationReport(c context.Context, r *validationReport) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return store.putReport(c, &reportEntity{Timestamp: r.Created, Bytes: b})
+}
 
-	var res []*struct {
-		Timestamp time.Time `datastore:"ts"`
-		Bytes     []byte    `datastore:"data"`
+// getValidationReport returns the report saved with storeValidationReport,
+// or errNotFound if event data has never been synced.
+func getValidationReport(c context.Context) (*validationReport, error) {
+	ent, err := store.latestReport(c)
+	if err != nil {
+		return nil, err
 	}
-	if _, err := q.GetAll(c, &res); err != nil {
+	r := &validationReport{}
+	return r, json.Unmarshal(ent.Bytes, r)
+}
+
+// getSessionSequences returns the number of details updates of each session
+// in the change log, as counted by storeChanges.
+// If the counts have never been stored, e.g. the change log was written before they were,
+// they are counted by reading the whole change log.
+func getSessionSequences(c context.Context) (map[string]int, error) {
+	seq := make(map[string]int)
+	ent, err := store.latestSequences(c)
+	if err == nil {
+		return seq, json.Unmarshal(ent.Bytes, &seq)
+	}
+	if err != errNotFound {
 		return nil, err
 	}
+	err = forEachChange(c, time.Time{}, func(dc *dataChanges) error {
+		for id, s := range dc.Sessions {
+			if s.Update == updateDetails {
+				seq[id]++
+			}
+		}
+		return nil
+	})
+	return seq, err
+}
+
+// storeSessionSequences replaces the session sequences with seq,
+// counted up to the change log entry at time t.
+func storeSessionSequences(c context.Context, t time.Time, seq map[string]int) error {
+	b, err := json.Marshal(seq)
+	if err != nil {
+		return err
+	}
+	return store.putSequences(c, &sequencesEntity{Timestamp: t, Bytes: b})
+}
+
+// changesPageSize is the number of change log entries forEachChange reads at once.
+var changesPageSize = 1000
 
+// getChangesSince queries the store for all changes occurred since time t
+// and returns them all combined in one dataChanges result.
+// In a case where multiple changes have been introduced in the same data items,
+// older changes will be overwritten by the most recent ones.
+// Resulting dataChanges.Changed time will be set to the most recent one.
+//
+// It returns errChangesPage if the change log can't be read in pages, see forEachChange.
+func getChangesSince(c context.Context, t time.Time) (*dataChanges, error) {
 	changes := &dataChanges{
 		Updated: t,
 		eventData: eventData{
@@ -323,81 +449,99 @@ func getChangesSince(c context.Context, t time.Time) (*dataChanges, error) {
 			Videos:   make(map[string]*eventVideo),
 		},
 	}
-	if len(res) == 0 {
-		return changes, nil
-	}
+	err := forEachChange(c, t, func(dc *dataChanges) error {
+		mergeChanges(changes, dc)
+		return nil
+	})
+	return changes, err
+}
 
-	for _, item := range res {
-		dc := &dataChanges{}
-		if err := json.Unmarshal(item.Bytes, dc); err != nil {
-			errorf(c, "getChangesSince: %v at ts = %s", err, item.Timestamp)
-			continue
+// forEachChange calls fn with each change log entry stored after time t,
+// in ascending order of their timestamps, until fn returns an error.
+// Updated field of each entry is set to the entry timestamp.
+// Entries which can't be decoded are logged and skipped.
+//
+// The change log is read in pages of changesPageSize entries. Since the next page
+// starts after the last timestamp of the previous one, entries sharing the last timestamp
+// are left for the next page. If all entries of a page share the same timestamp,
+// forEachChange returns errChangesPage rather than skip the rest of them.
+func forEachChange(c context.Context, t time.Time, fn func(dc *dataChanges) error) error {
+	for {
+		res, err := store.changesSince(c, t, changesPageSize)
+		if err != nil {
+			return err
 		}
-		mergeChanges(changes, dc)
+		more := len(res) == changesPageSize
+		if more {
+			last := res[len(res)-1].Timestamp
+			n := len(res)
+			for n > 0 && res[n-1].Timestamp.Equal(last) {
+				n--
+			}
+			if n == 0 {
+				return errChangesPage
+			}
+			res = res[:n]
+		}
+		for _, item := range res {
+			dc := &dataChanges{}
+			if err := json.Unmarshal(item.Bytes, dc); err != nil {
+				errorf(c, "forEachChange: %v at ts = %s", err, item.Timestamp)
+				continue
+			}
+			dc.Updated = item.Timestamp
+			if err := fn(dc); err != nil {
+				return err
+			}
+		}
+		if !more {
+			return nil
+		}
+		t = res[len(res)-1].Timestamp
 	}
-	return changes, nil
+}
+
+// changeLogCovers reports whether the change log has all changes of event data since t,
+// which is true if t is not before the oldest stored version of event data.
+func changeLogCovers(c context.Context, t time.Time) (bool, error) {
+	first, err := store.firstEventDataTime(c)
+	if err == errNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !t.Before(first), nil
 }
 
 // storeNextSessions saves IDs of items under kindNext entity kind,
 // keyed by "sessionID:eventSession.Update".
 func storeNextSessions(c context.Context, items []*eventSession) error {
-	pkey := nextSessionParent(c)
-	keys := make([]*datastore.Key, len(items))
-	for i, s := range items {
-		id := s.ID + ":" + s.Update
-		keys[i] = datastore.NewKey(c, kindNext, id, 0, pkey)
-	}
-	zeros := make([]struct{}, len(keys))
-	_, err := datastore.PutMulti(c, keys, zeros)
-	return err
+	return store.putNext(c, nextSessionKeys(items))
 }
 
 // filterNextSessions queries kindNext entities and returns a subset of items
-// containing only the elements not present in the datastore, previously saved with
+// containing only the elements not present in the store, previously saved with
 // storeNextSessions().
 func filterNextSessions(c context.Context, items []*eventSession) ([]*eventSession, error) {
-	pkey := nextSessionParent(c)
-	keys := make([]*datastore.Key, len(items))
-	for i, s := range items {
-		id := s.ID + ":" + s.Update
-		keys[i] = datastore.NewKey(c, kindNext, id, 0, pkey)
-	}
-	zeros := make([]struct{}, len(keys))
-	err := datastore.GetMulti(c, keys, zeros)
-	merr, ok := err.(appengine.MultiError)
-	if !ok && err != nil {
+	found, err := store.hasNext(c, nextSessionKeys(items))
+	if err != nil {
 		return nil, err
 	}
-	res := make([]*eventSession, 0, len(keys))
-	for i, e := range merr {
-		if e == nil {
-			continue
+	res := make([]*eventSession, 0, len(items))
+	for i, ok := range found {
+		if !ok {
+			res = append(res, items[i])
 		}
-		if e != datastore.ErrNoSuchEntity {
-			return nil, err
-		}
-		res = append(res, items[i])
 	}
 	return res, nil
 }
 
-// eventDataParent returns a common ancestor for all kindEventData entities.
-func eventDataParent(c context.Context) *datastore.Key {
-	return datastore.NewKey(c, kindEventData, "root", 0, nil)
-}
-
-// changesParent returns a common ancestor for all kindChanges entities.
-func changesParent(c context.Context) *datastore.Key {
-	return datastore.NewKey(c, kindChanges, "root", 0, nil)
-}
-
-// nextSessionParent returns a common ancestor for all kindNext session entities.
-func nextSessionParent(c context.Context) *datastore.Key {
-	return datastore.NewKey(c, kindNext, "session", 0, nil)
-}
-
-// hexKey returns a representation of a key k in base 16.
-// Useful for etags.
-func hexKey(k *datastore.Key) string {
-	return fmt.Sprintf("%x", md5.Sum([]byte(k.String())))
+// nextSessionKeys returns kindNext keys of items in "sessionID:eventSession.Update" form.
+func nextSessionKeys(items []*eventSession) []string {
+	keys := make([]string, len(items))
+	for i, s := range items {
+		keys[i] = s.ID + ":" + s.Update
+	}
+	return keys
 }
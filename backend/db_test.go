@@ -15,6 +15,7 @@
 package backend
 
 import (
+	"math/rand"
 	"testing"
 	"time"
 )
@@ -69,6 +70,69 @@ func TestStoreGetChanges(t *testing.T) {
 	}
 }
 
+func TestGetChangesSincePages(t *testing.T) {
+	defer func(n int) { changesPageSize = n }(changesPageSize)
+	defer resetTestState(t)
+	changesPageSize = 3
+	c := newContext(newTestRequest(t, "GET", "/", nil))
+	now := time.Now()
+
+	// the third and the fourth changes share a timestamp across pages
+	items := []struct {
+		ts time.Time
+		id string
+	}{
+		{now.Add(1 * time.Second), "one"},
+		{now.Add(2 * time.Second), "two"},
+		{now.Add(3 * time.Second), "three"},
+		{now.Add(3 * time.Second), "four"},
+		{now.Add(4 * time.Second), "five"},
+		{now.Add(5 * time.Second), "six"},
+	}
+	for _, item := range items {
+		if err := storeChanges(c, &dataChanges{
+			Updated: item.ts,
+			eventData: eventData{
+				Sessions: map[string]*eventSession{item.id: {ID: item.id}},
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dc, err := getChangesSince(c, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dc.Sessions) != len(items) {
+		t.Errorf("len(dc.Sessions) = %d; want %d", len(dc.Sessions), len(items))
+	}
+	for _, item := range items {
+		if dc.Sessions[item.id] == nil {
+			t.Errorf("want session %q", item.id)
+		}
+	}
+	if ts := items[len(items)-1].ts; !dc.Updated.Equal(ts) {
+		t.Errorf("dc.Updated = %s; want %s", dc.Updated, ts)
+	}
+
+	// a page full of entries with the same timestamp can't be split across pages
+	last := items[len(items)-1].ts
+	for _, id := range []string{"seven", "eight", "nine"} {
+		if err := storeChanges(c, &dataChanges{
+			Updated: last.Add(time.Second),
+			eventData: eventData{
+				Sessions: map[string]*eventSession{id: {ID: id}},
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := getChangesSince(c, last); err != errChangesPage {
+		t.Errorf("getChangesSince(%s) err = %v; want errChangesPage", last, err)
+	}
+}
+
 func TestStoreNextSessions(t *testing.T) {
 	c := newTestContext()
 	sessions := []*eventSession{
@@ -88,3 +152,40 @@ func TestStoreNextSessions(t *testing.T) {
 		t.Errorf("items = %v; want 'new'", items)
 	}
 }
+
+func TestStoreEventDataLarge(t *testing.T) {
+	c := newTestContext()
+	defer clearEventData(c)
+
+	// random bytes don't compress, making the gob larger than blobPartSize
+	desc := make([]byte, blobPartSize*3/2)
+	rand.Read(desc)
+	data := &eventData{
+		modified: time.Now().Round(time.Second),
+		Sessions: map[string]*eventSession{"big": {ID: "big", Desc: string(desc)}},
+	}
+	if err := storeEventData(c, data); err != nil {
+		t.Fatal(err)
+	}
+	// twice to go through both the store and the cache
+	for i := 0; i < 2; i++ {
+		res, err := getLatestEventData(c, nil)
+		if err != nil {
+			t.Fatalf("%d: getLatestEventData: %v", i, err)
+		}
+		if s := res.Sessions["big"]; s == nil || s.Desc != string(desc) {
+			t.Errorf("%d: res.Sessions[big] differs from the stored one", i)
+		}
+	}
+
+	if err := storeChanges(c, &dataChanges{Updated: data.modified, eventData: *data}); err != nil {
+		t.Fatal(err)
+	}
+	dc, err := getChangesSince(c, data.modified.Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := dc.Sessions["big"]; s == nil || s.Desc != string(desc) {
+		t.Errorf("dc.Sessions[big] differs from the stored one")
+	}
+}
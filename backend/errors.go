@@ -28,6 +28,10 @@ var (
 	errConflict      = errors.New("precondition or data conflict")
 	errNotFound      = errors.New("data not found")
 	errNotModified   = errors.New("content not modified")
+
+	// errChangesPage is returned by forEachChange when a page of the change log
+	// is full of entries with the same timestamp, which can't be split across pages.
+	errChangesPage = errors.New("too many change log entries with the same timestamp")
 )
 
 // pushError is used in methods that communicate with Push services like GCM.
@@ -15,6 +15,7 @@
 package backend
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
@@ -42,6 +43,11 @@ const (
 	// syncGCSCacheKey guards GCS sync task against choking
 	// when requests coming too fast.
 	syncGCSCacheKey = "sync:gcs"
+	// defaultVersionsLimit is the number of event data versions
+	// listed by serveScheduleVersions unless limit param is provided.
+	defaultVersionsLimit = 20
+	// maxVersionsLimit caps limit param of serveScheduleVersions.
+	maxVersionsLimit = 100
 )
 
 var (
@@ -64,6 +70,10 @@ func registerHandlers() {
 	handle("/api/v1/extended", serveIOExtEntries)
 	handle("/api/v1/social", serveSocial)
 	handle("/api/v1/schedule", serveSchedule)
+	handle("/api/v1/schedule/changes", serveScheduleChanges)
+	handle("/api/v1/schedule/stream", serveScheduleStream)
+	handle("/api/v1/schedule.ics", serveScheduleICS)
+	handle("/api/v1/schedule/sessions/", serveSessionICS)
 	handle("/api/v1/topsecret", serveEasterEgg)
 	handle("/api/v1/livestream", serveLivestream)
 	handle("/api/v1/user/survey/", submitUserSurvey)
@@ -76,44 +86,59 @@ func registerHandlers() {
 	handle("/task/social", refreshSocial)
 	handle("/task/clock", handleClock)
 	handle("/task/wipeout", handleWipeout)
-	// debug handlers; not available in prod
-	if !isProd() || isDevServer() {
-		handle("/debug/srvget", debugServiceGetURL)
-		handle("/debug/push", debugPush)
-		handle("/debug/sync", debugSync)
-		handle("/debug/notify", debugNotify)
-	}
+	// login for the whitelist
+	handle("/auth/login", handleLogin)
+	handle("/auth/callback", handleLoginCallback)
+	handle("/auth/logout", handleLogout)
+	// admin handlers
+	handle("/admin/config/reload", handleConfigReload)
+	handle("/admin/schedule/report", requireRole(roleViewer, serveValidationReport))
+	handle("/admin/schedule/versions", requireRole(roleViewer, serveScheduleVersions))
+	handle("/admin/schedule/versions/", requireRole(roleViewer, serveScheduleVersions))
+	handle("/admin/schedule/diff", requireRole(roleViewer, serveScheduleDiff))
+	handle("/admin/schedule/rollback", requireRole(roleOperator, handleScheduleRollback))
+	// debug handlers; access is restricted by config.Roles
+	handle("/debug/srvget", requireRole(roleAdmin, debugServiceGetURL))
+	handle("/debug/push", requireRole(roleOperator, debugPush))
+	handle("/debug/sync", requireRole(roleOperator, debugSync))
+	handle("/debug/notify", requireRole(roleOperator, debugNotify))
+	handle("/debug/cron", requireRole(roleViewer, debugCron))
 	// setup root redirect if we're prefixed
-	if config.Prefix != "/" {
+	if config().Prefix != "/" {
 		var redirect http.Handler = http.HandlerFunc(redirectHandler)
 		if wrapHandler != nil {
 			redirect = wrapHandler(redirect)
 		}
 		http.Handle("/", redirect)
 	}
+	// Prometheus metrics, can't use prefix either
+	http.HandleFunc("/metrics", serveMetrics)
+	// health checks for load balancers
+	http.HandleFunc("/healthz", serveLiveness)
+	http.HandleFunc("/readyz", serveReadiness)
 	// warmup, can't use prefix
 	http.HandleFunc("/_ah/warmup", func(w http.ResponseWriter, r *http.Request) {
 		c := newContext(r)
-		logf(c, "warmup: env = %s; devserver? %v", config.Env, isDevServer())
+		logf(c, "warmup: env = %s; devserver? %v", config().Env, isDevServer())
 	})
 }
 
 // handle registers a handle function fn for the pattern prefixed
 // with httpPrefix.
 func handle(pattern string, fn func(w http.ResponseWriter, r *http.Request)) {
-	p := path.Join(config.Prefix, pattern)
+	p := path.Join(config().Prefix, pattern)
 	if pattern[len(pattern)-1] == '/' {
 		p += "/"
 	}
-	http.Handle(p, handler(fn))
+	http.Handle(p, handler(instrumentHandler(pattern, fn)))
 }
 
 // handler creates a new func from fn with stripped prefix
 // and wrapped with wrapHandler.
 func handler(fn func(w http.ResponseWriter, r *http.Request)) http.Handler {
 	var h http.Handler = http.HandlerFunc(fn)
-	if config.Prefix != "/" {
-		h = http.StripPrefix(config.Prefix, h)
+	if prefix := config().Prefix; prefix != "/" {
+		h = http.StripPrefix(prefix, h)
 	}
 	if wrapHandler != nil {
 		h = wrapHandler(h)
@@ -129,7 +154,7 @@ func redirectHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, http.StatusText(code), code)
 		return
 	}
-	http.Redirect(w, r, path.Join(config.Prefix, r.URL.Path), http.StatusFound)
+	http.Redirect(w, r, path.Join(config().Prefix, r.URL.Path), http.StatusFound)
 }
 
 // serveTemplate responds with text/html content of the executed template
@@ -138,7 +163,7 @@ func redirectHandler(w http.ResponseWriter, r *http.Request) {
 func serveTemplate(w http.ResponseWriter, r *http.Request) {
 	// redirect /page/ to /page unless it's homepage
 	if r.URL.Path != "/" && strings.HasSuffix(r.URL.Path, "/") {
-		trimmed := path.Join(config.Prefix, strings.TrimSuffix(r.URL.Path, "/"))
+		trimmed := path.Join(config().Prefix, strings.TrimSuffix(r.URL.Path, "/"))
 		http.Redirect(w, r, trimmed, http.StatusFound)
 		return
 	}
@@ -215,7 +240,7 @@ func serveSitemap(w http.ResponseWriter, r *http.Request) {
 	base := &url.URL{
 		Scheme: "https",
 		Host:   r.Host,
-		Path:   config.Prefix + "/",
+		Path:   config().Prefix + "/",
 	}
 	if r.TLS == nil {
 		base.Scheme = "http"
@@ -282,7 +307,7 @@ func serveSocial(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json;charset=utf-8")
 	// respond with stubbed JSON entries in dev mode
 	if isDev() {
-		f := filepath.Join(config.Dir, "temporary_api", "social_feed.json")
+		f := filepath.Join(config().Dir, "temporary_api", "social_feed.json")
 		http.ServeFile(w, r, f)
 		return
 	}
@@ -307,11 +332,13 @@ func refreshSocial(w http.ResponseWriter, r *http.Request) {
 }
 
 func serveSchedule(w http.ResponseWriter, r *http.Request) {
+	cfg := config()
 	w.Header().Set("Content-Type", "application/json;charset=utf-8")
 	c := newContext(r)
-	// respond with stubbed JSON entries in dev mode
-	if isDev() {
-		f := filepath.Join(config.Dir, "temporary_api", "schedule.json")
+	// respond with stubbed JSON entries in dev mode,
+	// unless event data is synced from a local manifest
+	if isDev() && !isLocalManifest(cfg.Schedule.ManifestURL) {
+		f := filepath.Join(cfg.Dir, "temporary_api", "schedule.json")
 		fi, err := os.Stat(f)
 		if err != nil {
 			writeJSONError(c, w, errStatus(err), err)
@@ -342,59 +369,194 @@ func serveSchedule(w http.ResponseWriter, r *http.Request) {
 	w.Write(b)
 }
 
+// serveScheduleChanges responds with changes of event data since a point identified
+// by "since" param: either an etag of /api/v1/schedule response, or a timestamp
+// in RFC 3339 format or seconds since epoch, like "ts" field of a previous response.
+// All change log entries after that point are merged into one, removed items included.
+//
+// If the change log doesn't cover the point, e.g. when the etag is unknown,
+// the response has "resync" field set to true and the client is expected
+// to fetch the whole /api/v1/schedule instead.
+func serveScheduleChanges(w http.ResponseWriter, r *http.Request) {
+	c := newContext(r)
+	since := strings.Trim(r.FormValue("since"), `"`)
+	if since == "" {
+		writeJSONError(c, w, http.StatusBadRequest, "since param is required")
+		return
+	}
+	res := struct {
+		Resync   bool                     `json:"resync"`
+		Etag     string                   `json:"etag,omitempty"`
+		Updated  time.Time                `json:"ts"`
+		Sessions map[string]*eventSession `json:"sessions,omitempty"`
+		Speakers map[string]*eventSpeaker `json:"speakers,omitempty"`
+		Videos   map[string]*eventVideo   `json:"videos,omitempty"`
+	}{}
+	// unlike admin handlers, respond with compact JSON, same as serveSchedule
+	respond := func() {
+		b, err := json.Marshal(res)
+		if err != nil {
+			writeJSONError(c, w, errStatus(err), err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json;charset=utf-8")
+		w.Write(b)
+	}
+	// stubbed JSON entries of dev mode have no change log; see serveSchedule
+	if isDev() && !isLocalManifest(config().Schedule.ManifestURL) {
+		res.Resync = true
+		respond()
+		return
+	}
+
+	cur, err := getLatestEventData(c, nil)
+	if err != nil {
+		writeJSONError(c, w, errStatus(err), err)
+		return
+	}
+	res.Etag, res.Updated = cur.etag, cur.modified
+	if since == cur.etag {
+		respond()
+		return
+	}
+
+	var t time.Time
+	if n, err := strconv.ParseInt(since, 10, 64); err == nil {
+		t = time.Unix(n, 0)
+	} else if t, err = time.Parse(time.RFC3339, since); err != nil {
+		// not a timestamp; must be an etag of a previous version
+		if !isEtag(since) {
+			res.Resync = true
+			respond()
+			return
+		}
+		t, err = getEventDataTime(c, since)
+		if err == errNotFound {
+			res.Resync = true
+			respond()
+			return
+		}
+		if err != nil {
+			writeJSONError(c, w, errStatus(err), err)
+			return
+		}
+	}
+	if !t.Before(cur.modified) {
+		respond()
+		return
+	}
+	ok, err := changeLogCovers(c, t)
+	if err != nil {
+		writeJSONError(c, w, errStatus(err), err)
+		return
+	}
+	if !ok {
+		res.Resync = true
+		respond()
+		return
+	}
+
+	dc, err := getChangesSince(c, t)
+	if err == errChangesPage {
+		errorf(c, "serveScheduleChanges: %v since %s", err, t)
+		res.Resync = true
+		respond()
+		return
+	}
+	if err != nil {
+		writeJSONError(c, w, errStatus(err), err)
+		return
+	}
+	for _, s := range dc.Speakers {
+		s.Thumb = thumbURL(s.Thumb)
+	}
+	res.Sessions, res.Speakers, res.Videos = dc.Sessions, dc.Speakers, dc.Videos
+	respond()
+}
+
 // syncEventData updates event data stored in a persistent DB,
 // diffs the changes with a previous version, stores those changes
 // and spawns up workers to send push notifications to interested parties.
 func syncEventData(w http.ResponseWriter, r *http.Request) {
-	c := newContext(r)
+	c := withCorrelationID(newContext(r), "")
+	cfg := config()
 	// allow only cron jobs, task queues and GCS but don't tell them that
 	tque := r.Header.Get("x-appengine-cron") == "true" || r.Header.Get("x-appengine-taskname") != ""
-	if t := r.Header.Get("x-goog-channel-token"); t != config.SyncToken && !tque {
+	if t := r.Header.Get("x-goog-channel-token"); t != cfg.SyncToken && !tque {
 		logf(c, "NOT performing sync: x-goog-channel-token = %q", t)
+		syncTotal.inc("unauthorized")
 		return
 	}
 
 	i, err := cache.inc(c, syncGCSCacheKey, 1, 0)
 	if err != nil {
+		syncTotal.inc("error")
 		writeError(w, err)
 		return
 	}
 	if i > 1 {
 		logf(c, "GCS sync: already running")
+		syncTotal.inc("running")
 		return
 	}
 
-	err = runInTransaction(c, func(c context.Context) error {
-		oldData, err := getLatestEventData(c, nil)
+	outcome := "updated"
+
+	err = func() error {
+		// data files are fetched before the transaction starts,
+		// since retries of slow fetches may outlast the transaction deadline
+		lastData, err := getLatestEventData(c, nil)
 		if err != nil {
 			return err
 		}
-
-		newData, err := fetchEventData(c, config.Schedule.ManifestURL, oldData.modified)
+		newData, err := fetchEventData(c, cfg.Schedule.ManifestURL, lastData)
 		if err != nil {
 			return err
 		}
 		if isEmptyEventData(newData) {
-			logf(c, "%s: no data or not modified (last: %s)", config.Schedule.ManifestURL, oldData.modified)
+			logf(c, "%s: no data or not modified (last: %s)", cfg.Schedule.ManifestURL, lastData.modified)
+			outcome = "not_modified"
 			return nil
 		}
-		if err := storeEventData(c, newData); err != nil {
-			return err
-		}
+		logf(c, "%s: changed data files: %v", cfg.Schedule.ManifestURL, newData.changed)
+		return runInTransaction(c, func(c context.Context) error {
+			oldData, err := getLatestEventData(c, nil)
+			if err != nil {
+				return err
+			}
+			if !oldData.modified.Equal(lastData.modified) {
+				errorf(c, "%s: event data changed during fetch (%s => %s)", cfg.Schedule.ManifestURL, lastData.modified, oldData.modified)
+				return errConflict
+			}
+			if err := storeEventData(c, newData); err != nil {
+				return err
+			}
+			if rep := newData.report; rep != nil {
+				if n, w := rep.count(); n > 0 {
+					errorf(c, "%s: %d validation issues, %d warnings; see /admin/schedule/report", cfg.Schedule.ManifestURL, n, w)
+				} else if w > 0 {
+					logf(c, "%s: %d validation warnings; see /admin/schedule/report", cfg.Schedule.ManifestURL, w)
+				}
+				if err := storeValidationReport(c, rep); err != nil {
+					return err
+				}
+			}
 
-		diff := diffEventData(oldData, newData)
-		if isEmptyChanges(diff) {
-			logf(c, "%s: diff is empty (last: %s)", config.Schedule.ManifestURL, oldData.modified)
-			return nil
-		}
-		if err := storeChanges(c, diff); err != nil {
-			return err
-		}
-		if err := notifySubscribersAsync(c, diff, false); err != nil {
-			return err
-		}
-		return nil
-	})
+			diff := diffEventData(oldData, newData)
+			if isEmptyChanges(diff) {
+				logf(c, "%s: diff is empty (last: %s)", cfg.Schedule.ManifestURL, oldData.modified)
+				outcome = "empty_diff"
+				return nil
+			}
+			syncDiffItems.observe(float64(len(diff.Sessions)), "sessions")
+			syncDiffItems.observe(float64(len(diff.Speakers)), "speakers")
+			syncDiffItems.observe(float64(len(diff.Videos)), "videos")
+			if err := storeChanges(c, diff); err != nil {
+				return err
+			}
+			return notifySubscribersAsync(c, diff, false)
+		})
+	}()
 
 	if err := cache.deleteMulti(c, []string{syncGCSCacheKey}); err != nil {
 		errorf(c, err.Error())
@@ -403,7 +565,9 @@ func syncEventData(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		errorf(c, "syncEventSchedule: %v", err)
 		writeError(w, err)
+		outcome = "error"
 	}
+	syncTotal.inc(outcome)
 }
 
 // submitUserSurvey submits survey responses for a specific session or a batch.
@@ -471,7 +635,7 @@ func submitTaskSurvey(w http.ResponseWriter, r *http.Request) {
 
 // TODO: update for Firebase and webpush
 func handleNotifySubscribers(w http.ResponseWriter, r *http.Request) {
-	c := newContext(r)
+	c := withCorrelationID(newContext(r), r.FormValue(correlationParam))
 	if retry, err := taskRetryCount(r); err != nil || retry > maxTaskRetry {
 		errorf(c, "retry = %d, err: %v", retry, err)
 		return
@@ -480,7 +644,7 @@ func handleNotifySubscribers(w http.ResponseWriter, r *http.Request) {
 	all := r.FormValue("all") == "true"
 	changes := r.FormValue("changes")
 
-	for _, shard := range config.Firebase.Shards {
+	for _, shard := range config().Firebase.Shards {
 		if err := notifyShardAsync(c, shard, changes, all); err != nil {
 			errorf(c, "handleNotifySubscribers: %v", err)
 		}
@@ -488,7 +652,7 @@ func handleNotifySubscribers(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleNotifyShard(w http.ResponseWriter, r *http.Request) {
-	c := newContext(r)
+	c := withCorrelationID(newContext(r), r.FormValue(correlationParam))
 	if retry, err := taskRetryCount(r); err != nil || retry > maxTaskRetry {
 		errorf(c, "retry = %d, err: %v", retry, err)
 		return
@@ -496,6 +660,7 @@ func handleNotifyShard(w http.ResponseWriter, r *http.Request) {
 
 	all := r.FormValue("all") == "true"
 	shard := r.FormValue("shard")
+	c = withLogFields(c, "shard", shard)
 	changes := &dataChanges{}
 	if err := json.Unmarshal([]byte(r.FormValue("changes")), changes); err != nil {
 		errorf(c, "handleNotifyShard: %v\n%v", err, r.FormValue("changes"))
@@ -523,6 +688,7 @@ func handleNotifyShard(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for _, uid := range users {
+		c := withLogFields(c, "uid", uid)
 		nn := userNotifications(c, changes, userSessions[uid])
 		for _, n := range nn {
 			msg := &pushMessage{Notification: n}
@@ -535,7 +701,7 @@ func handleNotifyShard(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleNotifyUser(w http.ResponseWriter, r *http.Request) {
-	c := newContext(r)
+	c := withCorrelationID(newContext(r), r.FormValue(correlationParam))
 	retry, err := taskRetryCount(r)
 	if err != nil || retry > maxTaskRetry {
 		errorf(c, "retry = %d, err: %v", retry, err)
@@ -544,6 +710,7 @@ func handleNotifyUser(w http.ResponseWriter, r *http.Request) {
 
 	uid := r.FormValue("uid")
 	shard := r.FormValue("shard")
+	c = withLogFields(c, "uid", uid, "shard", shard)
 	pi, err := getUserPushInfo(c, uid, shard)
 	if err != nil {
 		errorf(c, "handleNotifyUser uid: %v, err: %v", uid, err)
@@ -561,9 +728,37 @@ func handleNotifyUser(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	sids := make([]string, 0, len(msg.Sessions))
+	for id := range msg.Sessions {
+		sids = append(sids, id)
+	}
+	sort.Strings(sids)
+	c = withLogFields(c, "sessions", strings.Join(sids, ","))
 
-	for key, sub := range pi.Subscriptions {
-		if err = notifySubscription(c, sub, msg); err != nil {
+	keys := r.Form["sub"]
+	if len(keys) == 0 {
+		for key := range pi.Subscriptions {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+	}
+	for i, key := range keys {
+		sub, ok := pi.Subscriptions[key]
+		if !ok {
+			continue
+		}
+		if isStopping() {
+			// hand over the rest to a new task, resumed after restart
+			logf(c, "handleNotifyUser: stopping with %d subscriptions left", len(keys)-i)
+			if err := notifyUserAsync(c, uid, shard, msg, keys[i:]...); err != nil {
+				errorf(c, "handleNotifyUser: %v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+		err = notifySubscription(c, sub, msg)
+		pushTotal.inc(pushResult(err))
+		if err != nil {
 			pe := err.(*pushError)
 			if pe.remove {
 				logf(c, "handleNotifyUser: %v", err)
@@ -578,7 +773,7 @@ func handleNotifyUser(w http.ResponseWriter, r *http.Request) {
 // handleClock compares time.Now() to each session and notifies users about starting sessions.
 // It must be run frequently, every minute or so.
 func handleClock(w http.ResponseWriter, r *http.Request) {
-	c := newContext(r)
+	c := withCorrelationID(newContext(r), "")
 	retry, err := taskRetryCount(r)
 	if h := r.Header.Get("x-appengine-cron"); h != "true" && err == nil && retry > 0 {
 		errorf(c, "cron = %s, retry = %d, err: %v", h, retry, err)
@@ -632,6 +827,7 @@ func handleClock(w http.ResponseWriter, r *http.Request) {
 // It must be run every day
 func handleWipeout(w http.ResponseWriter, r *http.Request) {
 	c := newContext(r)
+	cfg := config()
 	retry, err := taskRetryCount(r)
 	if h := r.Header.Get("x-appengine-cron"); h != "true" || err == nil && retry > 0 {
 		errorf(c, "cron = %s, retry = %d, err: %v", h, retry, err)
@@ -640,13 +836,13 @@ func handleWipeout(w http.ResponseWriter, r *http.Request) {
 
 	ch := make(chan error, 1)
 
-	for _, shard := range config.Firebase.Shards {
+	for _, shard := range cfg.Firebase.Shards {
 		go func(shard string) {
 			ch <- wipeoutShard(c, shard)
 		}(shard)
 	}
 
-	for _, shard := range config.Firebase.Shards {
+	for _, shard := range cfg.Firebase.Shards {
 		if err := <-ch; err != nil {
 			w.WriteHeader(500)
 			errorf(c, "wipeout err: %v, shard: %s", err, shard)
@@ -705,7 +901,7 @@ func serveLivestream(w http.ResponseWriter, r *http.Request) {
 }
 
 // debugGetURL fetches a URL with service account credentials.
-// Should not be available on prod.
+// Access is restricted by requireRole in registerHandlers.
 func debugServiceGetURL(w http.ResponseWriter, r *http.Request) {
 	c := newContext(r)
 	req, err := http.NewRequest("GET", r.FormValue("url"), nil)
@@ -737,13 +933,13 @@ func debugServiceGetURL(w http.ResponseWriter, r *http.Request) {
 
 // debugPush stores dataChanges from r in the DB and calls notifySubscribersAsync.
 // dataChanges.Token is ignored; dataChanges.Changed is set to current time if not provided.
-// Should not be available on prod.
+// Access is restricted by requireRole in registerHandlers.
 func debugPush(w http.ResponseWriter, r *http.Request) {
 	c := newContext(r)
 
 	if r.Method == "GET" {
 		w.Header().Set("Content-Type", "text/html;charset=utf-8")
-		t, err := template.ParseFiles(filepath.Join(config.Dir, templatesDir, "debug", "push.html"))
+		t, err := template.ParseFiles(filepath.Join(config().Dir, templatesDir, "debug", "push.html"))
 		if err != nil {
 			writeError(w, err)
 			return
@@ -787,10 +983,11 @@ func debugPush(w http.ResponseWriter, r *http.Request) {
 // debugNotify directly sends a notification to a list of users
 func debugNotify(w http.ResponseWriter, r *http.Request) {
 	c := newContext(r)
+	cfg := config()
 
 	if r.Method == "GET" {
 		w.Header().Set("Content-Type", "text/html;charset=utf-8")
-		t, err := template.ParseFiles(filepath.Join(config.Dir, templatesDir, "debug", "notify.html"))
+		t, err := template.ParseFiles(filepath.Join(cfg.Dir, templatesDir, "debug", "notify.html"))
 		if err != nil {
 			writeError(w, err)
 			return
@@ -821,7 +1018,7 @@ func debugNotify(w http.ResponseWriter, r *http.Request) {
 
 	// TODO: In dev we only have one shard, so should work for debug. However,
 	// probably need to accept shard as an argument instead
-	shard := config.Firebase.Shards[0]
+	shard := cfg.Firebase.Shards[0]
 
 	for _, id := range users {
 		if err := notifyUserAsync(c, id, shard, msg); err != nil {
@@ -831,13 +1028,14 @@ func debugNotify(w http.ResponseWriter, r *http.Request) {
 }
 
 // debugSync updates locally stored EventData with staging or prod data.
-// Should not be available on prod.
+// Access is restricted by requireRole in registerHandlers.
 func debugSync(w http.ResponseWriter, r *http.Request) {
 	c := newContext(r)
+	cfg := config()
 
 	if r.Method == "GET" {
 		w.Header().Set("Content-Type", "text/html;charset=utf-8")
-		t, err := template.ParseFiles(filepath.Join(config.Dir, templatesDir, "debug", "sync.html"))
+		t, err := template.ParseFiles(filepath.Join(cfg.Dir, templatesDir, "debug", "sync.html"))
 		if err != nil {
 			writeError(w, err)
 			return
@@ -848,10 +1046,10 @@ func debugSync(w http.ResponseWriter, r *http.Request) {
 			Manifest  string
 			SyncToken string
 		}{
-			config.Env,
-			config.Prefix,
-			config.Schedule.ManifestURL,
-			config.SyncToken,
+			cfg.Env,
+			cfg.Prefix,
+			cfg.Schedule.ManifestURL,
+			cfg.masked().SyncToken,
 		}
 		if err := t.Execute(w, &data); err != nil {
 			errorf(c, err.Error())
@@ -859,11 +1057,271 @@ func debugSync(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.Method == "POST" {
+		// sync on behalf of the page so that the token never leaves the server
+		r.Header.Set("x-goog-channel-token", cfg.SyncToken)
+		syncEventData(w, r)
+		return
+	}
+
 	if err := clearEventData(c); err != nil {
 		writeError(w, err)
 	}
 }
 
+// debugCron responds with the state of cron jobs run by the standalone server.
+// Access is restricted by requireRole in registerHandlers.
+func debugCron(w http.ResponseWriter, r *http.Request) {
+	c := newContext(r)
+	if cron == nil {
+		writeJSONError(c, w, http.StatusNotFound, "cron jobs are run by App Engine")
+		return
+	}
+	b, err := json.MarshalIndent(cron.status(), "", "  ")
+	if err != nil {
+		writeJSONError(c, w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.Write(b)
+}
+
+// serveValidationReport responds with the validation report of event data
+// stored by the last sync, so that content editors can find mistakes in data files.
+func serveValidationReport(w http.ResponseWriter, r *http.Request) {
+	c := newContext(r)
+	rep, err := getValidationReport(c)
+	if err == errNotFound {
+		writeJSONError(c, w, http.StatusNotFound, "event data has not been synced yet")
+		return
+	}
+	if err != nil {
+		writeJSONError(c, w, http.StatusInternalServerError, err)
+		return
+	}
+	b, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		writeJSONError(c, w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.Write(b)
+}
+
+// serveScheduleVersions responds with a list of stored event data versions,
+// or a single version identified by its etag, "/admin/schedule/versions/<etag>",
+// in the same format as serveSchedule.
+func serveScheduleVersions(w http.ResponseWriter, r *http.Request) {
+	c := newContext(r)
+	if etag := path.Base(r.URL.Path); etag != "versions" {
+		data, err := getEventDataVersion(c, etag)
+		if err != nil {
+			writeJSONError(c, w, errStatus(err), err)
+			return
+		}
+		w.Header().Set("etag", `"`+data.etag+`"`)
+		writeJSON(c, w, toAPISchedule(data))
+		return
+	}
+
+	limit := defaultVersionsLimit
+	if v := r.FormValue("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeJSONError(c, w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = n
+	}
+	if limit > maxVersionsLimit {
+		limit = maxVersionsLimit
+	}
+	versions, err := getEventDataVersions(c, limit)
+	if err != nil {
+		writeJSONError(c, w, errStatus(err), err)
+		return
+	}
+	type version struct {
+		Etag     string    `json:"etag"`
+		Modified time.Time `json:"modified"`
+		Current  bool      `json:"current"`
+		Sessions int       `json:"sessions"`
+		Speakers int       `json:"speakers"`
+		Videos   int       `json:"videos"`
+		Tags     int       `json:"tags"`
+	}
+	res := make([]*version, len(versions))
+	for i, ent := range versions {
+		res[i] = &version{
+			Etag:     ent.Etag,
+			Modified: ent.Timestamp,
+			Current:  i == 0,
+			Sessions: ent.Sessions,
+			Speakers: ent.Speakers,
+			Videos:   ent.Videos,
+			Tags:     ent.Tags,
+		}
+	}
+	writeJSON(c, w, res)
+}
+
+// serveScheduleDiff responds with changes between two event data versions
+// identified by "from" and "to" etag params, as computed by diffEventData.
+// The current version is used if "to" is empty.
+func serveScheduleDiff(w http.ResponseWriter, r *http.Request) {
+	c := newContext(r)
+	from := r.FormValue("from")
+	if from == "" {
+		writeJSONError(c, w, http.StatusBadRequest, "from param is required")
+		return
+	}
+	a, err := getEventDataVersion(c, from)
+	if err != nil {
+		writeJSONError(c, w, errStatus(err), err)
+		return
+	}
+	var b *eventData
+	if to := r.FormValue("to"); to != "" {
+		b, err = getEventDataVersion(c, to)
+	} else {
+		b, err = getLatestEventData(c, nil)
+	}
+	if err != nil {
+		writeJSONError(c, w, errStatus(err), err)
+		return
+	}
+	dc := diffEventData(a, b)
+	if dc == nil {
+		dc = &dataChanges{Updated: b.modified}
+	}
+	writeJSON(c, w, dc)
+}
+
+// handleScheduleRollback makes a previous version of event data, identified by etag param,
+// current again. The version is stored anew with the current time as its modification time,
+// so that the rollback holds until the manifest is modified again.
+// Changes between the current and the restored versions are stored in the change log,
+// and subscribers are notified about them if notify param is "true".
+func handleScheduleRollback(w http.ResponseWriter, r *http.Request) {
+	c := withCorrelationID(newContext(r), "")
+	if r.Method != "POST" {
+		writeJSONError(c, w, http.StatusMethodNotAllowed, "only POST is allowed")
+		return
+	}
+	etag := r.FormValue("etag")
+	if etag == "" {
+		writeJSONError(c, w, http.StatusBadRequest, "etag param is required")
+		return
+	}
+	notify := r.FormValue("notify") == "true"
+
+	res := struct {
+		Etag     string `json:"etag"`
+		Restored string `json:"restored"`
+		Changes  int    `json:"changes"`
+		Notified bool   `json:"notified"`
+	}{Restored: etag}
+	err := runInTransaction(c, func(c context.Context) error {
+		cur, err := getLatestEventData(c, nil)
+		if err != nil {
+			return err
+		}
+		data, err := getEventDataVersion(c, etag)
+		if err != nil {
+			return err
+		}
+		if data.etag == cur.etag {
+			return &apiError{code: http.StatusConflict, msg: "version " + etag + " is already current"}
+		}
+		data.modified = time.Now()
+		if err := storeEventData(c, data); err != nil {
+			return err
+		}
+		res.Etag = data.etag
+
+		diff := diffEventData(cur, data)
+		if isEmptyChanges(diff) {
+			return nil
+		}
+		res.Changes = len(diff.Sessions) + len(diff.Speakers) + len(diff.Videos)
+		if err := storeChanges(c, diff); err != nil {
+			return err
+		}
+		if !notify {
+			return nil
+		}
+		res.Notified = true
+		return notifySubscribersAsync(c, diff, false)
+	})
+	if err != nil {
+		writeJSONError(c, w, errStatus(err), err)
+		return
+	}
+	// storeEventData clears the cache within the transaction,
+	// which doesn't stop a concurrent reader from caching the old version before commit
+	if err := cache.deleteMulti(c, allCachedEventDataKeys); err != nil {
+		errorf(c, "handleScheduleRollback: %v", err)
+	}
+	logf(c, "rolled back event data to %s as %s; %d changes, notified: %v", etag, res.Etag, res.Changes, notify)
+	writeJSON(c, w, &res)
+}
+
+// handleConfigReload reloads server config using reloadConfig
+// and responds with the list of issues found in the new config.
+// Requests must be authorized with "Bearer <config.AdminToken>".
+func handleConfigReload(w http.ResponseWriter, r *http.Request) {
+	c := newContext(r)
+	if !allowConfigReload {
+		writeJSONError(c, w, http.StatusNotFound, "config reload is not supported on App Engine")
+		return
+	}
+	if r.Method != "POST" {
+		writeJSONError(c, w, http.StatusMethodNotAllowed, "only POST is allowed")
+		return
+	}
+	token := config().AdminToken
+	auth := r.Header.Get("authorization")
+	if token == "" || subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+token)) != 1 {
+		writeJSONError(c, w, http.StatusForbidden, "invalid admin token")
+		return
+	}
+
+	issues, err := reloadConfig(c)
+	res := struct {
+		Reloaded bool     `json:"reloaded"`
+		Issues   []string `json:"issues"`
+		Error    string   `json:"error,omitempty"`
+	}{Reloaded: err == nil, Issues: make([]string, len(issues))}
+	for i, ci := range issues {
+		res.Issues[i] = ci.String()
+	}
+	code := http.StatusOK
+	if err != nil {
+		errorf(c, "reloadConfig: %v", err)
+		res.Error = err.Error()
+		code = http.StatusBadRequest
+	}
+	b, err := json.MarshalIndent(&res, "", "  ")
+	if err != nil {
+		writeJSONError(c, w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(b)
+}
+
+// writeJSON writes v to w in indented JSON format.
+func writeJSON(c context.Context, w http.ResponseWriter, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		writeJSONError(c, w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.Write(b)
+}
+
 // writeJSONError sets response code to 500 and writes an error message to w.
 // If err is *apiError, code is overwritten by err.code.
 // TODO: remove code from the args and use only apiError.
@@ -946,17 +1404,18 @@ func toAPISchedule(d *eventData) interface{} {
 
 // canonicalURL returns a canonical URL of the page rendered for a request at URL u.
 func canonicalURL(r *http.Request, q url.Values) string {
+	cfg := config()
 	// make sure path has site prefix
 	p := r.URL.Path
-	if !strings.HasPrefix(p, config.Prefix) {
-		p = path.Join(config.Prefix, p)
+	if !strings.HasPrefix(p, cfg.Prefix) {
+		p = path.Join(cfg.Prefix, p)
 	}
 	// remove /home
-	if p == path.Join(config.Prefix, "home") {
-		p = config.Prefix + "/"
+	if p == path.Join(cfg.Prefix, "home") {
+		p = cfg.Prefix + "/"
 	}
 	// re-add trailing slash if needed
-	if p == config.Prefix {
+	if p == cfg.Prefix {
 		p += "/"
 	}
 
@@ -974,9 +1433,10 @@ func canonicalURL(r *http.Request, q url.Values) string {
 	return u.String()
 }
 
-// h2preload adds HTTP/2 preload header configured in h2config.
+// h2preload adds HTTP/2 preload header configured in the HTTP/2 preload manifest.
 func h2preload(h http.Header, host, tplname string) {
-	a, ok := h2config[tplname]
+	cfg := config()
+	a, ok := cfg.h2preload[tplname]
 	if !ok {
 		return
 	}
@@ -984,7 +1444,7 @@ func h2preload(h http.Header, host, tplname string) {
 	if isDevServer() {
 		s = "http"
 	}
-	http2preload.AddHeader(h, s, path.Join(host, config.Prefix), a)
+	http2preload.AddHeader(h, s, path.Join(host, cfg.Prefix), a)
 }
 
 // fbtoken extracts firebase auth token from s.
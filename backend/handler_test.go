@@ -20,6 +20,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"sort"
 	"strconv"
@@ -47,7 +48,7 @@ func TestServeSocialStub(t *testing.T) {
 
 func TestServeScheduleStub(t *testing.T) {
 	defer preserveConfig()
-	config.Env = "dev"
+	config().Env = "dev"
 
 	r, _ := aetestInstance.NewRequest("GET", "/api/v1/schedule", nil)
 	w := httptest.NewRecorder()
@@ -73,7 +74,7 @@ func TestServeScheduleStub(t *testing.T) {
 
 func TestServeSchedule(t *testing.T) {
 	defer preserveConfig()
-	config.Env = "prod"
+	config().Env = "prod"
 	r, _ := aetestInstance.NewRequest("GET", "/api/v1/schedule", nil)
 	c := newContext(r)
 
@@ -138,7 +139,7 @@ func TestServeSchedule(t *testing.T) {
 func TestServeTemplate(t *testing.T) {
 	defer preserveConfig()()
 	const ctype = "text/html;charset=utf-8"
-	config.Prefix = "/root"
+	config().Prefix = "/root"
 
 	table := []struct{ path, slug, canonical string }{
 		{"/", "home", "http://example.org/root/"},
@@ -187,19 +188,18 @@ func TestServeTemplate(t *testing.T) {
 func TestH2Preload(t *testing.T) {
 	defer preserveConfig()()
 	// verify we have a h2preload config file
-	var err error
-	if h2config, err = http2preload.ReadManifest("h2preload.json"); err != nil {
+	if _, err := http2preload.ReadManifest("h2preload.json"); err != nil {
 		t.Fatalf("h2preload: %v", err)
 	}
 	// replace actual file content with test entries
-	h2config = http2preload.Manifest{
+	config().h2preload = http2preload.Manifest{
 		"home": {
 			"elements/elements.html": http2preload.AssetOpt{Type: "document"},
 			"elements/elements.js":   http2preload.AssetOpt{Type: "script"},
 			"styles/main.css":        http2preload.AssetOpt{Type: "style"},
 		},
 	}
-	config.Prefix = "/root"
+	config().Prefix = "/root"
 
 	r, _ := aetestInstance.NewRequest("GET", "/", nil)
 	r.Host = "example.org"
@@ -236,7 +236,7 @@ func TestServeTemplateRedirect(t *testing.T) {
 		if w.Code != http.StatusFound {
 			t.Fatalf("%d: GET %s: %d; want %d", i, test.start, w.Code, http.StatusFound)
 		}
-		redirect := config.Prefix + test.redirect
+		redirect := config().Prefix + test.redirect
 		if loc := w.Header().Get("Location"); loc != redirect {
 			t.Errorf("%d: Location: %q; want %q", i, loc, redirect)
 		}
@@ -275,8 +275,8 @@ func TestServeSessionTemplate(t *testing.T) {
 	}
 
 	table := []*struct{ p, title, desc, image string }{
-		{"/schedule", "Schedule", descDefault, config.Prefix + "/" + ogImageDefault},
-		{"/schedule?sid=not-there", "Schedule", descDefault, config.Prefix + "/" + ogImageDefault},
+		{"/schedule", "Schedule", descDefault, config().Prefix + "/" + ogImageDefault},
+		{"/schedule?sid=not-there", "Schedule", descDefault, config().Prefix + "/" + ogImageDefault},
 		{"/schedule?sid=123", "Session - Google I/O Schedule", "desc", "http://image.jpg"},
 	}
 
@@ -317,9 +317,9 @@ func TestServeEmbed(t *testing.T) {
 	defer preserveConfig()()
 
 	now := time.Now().Round(time.Second).UTC()
-	config.Schedule.Start = now
-	config.Schedule.Location = time.UTC
-	config.Prefix = "/pref"
+	config().Schedule.Start = now
+	config().Schedule.Location = time.UTC
+	config().Prefix = "/pref"
 
 	r := newTestRequest(t, "GET", "/embed", nil)
 	r.Host = "example.org"
@@ -379,9 +379,9 @@ func TestServeLivestream(t *testing.T) {
 	defer preserveConfig()()
 
 	now := time.Now().Round(time.Second).UTC()
-	config.Env = "prod"
-	config.Schedule.Start = now
-	config.Schedule.Location = time.UTC
+	config().Env = "prod"
+	config().Schedule.Start = now
+	config().Schedule.Location = time.UTC
 
 	r := newTestRequest(t, "GET", "/api/v1/livestream", nil)
 	c := newContext(r)
@@ -445,7 +445,7 @@ func TestServeSitemap(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	config.Prefix = "/pref"
+	config().Prefix = "/pref"
 	r := newTestRequest(t, "GET", "/sitemap.xml", nil)
 	r.Host = "example.org"
 	r.TLS = &tls.ConnectionState{}
@@ -491,7 +491,7 @@ func TestServeSitemap(t *testing.T) {
 
 func TestServeManifest(t *testing.T) {
 	defer preserveConfig()()
-	config.Google.GCM.Sender = "sender-123"
+	config().Google.GCM.Sender = "sender-123"
 
 	r, _ := http.NewRequest("GET", "/manifest.json", nil)
 	w := httptest.NewRecorder()
@@ -544,9 +544,9 @@ func TestSubmitUserSurvey(t *testing.T) {
 	}))
 	defer firestub.Close()
 
-	config.Env = "prod"
-	config.Firebase.Shards = []string{firestub.URL}
-	config.Survey.Answers = []string{"1", "2", "3", "4", "5"}
+	config().Env = "prod"
+	config().Firebase.Shards = []string{firestub.URL}
+	config().Survey.Answers = []string{"1", "2", "3", "4", "5"}
 
 	const body = `{
 		"overall": "5",
@@ -606,15 +606,15 @@ func TestSubmitTaskSurvey(t *testing.T) {
 	}))
 	defer epoint.Close()
 
-	config.Env = "prod"
-	config.Survey.Endpoint = epoint.URL + "/"
-	config.Survey.ID = "io-survey"
-	config.Survey.Reg = "registrant"
-	config.Survey.Key = "ep-key"
-	config.Survey.Q1 = "q1"
-	config.Survey.Q2 = "q2"
-	config.Survey.Q3 = "q3"
-	config.Survey.Q4 = "q4"
+	config().Env = "prod"
+	config().Survey.Endpoint = epoint.URL + "/"
+	config().Survey.ID = "io-survey"
+	config().Survey.Reg = "registrant"
+	config().Survey.Key = "ep-key"
+	config().Survey.Q1 = "q1"
+	config().Survey.Q2 = "q2"
+	config().Survey.Q3 = "q3"
+	config().Survey.Q4 = "q4"
 
 	const body = `{
 		"overall": "5",
@@ -751,8 +751,8 @@ func TestFirstSyncEventData(t *testing.T) {
 	}))
 	defer ts.Close()
 
-	config.Schedule.ManifestURL = ts.URL + "/manifest.json"
-	config.Schedule.Start = startDate
+	config().Schedule.ManifestURL = ts.URL + "/manifest.json"
+	config().Schedule.Start = startDate
 
 	r := newTestRequest(t, "POST", "/sync/gcs", nil)
 	r.Header.Set("x-goog-channel-token", "sync-token")
@@ -828,8 +828,8 @@ func TestSyncEventDataEmtpyDiff(t *testing.T) {
 	}))
 	defer ts.Close()
 
-	config.Schedule.ManifestURL = ts.URL + "/manifest.json"
-	config.Schedule.Start = time.Date(2015, 5, 28, 9, 30, 0, 0, time.UTC)
+	config().Schedule.ManifestURL = ts.URL + "/manifest.json"
+	config().Schedule.Start = time.Date(2015, 5, 28, 9, 30, 0, 0, time.UTC)
 
 	r := newTestRequest(t, "POST", "/sync/gcs", nil)
 	r.Header.Set("x-goog-channel-token", "sync-token")
@@ -855,6 +855,44 @@ func TestSyncEventDataEmtpyDiff(t *testing.T) {
 	}
 }
 
+func TestSyncEventDataConflict(t *testing.T) {
+	defer resetTestState(t)
+	defer preserveConfig()()
+
+	r := newTestRequest(t, "POST", "/sync/gcs", nil)
+	r.Header.Set("x-goog-channel-token", "sync-token")
+	c := newContext(r)
+	other := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/manifest.json" {
+			w.Header().Set("last-modified", time.Now().UTC().Format(http.TimeFormat))
+			w.Write([]byte(`{"data_files": ["schedule.json"]}`))
+			return
+		}
+		// another sync stores a version while data files are being fetched
+		if err := storeEventData(c, &eventData{modified: other}); err != nil {
+			t.Errorf("storeEventData: %v", err)
+		}
+		w.Write([]byte(`{"sessions": [{"id": "s1", "title": "Session", "startTimestamp": "2015-05-28T22:00:00Z"}]}`))
+	}))
+	defer ts.Close()
+	config().Schedule.ManifestURL = ts.URL + "/manifest.json"
+
+	w := httptest.NewRecorder()
+	syncEventData(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("w.Code = %d; want 500", w.Code)
+	}
+	data, err := getLatestEventData(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !data.modified.Equal(other) {
+		t.Errorf("data.modified = %s; want %s", data.modified, other)
+	}
+}
+
 func TestSyncEventDataWithDiff(t *testing.T) {
 	defer resetTestState(t)
 	defer preserveConfig()()
@@ -941,8 +979,8 @@ func TestSyncEventDataWithDiff(t *testing.T) {
 	}))
 	defer ts.Close()
 
-	config.Schedule.ManifestURL = ts.URL + "/manifest.json"
-	config.Schedule.Start = startDate
+	config().Schedule.ManifestURL = ts.URL + "/manifest.json"
+	config().Schedule.Start = startDate
 
 	w := httptest.NewRecorder()
 	syncEventData(w, r)
@@ -986,6 +1024,8 @@ func TestSyncEventDataWithDiff(t *testing.T) {
 		t.Errorf("len(dc.Videos) = %d; want 0", l)
 	}
 	s.Update = updateDetails
+	s.Changes = []string{changeRoom, changeDetails}
+	s.Prev = &sessionPrev{Room: session.Room, Desc: session.Desc}
 	if s2 := dc.Sessions[session.ID]; !reflect.DeepEqual(s2, s) {
 		t.Errorf("s2 = %+v\nwant %+v", s2, s)
 	}
@@ -1473,7 +1513,7 @@ func TestHandleWipeout(t *testing.T) {
 		}
 	}))
 	defer fb.Close()
-	config.Firebase.Shards = []string{fb.URL}
+	config().Firebase.Shards = []string{fb.URL}
 
 	r, _ := aetestInstance.NewRequest("GET", "/task/wipeout", nil)
 	r.Header.Set("x-appengine-cron", "true")
@@ -1496,3 +1536,165 @@ func compareStringSlices(a, b []string) bool {
 	sort.Strings(b)
 	return reflect.DeepEqual(a, b)
 }
+
+func TestScheduleRollback(t *testing.T) {
+	defer resetTestState(t)
+	r := newTestRequest(t, "GET", "/", nil)
+	c := newContext(r)
+
+	var versions []*eventData
+	for i, title := range []string{"Old", "New"} {
+		d := &eventData{
+			modified: time.Now().Add(time.Duration(i-2) * time.Minute),
+			Sessions: map[string]*eventSession{"s": {ID: "s", Title: title}},
+		}
+		if err := storeEventData(c, d); err != nil {
+			t.Fatal(err)
+		}
+		versions = append(versions, d)
+	}
+	old, cur := versions[0], versions[1]
+
+	w := httptest.NewRecorder()
+	serveScheduleVersions(w, newTestRequest(t, "GET", "/admin/schedule/versions?limit=1000", nil))
+	var list []struct {
+		Etag     string `json:"etag"`
+		Current  bool   `json:"current"`
+		Sessions int    `json:"sessions"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+	if len(list) != 2 || list[0].Etag != cur.etag || !list[0].Current || list[1].Etag != old.etag || list[1].Sessions != 1 {
+		t.Errorf("versions = %+v; want %s (current) and %s", list, cur.etag, old.etag)
+	}
+
+	w = httptest.NewRecorder()
+	serveScheduleVersions(w, newTestRequest(t, "GET", "/admin/schedule/versions/"+old.etag, nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"title": "Old"`) {
+		t.Errorf("versions/%s: %d %s; want Old session", old.etag, w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	serveScheduleDiff(w, newTestRequest(t, "GET", "/admin/schedule/diff?from="+old.etag, nil))
+	dc := &dataChanges{}
+	if err := json.Unmarshal(w.Body.Bytes(), dc); err != nil {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+	if s := dc.Sessions["s"]; s == nil || s.Title != "New" {
+		t.Errorf("diff: dc.Sessions = %v; want s titled New", dc.Sessions)
+	}
+
+	w = httptest.NewRecorder()
+	handleScheduleRollback(w, newTestRequest(t, "POST", "/admin/schedule/rollback?etag="+old.etag, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("rollback: %d %s", w.Code, w.Body)
+	}
+	data, err := getLatestEventData(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.etag == old.etag || data.etag == cur.etag || data.Sessions["s"].Title != "Old" {
+		t.Errorf("latest: etag = %s, session = %+v; want a new version of Old", data.etag, data.Sessions["s"])
+	}
+	dc, err = getChangesSince(c, cur.modified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := dc.Sessions["s"]; s == nil || s.Title != "Old" {
+		t.Errorf("changes: dc.Sessions = %v; want s titled Old", dc.Sessions)
+	}
+
+	w = httptest.NewRecorder()
+	handleScheduleRollback(w, newTestRequest(t, "POST", "/admin/schedule/rollback?etag="+data.etag, nil))
+	if w.Code != http.StatusConflict {
+		t.Errorf("rollback to current: w.Code = %d; want %d", w.Code, http.StatusConflict)
+	}
+}
+
+func TestServeScheduleChanges(t *testing.T) {
+	defer resetTestState(t)
+	defer preserveConfig()()
+	config().Env = "prod"
+	r := newTestRequest(t, "GET", "/", nil)
+	c := newContext(r)
+
+	start := time.Now().Add(-3 * time.Minute).Truncate(time.Second)
+	v1 := &eventData{
+		modified: start,
+		Sessions: map[string]*eventSession{
+			"kept":      {ID: "kept", Title: "Kept"},
+			"cancelled": {ID: "cancelled", Title: "Cancelled"},
+		},
+	}
+	v2 := &eventData{
+		modified: start.Add(time.Minute),
+		Sessions: map[string]*eventSession{
+			"kept": {ID: "kept", Title: "Renamed"},
+			"new":  {ID: "new", Title: "New"},
+		},
+	}
+	if err := storeEventData(c, v1); err != nil {
+		t.Fatal(err)
+	}
+	if err := storeEventData(c, v2); err != nil {
+		t.Fatal(err)
+	}
+	if err := storeChanges(c, diffEventData(v1, v2)); err != nil {
+		t.Fatal(err)
+	}
+
+	type response struct {
+		Resync   bool                     `json:"resync"`
+		Etag     string                   `json:"etag"`
+		Sessions map[string]*eventSession `json:"sessions"`
+	}
+	tests := []struct {
+		since  string
+		resync bool
+		update map[string]string // session ID: Update
+	}{
+		{v1.etag, false, map[string]string{"kept": updateDetails, "cancelled": updateRemoved, "new": updateAdded}},
+		{`"` + v1.etag + `"`, false, map[string]string{"kept": updateDetails, "cancelled": updateRemoved, "new": updateAdded}},
+		{strconv.FormatInt(start.Unix(), 10), false, map[string]string{"kept": updateDetails, "cancelled": updateRemoved, "new": updateAdded}},
+		{v2.etag, false, map[string]string{}},
+		{v2.modified.Format(time.RFC3339), false, map[string]string{}},
+		{"unknown-etag", true, map[string]string{}},
+		{strings.Repeat("0", 32), true, map[string]string{}},
+		{start.Add(-time.Hour).Format(time.RFC3339), true, map[string]string{}},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		serveScheduleChanges(w, newTestRequest(t, "GET", "/api/v1/schedule/changes?since="+url.QueryEscape(test.since), nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: w.Code = %d; want 200", test.since, w.Code)
+			continue
+		}
+		var res response
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Errorf("%s: %v: %s", test.since, err, w.Body)
+			continue
+		}
+		if res.Resync != test.resync || res.Etag != v2.etag {
+			t.Errorf("%s: resync = %v, etag = %q; want %v, %q", test.since, res.Resync, res.Etag, test.resync, v2.etag)
+		}
+		update := make(map[string]string)
+		for id, s := range res.Sessions {
+			update[id] = s.Update
+		}
+		if !reflect.DeepEqual(update, test.update) {
+			t.Errorf("%s: sessions = %v; want %v", test.since, update, test.update)
+		}
+	}
+
+	// etags are resolved w/o loading event data, and only once
+	if _, err := cache.get(c, eventDataTimeCacheKey+v1.etag); err != nil {
+		t.Errorf("cache.get(%q): %v", eventDataTimeCacheKey+v1.etag, err)
+	}
+
+	w := httptest.NewRecorder()
+	serveScheduleChanges(w, newTestRequest(t, "GET", "/api/v1/schedule/changes", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("no since: w.Code = %d; want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// readinessTimeout limits the duration of each readinessCheck.
+var readinessTimeout = 5 * time.Second
+
+// readinessCheck is a single dependency check of serveReadiness.
+type readinessCheck struct {
+	name string
+	fn   func(c context.Context) error
+}
+
+// checkResult is the outcome of a readinessCheck.
+type checkResult struct {
+	OK       bool    `json:"ok"`
+	Duration float64 `json:"ms"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// serveLiveness responds with 200 OK as long as the server is able to serve requests.
+func serveLiveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain;charset=utf-8")
+	w.Write([]byte("ok"))
+}
+
+// serveReadiness runs all readinessChecks concurrently and responds
+// with a JSON breakdown of their results and timings.
+// Checks which don't complete within readinessTimeout are reported as failed
+// without waiting for them.
+// The response code is 503 (Service Unavailable) if any of the checks failed.
+// Only users with at least viewer role, or anyone in dev env, get the breakdown;
+// others get the overall status.
+func serveReadiness(w http.ResponseWriter, r *http.Request) {
+	c := newContext(r)
+	tc, cancel := context.WithTimeout(c, readinessTimeout)
+	defer cancel()
+	type namedResult struct {
+		name string
+		cr   *checkResult
+	}
+	checks := readinessChecks()
+	done := make(chan namedResult, len(checks))
+	for _, ch := range checks {
+		go func(ch readinessCheck) {
+			start := time.Now()
+			err := ch.fn(tc)
+			cr := &checkResult{
+				OK:       err == nil,
+				Duration: float64(time.Since(start)) / float64(time.Millisecond),
+			}
+			if err != nil {
+				cr.Error = err.Error()
+			}
+			done <- namedResult{ch.name, cr}
+		}(ch)
+	}
+
+	res := make(map[string]*checkResult, len(checks))
+	timeout := time.After(readinessTimeout)
+wait:
+	for range checks {
+		select {
+		case nr := <-done:
+			res[nr.name] = nr.cr
+		case <-timeout:
+			break wait
+		}
+	}
+	for _, ch := range checks {
+		if res[ch.name] == nil {
+			res[ch.name] = &checkResult{
+				Duration: float64(readinessTimeout) / float64(time.Millisecond),
+				Error:    fmt.Sprintf("timed out after %s", readinessTimeout),
+			}
+		}
+	}
+
+	ready := true
+	for name, cr := range res {
+		if !cr.OK {
+			errorf(c, "readiness %s: %s", name, cr.Error)
+		}
+		ready = ready && cr.OK
+	}
+	body := map[string]interface{}{"ready": ready}
+	if canViewReadiness(r) {
+		body["checks"] = res
+	}
+	b, err := json.MarshalIndent(body, "", "  ")
+	if err != nil {
+		writeJSONError(c, w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(b)
+}
+
+// canViewReadiness reports whether r may see details of readiness checks,
+// which include dependency errors.
+func canViewReadiness(r *http.Request) bool {
+	if isDev() {
+		return true
+	}
+	email, err := sessionEmail(r)
+	return err == nil && userRole(email) >= roleViewer
+}
+
+// readinessChecks returns the list of dependencies checked by serveReadiness.
+func readinessChecks() []readinessCheck {
+	checks := []readinessCheck{
+		{"eventdata", checkEventData},
+		{"cache", checkCache},
+		{"templates", checkTemplates},
+	}
+	for i, s := range config().Firebase.Shards {
+		shard := s
+		checks = append(checks, readinessCheck{
+			name: fmt.Sprintf("firebase.shards[%d]", i),
+			fn:   func(c context.Context) error { return checkFirebaseShard(c, shard) },
+		})
+	}
+	return checks
+}
+
+// checkEventData verifies the latest event data can be loaded.
+func checkEventData(c context.Context) error {
+	_, err := getLatestEventData(c, nil)
+	return err
+}
+
+// checkCache verifies a random value round-trips through the cache.
+func checkCache(c context.Context) error {
+	key := "readiness:" + strconv.FormatInt(rand.Int63(), 36)
+	v := []byte(time.Now().String())
+	if err := cache.set(c, key, v, time.Minute); err != nil {
+		return err
+	}
+	defer cache.deleteMulti(c, []string{key})
+	b, err := cache.get(c, key)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(b, v) {
+		return fmt.Errorf("cache.get(%q) = %q; want %q", key, b, v)
+	}
+	return nil
+}
+
+// checkTemplates verifies the main page templates parse.
+func checkTemplates(c context.Context) error {
+	for _, name := range []string{"home", "schedule"} {
+		if _, err := parseTemplate(name, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkFirebaseShard verifies shard answers a shallow read of the root
+// within readinessTimeout.
+func checkFirebaseShard(c context.Context, shard string) error {
+	hc := firebaseClient(c)
+	hc.Timeout = readinessTimeout
+	res, err := hc.Get(shard + "/.json?shallow=true")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", shard, res.Status)
+	}
+	return nil
+}
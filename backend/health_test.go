@@ -0,0 +1,136 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestServeReadiness(t *testing.T) {
+	defer preserveConfig()()
+	defer func(c cacheInterface, s eventStore, tr func(context.Context) http.RoundTripper) {
+		cache, store, httpTransport = c, s, tr
+	}(cache, store, httpTransport)
+	httpTransport = func(context.Context) http.RoundTripper { return http.DefaultTransport }
+	cache = newMemoryCache(10)
+	var err error
+	if store, err = newFileStore(""); err != nil {
+		t.Fatal(err)
+	}
+
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.json" || r.FormValue("shallow") != "true" {
+			t.Errorf("r.URL = %s; want /.json?shallow=true", r.URL)
+		}
+		w.Write([]byte(`{"users": true}`))
+	}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer down.Close()
+	stuck := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stuck
+	}))
+	defer slow.Close()
+	defer close(stuck)
+	defer func(d time.Duration) { readinessTimeout = d }(readinessTimeout)
+	readinessTimeout = 100 * time.Millisecond
+
+	tests := []struct {
+		shards []string
+		code   int
+		failed string
+	}{
+		{[]string{up.URL}, http.StatusOK, ""},
+		{[]string{up.URL, down.URL}, http.StatusServiceUnavailable, "firebase.shards[1]"},
+		{[]string{slow.URL, up.URL}, http.StatusServiceUnavailable, "firebase.shards[0]"},
+	}
+	for i, test := range tests {
+		config().Firebase.Shards = test.shards
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/readyz", nil)
+		serveReadiness(w, r)
+		if w.Code != test.code {
+			t.Errorf("%d: w.Code = %d; want %d\nResponse: %s", i, w.Code, test.code, w.Body)
+		}
+		var res struct {
+			Ready  bool
+			Checks map[string]*checkResult
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		if n := 3 + len(test.shards); len(res.Checks) != n {
+			t.Errorf("%d: len(res.Checks) = %d; want %d", i, len(res.Checks), n)
+		}
+		for name, cr := range res.Checks {
+			if cr.OK != (name != test.failed) {
+				t.Errorf("%d: %s: ok = %v, error = %q", i, name, cr.OK, cr.Error)
+			}
+		}
+	}
+
+	// a check which ignores its context doesn't hold the response
+	config().Firebase.Shards = []string{up.URL}
+	st := stuckStore{store, make(chan struct{}), make(chan struct{}, 1)}
+	store = st
+	start := time.Now()
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/readyz", nil)
+	serveReadiness(w, r)
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("stuck store: responded in %s; want about %s", d, readinessTimeout)
+	}
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "timed out") {
+		t.Errorf("stuck store: %d %s; want 503 with eventdata timed out", w.Code, w.Body)
+	}
+
+	close(st.release)
+	<-st.returned
+	store = st.eventStore
+
+	// anonymous users outside of dev get the status only
+	config().Env = "prod"
+	config().Firebase.Shards = []string{down.URL}
+	w = httptest.NewRecorder()
+	serveReadiness(w, r)
+	if w.Code != http.StatusServiceUnavailable || strings.Contains(w.Body.String(), "checks") {
+		t.Errorf("anonymous: %d %s; want 503 w/o checks", w.Code, w.Body)
+	}
+}
+
+// stuckStore is an eventStore whose latestEventData blocks until release is closed,
+// regardless of the context, and signals returned when it returns.
+type stuckStore struct {
+	eventStore
+	release  chan struct{}
+	returned chan struct{}
+}
+
+func (s stuckStore) latestEventData(c context.Context) (*eventDataCache, error) {
+	<-s.release
+	s.returned <- struct{}{}
+	return nil, errNotFound
+}
@@ -0,0 +1,376 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// icsContentType is the content type of iCalendar responses.
+	icsContentType = "text/calendar;charset=utf-8"
+	// icsUIDDomain makes session IDs globally unique in event UIDs.
+	icsUIDDomain = "events.google.com"
+	// icsSequenceCacheKey is the cache key prefix of sessionSequences results,
+	// followed by event data etag.
+	icsSequenceCacheKey = "ics:sequence:"
+	// icsLineLen is the max length of a content line in octets, excluding CRLF.
+	icsLineLen = 75
+)
+
+// icsEscaper escapes TEXT property values as defined in RFC 5545, section 3.3.11.
+var icsEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+)
+
+// serveScheduleICS responds with all sessions in iCalendar format.
+// Sessions can be filtered with "day", "tag" and "room" params,
+// each of which can be repeated to match any of the values:
+//   - day is a day of month of a session, same as "day" field of /api/v1/schedule
+//   - tag is a tag ID, e.g. TOPIC_WEB
+//   - room is a room name, case insensitive
+func serveScheduleICS(w http.ResponseWriter, r *http.Request) {
+	c := newContext(r)
+	if err := r.ParseForm(); err != nil {
+		writeJSONError(c, w, http.StatusBadRequest, err)
+		return
+	}
+	data, err := getLatestEventData(c, nil)
+	if err != nil {
+		writeJSONError(c, w, errStatus(err), err)
+		return
+	}
+	var days []int
+	for _, v := range r.Form["day"] {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeJSONError(c, w, http.StatusBadRequest, "invalid day")
+			return
+		}
+		days = append(days, n)
+	}
+	sessions := filterSessions(data.Sessions, days, r.Form["tag"], r.Form["room"])
+	seq, err := sessionSequences(c, data.etag)
+	if err != nil {
+		writeJSONError(c, w, errStatus(err), err)
+		return
+	}
+	w.Header().Set("Content-Type", icsContentType)
+	w.Write(scheduleICS(data, sessions, seq, scheduleURL(r)))
+}
+
+// serveSessionICS responds with a single session in iCalendar format,
+// identified by the last path element, "/api/v1/schedule/sessions/<id>.ics",
+// as a file download.
+func serveSessionICS(w http.ResponseWriter, r *http.Request) {
+	c := newContext(r)
+	id := path.Base(r.URL.Path)
+	if !strings.HasSuffix(id, ".ics") {
+		writeJSONError(c, w, http.StatusNotFound, "not found")
+		return
+	}
+	id = strings.TrimSuffix(id, ".ics")
+	data, err := getLatestEventData(c, nil)
+	if err != nil {
+		writeJSONError(c, w, errStatus(err), err)
+		return
+	}
+	s, ok := data.Sessions[id]
+	if !ok {
+		writeJSONError(c, w, http.StatusNotFound, "no such session")
+		return
+	}
+	seq, err := sessionSequences(c, data.etag)
+	if err != nil {
+		writeJSONError(c, w, errStatus(err), err)
+		return
+	}
+	w.Header().Set("Content-Type", icsContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+".ics"))
+	w.Write(scheduleICS(data, []*eventSession{s}, seq, scheduleURL(r)))
+}
+
+// scheduleURL returns the absolute URL of the schedule page of the site serving r.
+func scheduleURL(r *http.Request) string {
+	u := &url.URL{Scheme: requestScheme(r), Host: r.Host, Path: path.Join(config().Prefix, "schedule")}
+	return u.String()
+}
+
+// filterSessions returns sessions matching any of days, any of tags and any of rooms,
+// sorted with sortedSessionsList. Empty days, tags or rooms match all sessions.
+func filterSessions(sessions map[string]*eventSession, days []int, tags, rooms []string) []*eventSession {
+	var res []*eventSession
+	for _, s := range sessions {
+		if len(days) > 0 && !containsInt(days, s.Day) {
+			continue
+		}
+		if len(tags) > 0 && !hasAnyTag(s.Tags, tags) {
+			continue
+		}
+		if len(rooms) > 0 && !hasRoom(rooms, s.Room) {
+			continue
+		}
+		res = append(res, s)
+	}
+	sort.Sort(sortedSessionsList(res))
+	return res
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyTag(tags, want []string) bool {
+	for _, t := range tags {
+		for _, w := range want {
+			if t == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasRoom(rooms []string, room string) bool {
+	for _, r := range rooms {
+		if strings.EqualFold(r, room) {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionSequences returns the number of details updates of each session
+// in the change log, used as SEQUENCE of the session events; see getSessionSequences.
+// Results are cached for each version of event data, identified by etag.
+func sessionSequences(c context.Context, etag string) (map[string]int, error) {
+	key := icsSequenceCacheKey + etag
+	seq := make(map[string]int)
+	if b, err := cache.get(c, key); err == nil && json.Unmarshal(b, &seq) == nil {
+		return seq, nil
+	}
+	seq, err := getSessionSequences(c)
+	if err != nil {
+		return nil, err
+	}
+	if b, err := json.Marshal(seq); err == nil {
+		if err := cache.set(c, key, b, time.Hour); err != nil {
+			errorf(c, "sessionSequences: cache.set(%q): %v", key, err)
+		}
+	}
+	return seq, nil
+}
+
+// scheduleICS formats sessions of d as an iCalendar object with a VEVENT for each session.
+// seq is the SEQUENCE of each session, as returned by sessionSequences,
+// and link is the schedule page URL which session IDs are appended to.
+func scheduleICS(d *eventData, sessions []*eventSession, seq map[string]int, link string) []byte {
+	cfg := config()
+	loc := cfg.Schedule.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	var b icsWriter
+	b.line("BEGIN:VCALENDAR")
+	b.line("VERSION:2.0")
+	b.line("PRODID:-//Google Inc//Google I/O 2016//EN")
+	b.line("CALSCALE:GREGORIAN")
+	b.line("METHOD:PUBLISH")
+	b.prop("X-WR-CALNAME", "Google I/O 2016")
+	b.line("X-WR-TIMEZONE:" + loc.String())
+	if loc != time.UTC {
+		year := cfg.Schedule.Start.In(loc).Year()
+		for _, l := range vtimezone(loc, year) {
+			b.line(l)
+		}
+	}
+
+	stamp := d.modified.UTC().Format("20060102T150405Z")
+	for _, s := range sessions {
+		b.line("BEGIN:VEVENT")
+		b.line(fmt.Sprintf("UID:%s@%s", s.ID, icsUIDDomain))
+		b.line("DTSTAMP:" + stamp)
+		b.line(icsTime("DTSTART", s.StartTime, loc))
+		b.line(icsTime("DTEND", s.EndTime, loc))
+		b.line(fmt.Sprintf("SEQUENCE:%d", seq[s.ID]))
+		b.prop("SUMMARY", s.Title)
+		if desc := sessionICSDesc(d, s); desc != "" {
+			b.prop("DESCRIPTION", desc)
+		}
+		if s.Room != "" {
+			b.prop("LOCATION", s.Room)
+		}
+		var cats []string
+		for _, t := range s.Tags {
+			if tag, ok := d.Tags[t]; ok {
+				cats = append(cats, icsEscaper.Replace(tag.Name))
+			}
+		}
+		if len(cats) > 0 {
+			b.line("CATEGORIES:" + strings.Join(cats, ","))
+		}
+		b.line("URL:" + link + "?sid=" + url.QueryEscape(s.ID))
+		b.line("END:VEVENT")
+	}
+	b.line("END:VCALENDAR")
+	return b.Bytes()
+}
+
+// sessionICSDesc returns description of s prefixed with names of its speakers.
+// Speakers not found in d are skipped.
+func sessionICSDesc(d *eventData, s *eventSession) string {
+	var names []string
+	for _, id := range s.Speakers {
+		sp, ok := d.Speakers[id]
+		if !ok || sp.Name == "" {
+			continue
+		}
+		if sp.Company != "" {
+			names = append(names, fmt.Sprintf("%s (%s)", sp.Name, sp.Company))
+		} else {
+			names = append(names, sp.Name)
+		}
+	}
+	if len(names) == 0 {
+		return s.Desc
+	}
+	desc := "Speakers: " + strings.Join(names, ", ")
+	if s.Desc != "" {
+		desc += "\n\n" + s.Desc
+	}
+	return desc
+}
+
+// icsTime formats property name of local time t in loc, e.g. "DTSTART;TZID=...:20160518T100000".
+func icsTime(name string, t time.Time, loc *time.Location) string {
+	if loc == time.UTC {
+		return name + ":" + t.UTC().Format("20060102T150405Z")
+	}
+	return fmt.Sprintf("%s;TZID=%s:%s", name, loc, t.In(loc).Format("20060102T150405"))
+}
+
+// vtimezone returns content lines of VTIMEZONE component of loc,
+// with an observance for each offset transition of the year before, the year and the year after.
+// A location without transitions has a single STANDARD observance.
+func vtimezone(loc *time.Location, year int) []string {
+	start := time.Date(year-1, 1, 1, 0, 0, 0, 0, loc)
+	end := time.Date(year+2, 1, 1, 0, 0, 0, 0, loc)
+	// standard time has the smallest offset of the year
+	_, std := time.Date(year, 1, 1, 0, 0, 0, 0, loc).Zone()
+	if _, off := time.Date(year, 7, 1, 0, 0, 0, 0, loc).Zone(); off < std {
+		std = off
+	}
+
+	lines := []string{"BEGIN:VTIMEZONE", "TZID:" + loc.String()}
+	observance := func(at time.Time, from, to int, name string) {
+		kind := "STANDARD"
+		if to > std {
+			kind = "DAYLIGHT"
+		}
+		lines = append(lines,
+			"BEGIN:"+kind,
+			// local time of the transition in the offset before it
+			"DTSTART:"+at.In(time.FixedZone("", from)).Format("20060102T150405"),
+			"TZOFFSETFROM:"+icsOffset(from),
+			"TZOFFSETTO:"+icsOffset(to),
+			"TZNAME:"+name,
+			"END:"+kind,
+		)
+	}
+
+	_, off := start.Zone()
+	found := false
+	for t := start; t.Before(end); t = t.Add(24 * time.Hour) {
+		next := t.Add(24 * time.Hour)
+		name, noff := next.Zone()
+		if noff == off {
+			continue
+		}
+		// find the first second with the new offset
+		i := sort.Search(24*60*60, func(i int) bool {
+			_, o := t.Add(time.Duration(i) * time.Second).Zone()
+			return o != off
+		})
+		observance(t.Add(time.Duration(i)*time.Second), off, noff, name)
+		off = noff
+		found = true
+	}
+	if !found {
+		name, _ := start.Zone()
+		observance(time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), off, off, name)
+	}
+	return append(lines, "END:VTIMEZONE")
+}
+
+// icsOffset formats UTC offset in seconds as defined in RFC 5545, e.g. "-0700".
+func icsOffset(sec int) string {
+	sign := '+'
+	if sec < 0 {
+		sign = '-'
+		sec = -sec
+	}
+	return fmt.Sprintf("%c%02d%02d", sign, sec/3600, sec/60%60)
+}
+
+// icsWriter builds an iCalendar object from content lines.
+type icsWriter struct {
+	bytes.Buffer
+}
+
+// prop writes a TEXT property name with value v escaped.
+func (w *icsWriter) prop(name, v string) {
+	w.line(name + ":" + icsEscaper.Replace(v))
+}
+
+// line writes a content line terminated with CRLF,
+// folding it into multiple lines of at most icsLineLen octets.
+func (w *icsWriter) line(l string) {
+	max := icsLineLen
+	for len(l) > max {
+		// don't split multi-octet characters
+		i := max
+		for i > 0 && !utf8.RuneStart(l[i]) {
+			i--
+		}
+		w.WriteString(l[:i])
+		w.WriteString("\r\n ")
+		l = l[i:]
+		// continuation lines start with a space
+		max = icsLineLen - 1
+	}
+	w.WriteString(l)
+	w.WriteString("\r\n")
+}
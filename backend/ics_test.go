@@ -0,0 +1,219 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduleICS(t *testing.T) {
+	defer preserveConfig()()
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skipf("no tz database: %v", err)
+	}
+	config().Schedule.Location = loc
+	config().Schedule.Start = time.Date(2016, 5, 18, 10, 0, 0, 0, loc)
+
+	start := time.Date(2016, 5, 18, 14, 0, 0, 0, loc)
+	d := &eventData{
+		modified: time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC),
+		Speakers: map[string]*eventSpeaker{
+			"spk-1": {ID: "spk-1", Name: "Jane Doe", Company: "Google"},
+		},
+		Tags: map[string]*eventTag{
+			"TOPIC_WEB": {Tag: "TOPIC_WEB", Name: "Mobile Web"},
+		},
+	}
+	s := &eventSession{
+		ID:        "s1",
+		Title:     "Progressive, web; apps",
+		Desc:      "Line one\nline two",
+		Room:      "Stage 3",
+		Speakers:  []string{"spk-1", "unknown"},
+		Tags:      []string{"TOPIC_WEB"},
+		StartTime: start,
+		EndTime:   start.Add(time.Hour),
+	}
+	b := scheduleICS(d, []*eventSession{s}, map[string]int{"s1": 2}, "https://example.org/io2016/schedule")
+	// unfold lines
+	ics := strings.Replace(string(b), "\r\n ", "", -1)
+	lines := strings.Split(strings.TrimSuffix(ics, "\r\n"), "\r\n")
+
+	want := []string{
+		"UID:s1@" + icsUIDDomain,
+		"DTSTAMP:20160501T000000Z",
+		"DTSTART;TZID=America/Los_Angeles:20160518T140000",
+		"DTEND;TZID=America/Los_Angeles:20160518T150000",
+		"SEQUENCE:2",
+		`SUMMARY:Progressive\, web\; apps`,
+		`DESCRIPTION:Speakers: Jane Doe (Google)\n\nLine one\nline two`,
+		"LOCATION:Stage 3",
+		"CATEGORIES:Mobile Web",
+		"URL:https://example.org/io2016/schedule?sid=s1",
+		// DST of 2016 starts on March 13 at 2 AM PST
+		"BEGIN:DAYLIGHT",
+		"DTSTART:20160313T020000",
+		"TZOFFSETFROM:-0800",
+		"TZOFFSETTO:-0700",
+		"TZNAME:PDT",
+	}
+	for _, l := range want {
+		if !strings.Contains(ics, "\r\n"+l+"\r\n") {
+			t.Errorf("missing %q in:\n%s", l, ics)
+		}
+	}
+	if lines[0] != "BEGIN:VCALENDAR" || lines[len(lines)-1] != "END:VCALENDAR" {
+		t.Errorf("first, last lines = %q, %q; want BEGIN:VCALENDAR, END:VCALENDAR", lines[0], lines[len(lines)-1])
+	}
+	for _, l := range strings.Split(string(b), "\r\n") {
+		if len(l) > icsLineLen {
+			t.Errorf("%d octets line: %q", len(l), l)
+		}
+	}
+}
+
+func TestICSWriterFold(t *testing.T) {
+	t.Parallel()
+	var w icsWriter
+	v := strings.Repeat("é", 100)
+	w.prop("SUMMARY", v)
+	lines := strings.Split(strings.TrimSuffix(w.String(), "\r\n"), "\r\n")
+	if len(lines) < 3 {
+		t.Fatalf("len(lines) = %d; want at least 3", len(lines))
+	}
+	for i, l := range lines {
+		if len(l) > icsLineLen {
+			t.Errorf("%d: len(%q) = %d; want at most %d", i, l, len(l), icsLineLen)
+		}
+		if i > 0 && !strings.HasPrefix(l, " ") {
+			t.Errorf("%d: %q doesn't start with a space", i, l)
+		}
+	}
+	if s := strings.Replace(w.String(), "\r\n ", "", -1); s != "SUMMARY:"+v+"\r\n" {
+		t.Errorf("unfolded = %q; want SUMMARY:%s", s, v)
+	}
+}
+
+func TestFilterSessions(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2016, 5, 18, 10, 0, 0, 0, time.UTC)
+	sessions := map[string]*eventSession{
+		"a": {ID: "a", Day: 18, StartTime: start, Room: "Stage 3", Tags: []string{"TOPIC_WEB"}},
+		"b": {ID: "b", Day: 19, StartTime: start.Add(24 * time.Hour), Room: "Stage 5", Tags: []string{"TOPIC_ANDROID", "TOPIC_WEB"}},
+		"c": {ID: "c", Day: 19, StartTime: start.Add(25 * time.Hour), Room: "Stage 3", Tags: []string{"TOPIC_CLOUD"}},
+	}
+	tests := []struct {
+		days  []int
+		tags  []string
+		rooms []string
+		ids   []string
+	}{
+		{nil, nil, nil, []string{"a", "b", "c"}},
+		{[]int{19}, nil, nil, []string{"b", "c"}},
+		{nil, []string{"TOPIC_WEB"}, nil, []string{"a", "b"}},
+		{nil, []string{"TOPIC_CLOUD", "TOPIC_ANDROID"}, []string{"stage 5"}, []string{"b"}},
+		{[]int{19}, nil, []string{"Stage 3"}, []string{"c"}},
+		{[]int{20}, nil, nil, nil},
+	}
+	for i, test := range tests {
+		var ids []string
+		for _, s := range filterSessions(sessions, test.days, test.tags, test.rooms) {
+			ids = append(ids, s.ID)
+		}
+		if !reflect.DeepEqual(ids, test.ids) {
+			t.Errorf("%d: ids = %v; want %v", i, ids, test.ids)
+		}
+	}
+}
+
+func TestServeSessionICS(t *testing.T) {
+	defer resetTestState(t)
+	defer preserveConfig()()
+	config().Env = "prod"
+	config().Schedule.Location = time.UTC
+	c := newContext(newTestRequest(t, "GET", "/", nil))
+
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	s := &eventSession{ID: "s1", Title: "Moved", StartTime: start, EndTime: start.Add(time.Hour)}
+	if err := storeEventData(c, &eventData{
+		modified: start.Add(-time.Hour),
+		Sessions: map[string]*eventSession{"s1": s},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// a change log written before session sequences were stored:
+	// a corrupt entry and two updates sharing a timestamp across pages
+	defer func(n int) { changesPageSize = n }(changesPageSize)
+	changesPageSize = 3
+	update := []byte(`{"sessions": {"s1": {"id": "s1", "update": "details"}}}`)
+	for _, d := range []time.Duration{-3 * time.Hour, -2 * time.Hour, -2 * time.Hour} {
+		if err := store.putChanges(c, &changesEntity{Timestamp: start.Add(d), Bytes: update}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.putChanges(c, &changesEntity{Timestamp: start.Add(-150 * time.Minute), Bytes: []byte("garbage")}); err != nil {
+		t.Fatal(err)
+	}
+	// counted from the change log once, then incrementally
+	for _, d := range []time.Duration{-90 * time.Minute, -time.Hour} {
+		if err := storeChanges(c, &dataChanges{
+			Updated: start.Add(d),
+			eventData: eventData{Sessions: map[string]*eventSession{
+				"s1": {ID: "s1", Update: updateDetails},
+			}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.latestSequences(c); err != nil {
+		t.Errorf("store.latestSequences: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	r := newTestRequest(t, "GET", "/api/v1/schedule/sessions/s1.ics", nil)
+	serveSessionICS(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("w.Code = %d; want 200", w.Code)
+	}
+	if v := w.Header().Get("content-disposition"); v != `attachment; filename="s1.ics"` {
+		t.Errorf("content-disposition = %q", v)
+	}
+	body := w.Body.String()
+	for _, l := range []string{
+		"UID:s1@" + icsUIDDomain,
+		"SEQUENCE:5",
+		"DTSTART:" + start.UTC().Format("20060102T150405Z"),
+		"URL:https://" + r.Host + "/myprefix/schedule?sid=s1",
+	} {
+		if !strings.Contains(body, "\r\n"+l+"\r\n") {
+			t.Errorf("missing %q in:\n%s", l, body)
+		}
+	}
+	if strings.Contains(body, "VTIMEZONE") {
+		t.Errorf("UTC schedule has VTIMEZONE:\n%s", body)
+	}
+
+	w = httptest.NewRecorder()
+	serveSessionICS(w, newTestRequest(t, "GET", "/api/v1/schedule/sessions/missing.ics", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing: w.Code = %d; want 404", w.Code)
+	}
+}
@@ -52,7 +52,7 @@ type extEntry struct {
 // ioExtEntries fetches I/O Extended items either from cache or a spreadsheet.
 // Cache can be invalidated by providing refresh = true.
 func ioExtEntries(c context.Context, refresh bool) ([]*extEntry, error) {
-	feedURL := config.IoExtFeedURL
+	feedURL := config().IoExtFeedURL
 	if !refresh {
 		entries, err := ioExtEntriesFromCache(c, feedURL)
 		if err == nil {
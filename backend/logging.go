@@ -0,0 +1,104 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// correlationParam is the task param carrying correlation ID
+	// from a trigger, like sync or clock, to all tasks it has spawned.
+	correlationParam = "cid"
+
+	// log levels
+	levelInfo  = "info"
+	levelError = "error"
+)
+
+// logFieldsKey is the context key of log fields attached with withLogFields.
+type logFieldsKey struct{}
+
+// withLogFields returns a copy of c with key-value pairs kv attached to all log entries
+// of the returned context. Keys must be strings. Empty values are ignored.
+func withLogFields(c context.Context, kv ...interface{}) context.Context {
+	old, _ := c.Value(logFieldsKey{}).(map[string]interface{})
+	fields := make(map[string]interface{}, len(old)+len(kv)/2)
+	for k, v := range old {
+		fields[k] = v
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		if v := kv[i+1]; v != nil && v != "" {
+			fields[kv[i].(string)] = v
+		}
+	}
+	return context.WithValue(c, logFieldsKey{}, fields)
+}
+
+// withRequestFields attaches log fields of the request r to c:
+// handler path and task retry count if r is a task.
+func withRequestFields(c context.Context, r *http.Request) context.Context {
+	kv := []interface{}{"handler", r.URL.Path}
+	if v := r.Header.Get("x-appengine-taskretrycount"); v != "" {
+		n, _ := strconv.Atoi(v)
+		kv = append(kv, "retry", n)
+	}
+	return withLogFields(c, kv...)
+}
+
+// correlationID returns correlation ID attached to c with withCorrelationID, if any.
+func correlationID(c context.Context) string {
+	fields, _ := c.Value(logFieldsKey{}).(map[string]interface{})
+	id, _ := fields[correlationParam].(string)
+	return id
+}
+
+// withCorrelationID attaches correlation ID to c.
+// A new ID is generated if id is empty.
+func withCorrelationID(c context.Context, id string) context.Context {
+	if id == "" {
+		b := make([]byte, 8)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+	return withLogFields(c, correlationParam, id)
+}
+
+// logEntry formats a structured log entry of msg with all fields of c.
+// Reserved fields, like msg, are set last so they can't be overwritten.
+func logEntry(c context.Context, level, msg string, extra ...interface{}) string {
+	fields, _ := c.Value(logFieldsKey{}).(map[string]interface{})
+	e := make(map[string]interface{}, len(fields)+len(extra)/2+2)
+	for k, v := range fields {
+		e[k] = v
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		e[extra[i].(string)] = extra[i+1]
+	}
+	e["level"] = level
+	e["msg"] = msg
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Sprintf(`{"level": %q, "msg": %q}`, level, msg)
+	}
+	return string(b)
+}
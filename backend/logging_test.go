@@ -0,0 +1,92 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestLogEntry(t *testing.T) {
+	r, _ := http.NewRequest("POST", "/task/notify-user", nil)
+	r.Header.Set("X-AppEngine-TaskRetryCount", "2")
+	c := withRequestFields(context.Background(), r)
+	c = withCorrelationID(c, "abc")
+	c = withLogFields(c, "uid", "123", "shard", "", "msg", "overwritten")
+
+	var e map[string]interface{}
+	if err := json.Unmarshal([]byte(logEntry(c, levelError, "oops")), &e); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"handler": "/task/notify-user",
+		"retry":   float64(2),
+		"cid":     "abc",
+		"uid":     "123",
+		"level":   "error",
+		"msg":     "oops",
+	}
+	if !reflect.DeepEqual(e, want) {
+		t.Errorf("logEntry = %v; want %v", e, want)
+	}
+	if id := correlationID(c); id != "abc" {
+		t.Errorf("correlationID = %q; want abc", id)
+	}
+	if id := correlationID(withCorrelationID(context.Background(), "")); len(id) != 16 {
+		t.Errorf("correlationID = %q; want 16 hex chars", id)
+	}
+}
+
+// recordingQueue is a taskQueue which keeps all added tasks.
+type recordingQueue struct {
+	tasks []*task
+}
+
+func (q *recordingQueue) add(c context.Context, t *task, qname string) error {
+	q.tasks = append(q.tasks, t)
+	return nil
+}
+
+func TestCorrelationIDTaskParams(t *testing.T) {
+	defer func(q taskQueue) { queue = q }(queue)
+	rq := &recordingQueue{}
+	queue = rq
+
+	c := withCorrelationID(context.Background(), "abc")
+	if err := notifySubscribersAsync(c, &dataChanges{}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := notifyShardAsync(c, "shard", "{}", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := notifyUserAsync(c, "123", "shard", &pushMessage{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tsk := range rq.tasks {
+		params, err := url.ParseQuery(string(tsk.Payload))
+		if err != nil {
+			t.Errorf("%s: %v", tsk.Path, err)
+			continue
+		}
+		if v := params.Get(correlationParam); v != "abc" {
+			t.Errorf("%s: %s = %q; want abc", tsk.Path, correlationParam, v)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// sessionCookie holds signed email of the logged in user.
+	sessionCookie = "session"
+	// loginStateCookie holds signed OAuth2 state and the URL to redirect to after login.
+	loginStateCookie = "login_state"
+	// sessionDuration is how long a login session lasts.
+	sessionDuration = 24 * time.Hour
+	// loginStateDuration is how long users have to complete the login flow.
+	loginStateDuration = 10 * time.Minute
+
+	// default OpenID Connect endpoints if not specified in config.Google.Auth.
+	googleAuthURL  = "https://accounts.google.com/o/oauth2/v2/auth"
+	googleTokenURL = "https://www.googleapis.com/oauth2/v4/token"
+)
+
+// allow requests prefixed with passthruPrefixes to bypass checkWhitelist
+var passthruPrefixes = []string{
+	"/manifest.json",
+	"/sync",
+	"/api/v1/user",
+	"/auth/",
+}
+
+// initWhitelist prepends config.Prefix to passthruPrefixes.
+// It must be called once, after the config has been initialized.
+func initWhitelist() {
+	for i, p := range passthruPrefixes {
+		passthruPrefixes[i] = path.Join(config().Prefix, p)
+		if strings.HasSuffix(p, "/") {
+			passthruPrefixes[i] += "/"
+		}
+	}
+}
+
+// allowPassthrough returns true if the request r can be handled w/o whitelist check.
+// Currently, only Cron and Task Queue jobs, and passthruPrefixes are allowed.
+func allowPassthrough(r *http.Request) bool {
+	if r.Header.Get("x-appengine-cron") == "true" || r.Header.Get("x-appengine-taskname") != "" {
+		return true
+	}
+	for _, p := range passthruPrefixes {
+		if strings.HasPrefix(r.URL.Path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWhitelist checks whether the current user is allowed to access
+// handler h using isWhitelisted() func before handing over in-flight request.
+// The user is identified by the session cookie set in handleLoginCallback.
+// It redirects to the login handler if no user found or responds with 403
+// (Forbidden) HTTP error code if the current user is not whitelisted.
+// All requests are allowed if config.Whitelist is empty.
+func checkWhitelist(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(config().Whitelist) == 0 || allowPassthrough(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		c := newContext(r)
+		email, err := sessionEmail(r)
+		switch {
+		case err == nil && isWhitelisted(email):
+			h.ServeHTTP(w, r)
+		case err == nil:
+			errorf(c, "%s is not whitelisted", email)
+			http.Error(w, "Access denied, sorry. Try with a different account.", http.StatusForbidden)
+		default:
+			redirectLogin(w, r, r.URL.RequestURI())
+		}
+	})
+}
+
+// redirectLogin redirects to the login handler, which sends the user back
+// to next URL after a successful login.
+func redirectLogin(w http.ResponseWriter, r *http.Request, next string) {
+	q := url.Values{"next": {next}}
+	http.Redirect(w, r, path.Join(config().Prefix, "/auth/login")+"?"+q.Encode(), http.StatusFound)
+}
+
+// handleLogin redirects to the OpenID Connect provider authorization endpoint,
+// after setting login state cookie to be verified in handleLoginCallback.
+// The user is redirected back to "next" URL param after a successful login.
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	c := newContext(r)
+	cfg := config()
+	next := r.FormValue("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		next = cfg.Prefix + "/"
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		writeError(w, err)
+		return
+	}
+	state := base64.RawURLEncoding.EncodeToString(b)
+	v, err := signCookie(loginStateCookie, state+" "+next, loginStateDuration)
+	if err != nil {
+		errorf(c, "handleLogin: %v", err)
+		http.Error(w, "Login is not configured.", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginStateCookie,
+		Value:    v,
+		Path:     path.Join(cfg.Prefix, "/auth/"),
+		MaxAge:   int(loginStateDuration / time.Second),
+		HttpOnly: true,
+		Secure:   requestScheme(r) == "https",
+	})
+	http.Redirect(w, r, oauth2Login(r).AuthCodeURL(state), http.StatusFound)
+}
+
+// handleLoginCallback completes the login flow started in handleLogin.
+// It exchanges authorization code for an ID token, sets session cookie
+// with the token email and sends the user back to the URL they came from.
+func handleLoginCallback(w http.ResponseWriter, r *http.Request) {
+	c := newContext(r)
+	cfg := config()
+	if e := r.FormValue("error"); e != "" {
+		errorf(c, "handleLoginCallback: %s", e)
+		http.Error(w, "Login failed: "+e, http.StatusForbidden)
+		return
+	}
+	var state, next string
+	if ck, err := r.Cookie(loginStateCookie); err == nil {
+		v, _ := verifyCookie(loginStateCookie, ck.Value)
+		if i := strings.IndexByte(v, ' '); i > 0 {
+			state, next = v[:i], v[i+1:]
+		}
+	}
+	if state == "" || r.FormValue("state") != state {
+		http.Error(w, "Invalid login state. Try again.", http.StatusBadRequest)
+		return
+	}
+
+	email, err := exchangeLoginCode(c, oauth2Login(r), r.FormValue("code"))
+	if err != nil {
+		errorf(c, "handleLoginCallback: %v", err)
+		http.Error(w, "Login failed. Try again.", http.StatusForbidden)
+		return
+	}
+	v, err := signCookie(sessionCookie, email, sessionDuration)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	setSessionCookie(w, r, v)
+	http.SetCookie(w, &http.Cookie{
+		Name:   loginStateCookie,
+		Path:   path.Join(cfg.Prefix, "/auth/"),
+		MaxAge: -1,
+	})
+	// the callback is a cross-site navigation from the provider,
+	// which the session cookie wouldn't be sent with after an HTTP redirect
+	sameSiteRedirect(w, next)
+}
+
+// handleLogout removes the session cookie and redirects to the site root.
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	setSessionCookie(w, r, "")
+	http.Redirect(w, r, config().Prefix+"/", http.StatusFound)
+}
+
+// setSessionCookie sets session cookie to the signed value v, or removes it if v is empty.
+// The cookie is SameSite=Strict so that it isn't sent with requests made by other sites,
+// e.g. a form posting to a debug handler.
+func setSessionCookie(w http.ResponseWriter, r *http.Request, v string) {
+	ck := &http.Cookie{
+		Name:     sessionCookie,
+		Value:    v,
+		Path:     config().Prefix,
+		MaxAge:   int(sessionDuration / time.Second),
+		HttpOnly: true,
+		Secure:   requestScheme(r) == "https",
+	}
+	if v == "" {
+		ck.MaxAge = -1
+	}
+	// http.Cookie has no SameSite field
+	w.Header().Add("Set-Cookie", ck.String()+"; SameSite=Strict")
+}
+
+// sameSiteRedirect responds with a page which navigates to URL u of this server.
+// Unlike an HTTP redirect in response to a cross-site request, the navigation is same-site,
+// so SameSite=Strict cookies, like the session cookie, are sent along.
+func sameSiteRedirect(w http.ResponseWriter, u string) {
+	u = html.EscapeString(u)
+	w.Header().Set("Content-Type", "text/html;charset=utf-8")
+	fmt.Fprintf(w, `<!doctype html><meta http-equiv="refresh" content="0;url=%s"><a href="%s">Continue</a>`, u, u)
+}
+
+// oauth2Login returns OAuth2 config of the OpenID Connect provider
+// specified in config.Google.Auth, with redirect URL based on the request r.
+func oauth2Login(r *http.Request) *oauth2.Config {
+	cfg := config()
+	a := cfg.Google.Auth
+	ep := oauth2.Endpoint{AuthURL: a.AuthURL, TokenURL: a.TokenURL}
+	if ep.AuthURL == "" {
+		ep.AuthURL = googleAuthURL
+	}
+	if ep.TokenURL == "" {
+		ep.TokenURL = googleTokenURL
+	}
+	u := &url.URL{
+		Scheme: requestScheme(r),
+		Host:   r.Host,
+		Path:   path.Join(cfg.Prefix, "/auth/callback"),
+	}
+	return &oauth2.Config{
+		ClientID:     a.Client,
+		ClientSecret: a.Secret,
+		Endpoint:     ep,
+		RedirectURL:  u.String(),
+		Scopes:       []string{"openid", "email"},
+	}
+}
+
+// requestScheme returns the URL scheme clients use to reach the server with request r.
+// It is always "https" outside dev env, since App Engine and proxies terminating TLS
+// leave r.TLS empty. In dev env the scheme is taken from X-Forwarded-Proto header, if any,
+// so that login works on a plain HTTP dev server.
+func requestScheme(r *http.Request) string {
+	switch {
+	case !isDev() || r.TLS != nil:
+		return "https"
+	case r.Header.Get("x-forwarded-proto") == "https":
+		return "https"
+	}
+	return "http"
+}
+
+// exchangeLoginCode exchanges authorization code for tokens using cfg
+// and returns verified email of the ID token.
+//
+// The ID token signature is not verified since the token is received directly
+// from the token endpoint over TLS, as allowed by OpenID Connect Core 1.0, section 3.1.3.7.
+func exchangeLoginCode(c context.Context, cfg *oauth2.Config, code string) (string, error) {
+	if code == "" {
+		return "", errors.New("exchangeLoginCode: empty code")
+	}
+	c = context.WithValue(c, oauth2.HTTPClient, httpClient(c))
+	tok, err := cfg.Exchange(c, code)
+	if err != nil {
+		return "", err
+	}
+	idtok, _ := tok.Extra("id_token").(string)
+	parts := strings.Split(idtok, ".")
+	if len(parts) != 3 {
+		return "", errors.New("exchangeLoginCode: missing or malformed id_token")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("exchangeLoginCode: id_token: %v", err)
+	}
+	var claims struct {
+		Aud      interface{} `json:"aud"`
+		Exp      int64       `json:"exp"`
+		Email    string      `json:"email"`
+		Verified bool        `json:"email_verified"`
+	}
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return "", fmt.Errorf("exchangeLoginCode: id_token: %v", err)
+	}
+	var aud []interface{}
+	switch v := claims.Aud.(type) {
+	case string:
+		aud = []interface{}{v}
+	case []interface{}:
+		aud = v
+	}
+	ok := false
+	for _, a := range aud {
+		ok = ok || a == cfg.ClientID
+	}
+	switch {
+	case !ok:
+		return "", fmt.Errorf("exchangeLoginCode: id_token aud = %v; want %q", claims.Aud, cfg.ClientID)
+	case time.Unix(claims.Exp, 0).Before(time.Now()):
+		return "", errors.New("exchangeLoginCode: id_token expired")
+	case claims.Email == "" || !claims.Verified:
+		return "", fmt.Errorf("exchangeLoginCode: email %q is not verified", claims.Email)
+	}
+	return claims.Email, nil
+}
+
+// sessionEmail returns the email of a valid session cookie of the request r.
+// It returns an error if the cookie value is not a single email address.
+func sessionEmail(r *http.Request) (string, error) {
+	ck, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", err
+	}
+	v, err := verifyCookie(sessionCookie, ck.Value)
+	if err != nil {
+		return "", err
+	}
+	if a, err := mail.ParseAddress(v); err != nil || a.Name != "" || a.Address != v {
+		return "", fmt.Errorf("sessionEmail: %q is not an email address", v)
+	}
+	return v, nil
+}
+
+// signCookie creates a value of cookie name containing v which expires after d,
+// signed with config.Google.Auth.CookieKey.
+// The name is signed along with v, so that the value is valid only for that cookie.
+func signCookie(name, v string, d time.Duration) (string, error) {
+	exp := strconv.FormatInt(time.Now().Add(d).Unix(), 10)
+	s := base64.RawURLEncoding.EncodeToString([]byte(exp + " " + name + "\x00" + v))
+	sig, err := cookieSig(s)
+	if err != nil {
+		return "", err
+	}
+	return s + "." + sig, nil
+}
+
+// verifyCookie returns the value of cookie name created with signCookie.
+// It returns an error if the signature does not match, the cookie has expired
+// or it was created for another cookie name.
+func verifyCookie(name, cookie string) (string, error) {
+	i := strings.LastIndexByte(cookie, '.')
+	if i < 0 {
+		return "", errors.New("verifyCookie: malformed cookie")
+	}
+	s := cookie[:i]
+	sig, err := cookieSig(s)
+	if err != nil {
+		return "", err
+	}
+	if !hmac.Equal([]byte(sig), []byte(cookie[i+1:])) {
+		return "", errors.New("verifyCookie: invalid signature")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.SplitN(string(b), " ", 2)
+	if len(parts) != 2 {
+		return "", errors.New("verifyCookie: malformed cookie")
+	}
+	exp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Unix(exp, 0).Before(time.Now()) {
+		return "", errors.New("verifyCookie: expired")
+	}
+	if !strings.HasPrefix(parts[1], name+"\x00") {
+		return "", fmt.Errorf("verifyCookie: not a %s cookie", name)
+	}
+	return parts[1][len(name)+1:], nil
+}
+
+// cookieSig returns HMAC-SHA256 signature of s using config.Google.Auth.CookieKey.
+func cookieSig(s string) (string, error) {
+	key := config().Google.Auth.CookieKey
+	if key == "" {
+		return "", errors.New("cookieSig: empty google.auth.cookieKey")
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(s))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
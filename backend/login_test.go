@@ -0,0 +1,266 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestCheckWhitelist(t *testing.T) {
+	defer preserveConfig()()
+	config().Whitelist = []string{"@whitedomain.org", "white@example.org"}
+	config().Google.Auth.CookieKey = "cookie-key"
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	table := []struct {
+		env   string
+		email string
+		code  int
+	}{
+		{"stage", "", http.StatusFound},
+		{"stage", "dude@example.org", http.StatusForbidden},
+		{"stage", "white@example.org", http.StatusOK},
+		{"stage", "user@whitedomain.org", http.StatusOK},
+		{"prod", "", http.StatusFound},
+		{"prod", "dude@example.org", http.StatusForbidden},
+		{"prod", "white@example.org", http.StatusOK},
+		{"prod", "user@whitedomain.org", http.StatusOK},
+	}
+	for _, test := range table {
+		config().Env = test.env
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/io2016/admin/", nil)
+		if test.email != "" {
+			v, err := signCookie(sessionCookie, test.email, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.AddCookie(&http.Cookie{Name: sessionCookie, Value: v})
+		}
+		checkWhitelist(h).ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("%s: w.Code = %d; want %d %s\nResponse: %s",
+				test.email, w.Code, test.code, w.Header().Get("location"), w.Body.String())
+		}
+		if w.Code == http.StatusOK && w.Body.String() != "ok" {
+			t.Errorf("w.Body = %s; want 'ok'", w.Body.String())
+		}
+	}
+}
+
+func TestVerifyCookie(t *testing.T) {
+	defer preserveConfig()()
+	config().Google.Auth.CookieKey = "cookie-key"
+
+	v, err := signCookie(sessionCookie, "white@example.org", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err := verifyCookie(sessionCookie, v); err != nil || s != "white@example.org" {
+		t.Errorf("verifyCookie(%q) = %q, %v; want white@example.org", v, s, err)
+	}
+	expired, err := signCookie(sessionCookie, "white@example.org", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := strings.LastIndexByte(v, '.')
+	forged := base64.RawURLEncoding.EncodeToString([]byte("9999999999 dude@example.org")) + v[i:]
+	for _, c := range []string{expired, forged, "", "garbage"} {
+		if s, err := verifyCookie(sessionCookie, c); err == nil {
+			t.Errorf("verifyCookie(%q) = %q; want error", c, s)
+		}
+	}
+	if s, err := verifyCookie(loginStateCookie, v); err == nil {
+		t.Errorf("verifyCookie(%q) = %q as %s; want error", v, s, loginStateCookie)
+	}
+	config().Google.Auth.CookieKey = "another-key"
+	if s, err := verifyCookie(sessionCookie, v); err == nil {
+		t.Errorf("verifyCookie(%q) = %q with another key; want error", v, s)
+	}
+}
+
+func TestRequestScheme(t *testing.T) {
+	defer preserveConfig()()
+	table := []struct {
+		env, proto string
+		tls        bool
+		scheme     string
+	}{
+		{"dev", "", false, "http"},
+		{"dev", "", true, "https"},
+		{"dev", "https", false, "https"},
+		{"stage", "", false, "https"},
+		{"prod", "", false, "https"},
+		{"prod", "http", false, "https"},
+	}
+	for _, test := range table {
+		config().Env = test.env
+		r, _ := http.NewRequest("GET", "/myprefix/auth/login", nil)
+		if test.proto != "" {
+			r.Header.Set("x-forwarded-proto", test.proto)
+		}
+		if test.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		if v := requestScheme(r); v != test.scheme {
+			t.Errorf("requestScheme(%+v) = %q; want %q", test, v, test.scheme)
+		}
+	}
+}
+
+// TestLoginFlow runs handleLogin and handleLoginCallback against a local
+// stand-in OpenID Connect provider.
+func TestLoginFlow(t *testing.T) {
+	defer preserveConfig()()
+	defer func(t func(context.Context) http.RoundTripper) { httpTransport = t }(httpTransport)
+	httpTransport = func(context.Context) http.RoundTripper { return http.DefaultTransport }
+
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "test-code" || r.FormValue("client_id") != "test-client-id" && r.Header.Get("authorization") == "" {
+			http.Error(w, "invalid code", http.StatusBadRequest)
+			return
+		}
+		claims, _ := json.Marshal(map[string]interface{}{
+			"aud":            "test-client-id",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"email":          "white@example.org",
+			"email_verified": true,
+		})
+		idtok := "e30." + base64.RawURLEncoding.EncodeToString(claims) + ".sig"
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "at", "token_type": "Bearer", "id_token": %q}`, idtok)
+	}))
+	defer idp.Close()
+
+	config().Prefix = "/myprefix"
+	config().Google.Auth.Client = "test-client-id"
+	config().Google.Auth.Secret = "test-secret"
+	config().Google.Auth.AuthURL = idp.URL + "/auth"
+	config().Google.Auth.TokenURL = idp.URL + "/token"
+	config().Google.Auth.CookieKey = "cookie-key"
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/myprefix/auth/login?next=/myprefix/schedule", nil)
+	r.Host = "example.org"
+	handleLogin(w, r)
+	if w.Code != http.StatusFound {
+		t.Fatalf("handleLogin: w.Code = %d; want 302", w.Code)
+	}
+	loc, err := url.Parse(w.Header().Get("location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := loc.Query().Get("redirect_uri"); v != "http://example.org/myprefix/auth/callback" {
+		t.Errorf("redirect_uri = %q; want http://example.org/myprefix/auth/callback", v)
+	}
+	state := loc.Query().Get("state")
+	cookies := w.HeaderMap["Set-Cookie"]
+	if len(cookies) != 1 {
+		t.Fatalf("handleLogin: cookies = %v; want one", cookies)
+	}
+
+	// callback with invalid state
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/myprefix/auth/callback?code=test-code&state=invalid", nil)
+	r.Header.Set("cookie", cookies[0])
+	handleLoginCallback(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("handleLoginCallback(invalid state): w.Code = %d; want 400", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	q := url.Values{"code": {"test-code"}, "state": {state}}
+	r, _ = http.NewRequest("GET", "/myprefix/auth/callback?"+q.Encode(), nil)
+	r.Host = "example.org"
+	r.Header.Set("cookie", cookies[0])
+	handleLoginCallback(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("handleLoginCallback: w.Code = %d; want 200\nResponse: %s", w.Code, w.Body.String())
+	}
+	if v := `content="0;url=/myprefix/schedule"`; !strings.Contains(w.Body.String(), v) {
+		t.Errorf("w.Body = %s; want it to contain %s", w.Body.String(), v)
+	}
+	if v := w.HeaderMap["Set-Cookie"]; len(v) == 0 || !strings.HasSuffix(v[0], "; SameSite=Strict") {
+		t.Errorf("session cookie = %v; want SameSite=Strict", v)
+	}
+	r, _ = http.NewRequest("GET", "/myprefix/schedule", nil)
+	r.Header.Set("cookie", strings.Join(w.HeaderMap["Set-Cookie"], "; "))
+	if email, err := sessionEmail(r); err != nil || email != "white@example.org" {
+		t.Errorf("sessionEmail() = %q, %v; want white@example.org", email, err)
+	}
+}
+
+// TestLoginStateAsSession replays a login state cookie, which anyone can obtain
+// with an arbitrary "next", as the session cookie.
+func TestLoginStateAsSession(t *testing.T) {
+	defer preserveConfig()()
+	config().Env = "stage"
+	config().Prefix = "/myprefix"
+	config().Whitelist = []string{"@whitedomain.org"}
+	config().Google.Auth.CookieKey = "cookie-key"
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/myprefix/auth/login?next=/@whitedomain.org", nil)
+	handleLogin(w, r)
+	var state string
+	for _, ck := range (&http.Response{Header: w.HeaderMap}).Cookies() {
+		if ck.Name == loginStateCookie {
+			state = ck.Value
+		}
+	}
+	if state == "" {
+		t.Fatalf("no %s cookie in %v", loginStateCookie, w.HeaderMap["Set-Cookie"])
+	}
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/myprefix/admin/", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: state})
+	if email, err := sessionEmail(r); err == nil {
+		t.Errorf("sessionEmail() = %q; want error", email)
+	}
+	checkWhitelist(h).ServeHTTP(w, r)
+	if w.Code != http.StatusFound {
+		t.Errorf("w.Code = %d; want 302\nResponse: %s", w.Code, w.Body)
+	}
+
+	// a signed session value must still be a single address
+	v, err := signCookie(sessionCookie, "state /@whitedomain.org", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ = http.NewRequest("GET", "/myprefix/admin/", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: v})
+	if email, err := sessionEmail(r); err == nil {
+		t.Errorf("sessionEmail() = %q; want error", email)
+	}
+}
@@ -45,19 +45,19 @@ func TestMain(m *testing.M) {
 		w.Write([]byte(`{"access_token": "oauth1-token"}`))
 	}))
 
-	config.Dir = "app"
-	config.Env = "dev"
-	config.Prefix = "/myprefix"
-	config.Google.Auth.Client = "test-client-id"
-	config.Google.ServiceAccount.Key = ""
-	config.Twitter.TokenURL = oauth1.URL + "/"
-	config.SyncToken = "sync-token"
-	config.Schedule.Start = time.Date(2015, 5, 28, 9, 0, 0, 0, time.UTC)
-	config.Schedule.Timezone = "America/Los_Angeles"
+	config().Dir = "app"
+	config().Env = "dev"
+	config().Prefix = "/myprefix"
+	config().Google.Auth.Client = "test-client-id"
+	config().Google.ServiceAccount.Key = ""
+	config().Twitter.TokenURL = oauth1.URL + "/"
+	config().SyncToken = "sync-token"
+	config().Schedule.Start = time.Date(2015, 5, 28, 9, 0, 0, 0, time.UTC)
+	config().Schedule.Timezone = "America/Los_Angeles"
 	var err error
-	config.Schedule.Location, err = time.LoadLocation(config.Schedule.Timezone)
+	config().Schedule.Location, err = time.LoadLocation(config().Schedule.Timezone)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not load location %q", config.Schedule.Location)
+		fmt.Fprintf(os.Stderr, "Could not load location %q", config().Schedule.Location)
 		os.Exit(1)
 	}
 
@@ -141,9 +141,13 @@ func aetInstance(t *testing.T) aetest.Instance {
 	return inst
 }
 
+// preserveConfig makes a copy of the current config for a test to modify
+// and returns a func which restores the original one.
 func preserveConfig() func() {
-	orig := config
-	return func() { config = orig }
+	orig := config()
+	cfg := *orig
+	setConfig(&cfg)
+	return func() { setConfig(orig) }
 }
 
 func toSessionIDs(a []*eventSession) []string {
@@ -0,0 +1,207 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+// defaultManifestName is the manifest file name assumed
+// when schedule manifest points to a local directory.
+const defaultManifestName = "manifest_v1.json"
+
+// manifestSource is a location of the event data manifest
+// and the data files it lists, e.g. a GCS bucket or a local directory.
+type manifestSource interface {
+	// fetch retrieves file name, which is relative to the manifest location.
+	// If the file matches cond, fetch returns errNotModified.
+	fetch(c context.Context, name string, cond fetchCond) (*sourceFile, error)
+	// url returns the location of file name, used in logs and errors.
+	url(name string) string
+}
+
+// fetchCond makes a fetch conditional, similar to If-None-Match
+// and If-Modified-Since HTTP headers. The etag takes precedence over since.
+// A zero fetchCond makes the fetch unconditional.
+type fetchCond struct {
+	etag  string
+	since time.Time
+}
+
+// sourceFile is a file retrieved from manifestSource.
+// The caller must close it.
+type sourceFile struct {
+	io.ReadCloser
+	etag     string
+	modified time.Time
+}
+
+// newManifestSource returns the source of manifest at urlStr and the manifest file name.
+// urlStr is either an http(s) URL, a file:// URL or a local path.
+// A local directory is assumed to contain defaultManifestName.
+func newManifestSource(c context.Context, urlStr string) (manifestSource, string, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, "", err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		hc, err := serviceAccountClient(c, gcsReadOnlyScope)
+		if err != nil {
+			return nil, "", err
+		}
+		base := *u
+		base.Path = path.Dir(u.Path)
+		return &httpSource{base: &base, client: hc}, path.Base(u.Path), nil
+	case "file", "":
+		return newDirSource(localManifestPath(urlStr))
+	}
+	return nil, "", fmt.Errorf("newManifestSource(%q): unsupported scheme %q", urlStr, u.Scheme)
+}
+
+// isLocalManifest reports whether urlStr is a file:// URL or a local path.
+func isLocalManifest(urlStr string) bool {
+	u, err := url.Parse(urlStr)
+	return err == nil && urlStr != "" && (u.Scheme == "file" || u.Scheme == "")
+}
+
+// localManifestPath returns the file path of a local manifest urlStr.
+func localManifestPath(urlStr string) string {
+	if u, err := url.Parse(urlStr); err == nil && u.Scheme == "file" {
+		return filepath.FromSlash(u.Path)
+	}
+	return urlStr
+}
+
+// httpSource is a manifestSource of files served over HTTP, like GCS.
+type httpSource struct {
+	base   *url.URL // manifest dir
+	client *http.Client
+}
+
+func (s *httpSource) url(name string) string {
+	u := *s.base
+	u.Path = path.Join(s.base.Path, name)
+	return u.String()
+}
+
+func (s *httpSource) fetch(c context.Context, name string, cond fetchCond) (*sourceFile, error) {
+	u := s.url(name)
+	r, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cond.etag != "" {
+		r.Header.Set("if-none-match", cond.etag)
+	}
+	if !cond.since.IsZero() {
+		r.Header.Set("if-modified-since", cond.since.UTC().Format(http.TimeFormat))
+	}
+	res, err := ctxhttp.Do(c, s.client, r)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotModified {
+		res.Body.Close()
+		return nil, errNotModified
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, &statusError{url: u, code: res.StatusCode, status: res.Status}
+	}
+	mod := time.Now()
+	if t, err := time.ParseInLocation(http.TimeFormat, res.Header.Get("last-modified"), time.UTC); err == nil {
+		mod = t
+	}
+	return &sourceFile{ReadCloser: res.Body, etag: res.Header.Get("etag"), modified: mod}, nil
+}
+
+// statusError is an unexpected response status code of httpSource.
+type statusError struct {
+	url    string
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return e.url + ": " + e.status
+}
+
+// dirSource is a manifestSource of files in a local directory.
+// Modification times are emulated with file mtimes,
+// truncated to seconds similar to Last-Modified HTTP header,
+// and etags with file sizes and mtimes.
+type dirSource struct {
+	dir string
+}
+
+// newDirSource returns a dirSource of the manifest at path p and the manifest file name.
+// If p is a directory, the manifest is defaultManifestName in p.
+func newDirSource(p string) (manifestSource, string, error) {
+	p, err := filepath.Abs(p)
+	if err != nil {
+		return nil, "", err
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, "", err
+	}
+	if fi.IsDir() {
+		return &dirSource{dir: p}, defaultManifestName, nil
+	}
+	return &dirSource{dir: filepath.Dir(p)}, filepath.Base(p), nil
+}
+
+func (s *dirSource) url(name string) string {
+	return "file://" + filepath.ToSlash(s.path(name))
+}
+
+// path returns the file path of name. It never points outside of s.dir.
+func (s *dirSource) path(name string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+func (s *dirSource) fetch(c context.Context, name string, cond fetchCond) (*sourceFile, error) {
+	f, err := os.Open(s.path(name))
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	etag := fmt.Sprintf(`"%d-%d"`, fi.Size(), fi.ModTime().UnixNano())
+	mod := fi.ModTime().UTC().Truncate(time.Second)
+	notModified := cond.etag == etag
+	if cond.etag == "" && !cond.since.IsZero() {
+		notModified = !mod.After(cond.since)
+	}
+	if notModified {
+		f.Close()
+		return nil, errNotModified
+	}
+	return &sourceFile{ReadCloser: f, etag: etag, modified: mod}, nil
+}
@@ -0,0 +1,294 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// writeManifestDir creates a temp dir with a manifest listing rooms.json and sessions.json
+// and returns the dir along with the files modification time.
+func writeManifestDir(t *testing.T) (string, time.Time) {
+	dir, err := ioutil.TempDir("", "ioweb-manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		defaultManifestName: `{"data_files": ["rooms.json", "sessions.json", "past_io_videolibrary_v1.json"]}`,
+		"rooms.json":        `{"rooms": [{"id": "room-id", "name": "Room A"}]}`,
+		"sessions.json":     sessionsChunk("Local session"),
+	}
+	mod := time.Date(2016, 5, 1, 10, 0, 0, 0, time.UTC)
+	for name, content := range files {
+		writeChunk(t, dir, name, content, mod)
+	}
+	return dir, mod
+}
+
+// writeChunk writes content to file name in dir and sets its mtime to mod.
+func writeChunk(t *testing.T, dir, name, content string, mod time.Time) {
+	p := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(p, mod, mod.Add(500*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sessionsChunk returns a data file with a single session-id session titled title.
+func sessionsChunk(title string) string {
+	return fmt.Sprintf(`{"sessions": [{
+		"id": "session-id",
+		"title": %q,
+		"room": "room-id",
+		"startTimestamp": "2015-05-28T22:00:00Z",
+		"endTimestamp": "2015-05-28T23:00:00Z"
+	}]}`, title)
+}
+
+func TestDirSource(t *testing.T) {
+	t.Parallel()
+	dir, mod := writeManifestDir(t)
+	defer os.RemoveAll(dir)
+
+	c := context.Background()
+	for _, u := range []string{dir, filepath.Join(dir, defaultManifestName), "file://" + filepath.ToSlash(dir)} {
+		if !isLocalManifest(u) {
+			t.Errorf("isLocalManifest(%q) = false; want true", u)
+		}
+		src, name, err := newManifestSource(c, u)
+		if err != nil {
+			t.Errorf("newManifestSource(%q): %v", u, err)
+			continue
+		}
+		if name != defaultManifestName {
+			t.Errorf("newManifestSource(%q): name = %q; want %q", u, name, defaultManifestName)
+		}
+
+		tests := []struct {
+			since       time.Time
+			notModified bool
+		}{
+			{time.Time{}, false},
+			{mod.Add(-time.Second), false},
+			{mod, true},
+			{mod.Add(time.Second), true},
+		}
+		for _, test := range tests {
+			f, err := src.fetch(c, name, fetchCond{since: test.since})
+			if test.notModified {
+				if err != errNotModified {
+					t.Errorf("%s: fetch(%s): %v; want errNotModified", u, test.since, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s: fetch(%s): %v", u, test.since, err)
+				continue
+			}
+			f.Close()
+			if !f.modified.Equal(mod) {
+				t.Errorf("%s: f.modified = %s; want %s", u, f.modified, mod)
+			}
+		}
+	}
+
+	src, _, _ := newManifestSource(c, dir)
+	if _, err := src.fetch(c, "../"+filepath.Base(dir)+"/sessions.json", fetchCond{}); err == nil {
+		t.Errorf("fetch(../): want error")
+	}
+	if isLocalManifest("https://example.org/manifest_v1.json") {
+		t.Errorf("isLocalManifest(https) = true; want false")
+	}
+}
+
+func TestFetchEventDataLocal(t *testing.T) {
+	defer preserveConfig()()
+	defer resetTestState(t)
+	defer func(c cacheInterface) { cache = c }(cache)
+	cache = newMemoryCache(10)
+	dir, mod := writeManifestDir(t)
+	defer os.RemoveAll(dir)
+	config().Schedule.Start = time.Date(2015, 5, 28, 9, 0, 0, 0, time.UTC)
+
+	c := newContext(newTestRequest(t, "GET", "/", nil))
+	data, err := fetchEventData(c, "file://"+filepath.ToSlash(dir), &eventData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data == nil || len(data.Sessions) != 1 || data.Sessions["session-id"] == nil {
+		t.Fatalf("data = %+v; want session-id", data)
+	}
+	if !data.modified.Equal(mod) {
+		t.Errorf("data.modified = %s; want %s", data.modified, mod)
+	}
+
+	data, err = fetchEventData(c, dir, data)
+	if err != nil || data != nil {
+		t.Errorf("fetchEventData(%s): %+v, %v; want nil, nil", mod, data, err)
+	}
+}
+
+func TestFetchEventDataChunks(t *testing.T) {
+	defer preserveConfig()()
+	defer resetTestState(t)
+	defer func(c cacheInterface) { cache = c }(cache)
+	cache = newMemoryCache(10)
+	dir, mod := writeManifestDir(t)
+	defer os.RemoveAll(dir)
+	config().Schedule.Start = time.Date(2015, 5, 28, 9, 0, 0, 0, time.UTC)
+
+	c := newContext(newTestRequest(t, "GET", "/", nil))
+	data, err := fetchEventData(c, dir, &eventData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"rooms.json", "sessions.json"}; !reflect.DeepEqual(data.changed, want) {
+		t.Errorf("data.changed = %v; want %v", data.changed, want)
+	}
+	// nothing is considered synced until the data is stored
+	again, err := fetchEventData(c, dir, &eventData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again.changed, data.changed) {
+		t.Errorf("again.changed = %v; want %v", again.changed, data.changed)
+	}
+
+	// editors tweak a single file
+	mod2 := mod.Add(time.Hour)
+	writeChunk(t, dir, "sessions.json", sessionsChunk("Updated session"), mod2)
+	if err := os.Chtimes(filepath.Join(dir, defaultManifestName), mod2, mod2); err != nil {
+		t.Fatal(err)
+	}
+	data, err = fetchEventData(c, dir, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"sessions.json"}; !reflect.DeepEqual(data.changed, want) {
+		t.Errorf("data.changed = %v; want %v", data.changed, want)
+	}
+	s := data.Sessions["session-id"]
+	if s == nil || s.Title != "Updated session" || s.Room != "Room A" {
+		t.Errorf("session = %+v; want Updated session in Room A", s)
+	}
+}
+
+func TestFetchEventDataRetry(t *testing.T) {
+	defer preserveConfig()()
+	defer resetTestState(t)
+	defer func(c cacheInterface, tr func(context.Context) http.RoundTripper, d time.Duration) {
+		cache, httpTransport, fetchRetryDelay = c, tr, d
+	}(cache, httpTransport, fetchRetryDelay)
+	cache = newMemoryCache(10)
+	httpTransport = func(context.Context) http.RoundTripper { return http.DefaultTransport }
+	fetchRetryDelay = time.Millisecond
+	config().Schedule.Start = time.Date(2015, 5, 28, 9, 0, 0, 0, time.UTC)
+	config().Schedule.FetchConcurrency = 1
+	config().Schedule.FetchRetries = 2
+
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	inflight, maxInflight := 0, 0
+	status := map[string][]int{} // response codes of consecutive calls; the last one repeats
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/manifest.json" {
+			w.Write([]byte(`{"data_files": ["a.json", "b.json", "c.json"]}`))
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		mu.Lock()
+		inflight++
+		if inflight > maxInflight {
+			maxInflight = inflight
+		}
+		codes := status[name]
+		code := http.StatusOK
+		if n := calls[name]; n < len(codes) {
+			code = codes[n]
+		} else if len(codes) > 0 {
+			code = codes[len(codes)-1]
+		}
+		calls[name]++
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inflight--
+			mu.Unlock()
+		}()
+		time.Sleep(time.Millisecond)
+		w.WriteHeader(code)
+		fmt.Fprintf(w, `{"sessions": [{"id": %q, "startTimestamp": "2015-05-28T22:00:00Z"}]}`, name)
+	}))
+	defer ts.Close()
+	manifest := ts.URL + "/manifest.json"
+	c := newContext(newTestRequest(t, "GET", "/", nil))
+
+	// a.json recovers from a transient error
+	status["a.json"] = []int{http.StatusServiceUnavailable, http.StatusOK}
+	good, err := fetchEventData(c, manifest, &eventData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(good.Sessions) != 3 {
+		t.Errorf("len(good.Sessions) = %d; want 3", len(good.Sessions))
+	}
+	if calls["a.json"] != 2 {
+		t.Errorf("calls[a.json] = %d; want 2", calls["a.json"])
+	}
+	if maxInflight != 1 {
+		t.Errorf("maxInflight = %d; want 1", maxInflight)
+	}
+
+	// b.json is not retried; c.json fails after all retries
+	status["a.json"] = nil
+	status["b.json"] = []int{http.StatusNotFound}
+	status["c.json"] = []int{http.StatusInternalServerError}
+	calls = make(map[string]int)
+	_, err = fetchEventData(c, manifest, &eventData{})
+	errs, ok := err.(chunkErrors)
+	if !ok || len(errs) != 2 || errs["b.json"] == nil || errs["c.json"] == nil {
+		t.Fatalf("err = %v; want chunkErrors of b.json and c.json", err)
+	}
+	if calls["b.json"] != 1 || calls["c.json"] != 3 {
+		t.Errorf("calls = %v; want b.json: 1, c.json: 3", calls)
+	}
+
+	// keep the last good version of failed files, regardless of the cache
+	config().Schedule.OnChunkError = chunkErrorKeepLast
+	cache = newMemoryCache(10)
+	data, err := fetchEventData(c, manifest, good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a.json", "b.json", "c.json"} {
+		if data.Sessions[id] == nil {
+			t.Errorf("data.Sessions[%q] is nil", id)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// metricsContentType is the Prometheus text exposition format content type.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	// allMetrics is a list of all metrics exposed by serveMetrics.
+	allMetrics []metric
+
+	syncTotal = newCounterVec("ioweb_sync_total",
+		"Event data syncs by outcome.", "outcome")
+	syncDiffItems = newHistogramVec("ioweb_sync_diff_items",
+		"Number of changed items in a non-empty event data diff.",
+		[]float64{1, 5, 10, 25, 50, 100, 250, 500}, "kind")
+	syncChunksTotal = newCounterVec("ioweb_sync_chunks_total",
+		"Data files of event data syncs by whether they changed since the previous sync.", "result")
+	pushTotal = newCounterVec("ioweb_push_total",
+		"Push notification sends by result.", "result")
+	cacheTotal = newCounterVec("ioweb_cache_gets_total",
+		"Cache lookups by result.", "result")
+	twitterErrorsTotal = newCounterVec("ioweb_twitter_fetch_errors_total",
+		"Failed Twitter timeline fetches.")
+	surveyTotal = newCounterVec("ioweb_survey_submissions_total",
+		"Session survey submissions by result.", "result")
+	streamTotal = newCounterVec("ioweb_stream_connections_total",
+		"Schedule stream connection attempts by result.", "result")
+	wipeoutTotal = newCounterVec("ioweb_wipeout_deletions_total",
+		"Users wiped out from Firebase shards by result.", "result")
+	handlerDuration = newHistogramVec("ioweb_http_request_duration_seconds",
+		"Latency of requests served by handlers registered with handle().",
+		[]float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}, "handler", "code")
+)
+
+// metric is a collection of time series exposed by serveMetrics.
+type metric interface {
+	// writeTo writes the metric in Prometheus text format.
+	writeTo(w io.Writer)
+}
+
+// counterVec is a counter partitioned by label values.
+// It is safe for concurrent use.
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64 // label values joined with \xff
+}
+
+// newCounterVec creates a new counter and adds it to allMetrics.
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	allMetrics = append(allMetrics, c)
+	return c
+}
+
+// inc increments the counter of labels values lv by 1.
+// The number of lv must match the number of labels.
+func (c *counterVec) inc(lv ...string) {
+	c.add(1, lv...)
+}
+
+// add increments the counter of labels values lv by v.
+func (c *counterVec) add(v float64, lv ...string) {
+	k := strings.Join(lv, "\xff")
+	c.mu.Lock()
+	c.values[k] += v
+	c.mu.Unlock()
+}
+
+// value returns current counter value of lv labels.
+func (c *counterVec) value(lv ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[strings.Join(lv, "\xff")]
+}
+
+func (c *counterVec) writeTo(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, k := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelPairs(c.labels, k, "", ""), formatFloat(c.values[k]))
+	}
+}
+
+// histogramVec is a histogram partitioned by label values.
+// It is safe for concurrent use.
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64 // upper bounds, sorted
+
+	mu     sync.Mutex
+	series map[string]*histogram // label values joined with \xff
+}
+
+// histogram is a single histogram time series.
+type histogram struct {
+	counts []uint64 // cumulative counts of each bucket
+	count  uint64
+	sum    float64
+}
+
+// newHistogramVec creates a new histogram with upper bounds buckets
+// and adds it to allMetrics.
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*histogram),
+	}
+	allMetrics = append(allMetrics, h)
+	return h
+}
+
+// observe adds value v to the histogram of labels values lv.
+func (h *histogramVec) observe(v float64, lv ...string) {
+	k := strings.Join(lv, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[k]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *histogramVec) writeTo(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := h.series[k]
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(h.labels, k, "le", formatFloat(b)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(h.labels, k, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelPairs(h.labels, k, "", ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelPairs(h.labels, k, "", ""), s.count)
+	}
+}
+
+// labelPairs formats {name="value",...} of labels and their joined values.
+// An extra label is appended if extra is not empty.
+func labelPairs(labels []string, values string, extra, extraValue string) string {
+	var pairs []string
+	if len(labels) > 0 {
+		for i, v := range strings.Split(values, "\xff") {
+			if i < len(labels) {
+				pairs = append(pairs, fmt.Sprintf("%s=%s", labels[i], strconv.Quote(v)))
+			}
+		}
+	}
+	if extra != "" {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", extra, extraValue))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// serveMetrics responds with allMetrics in Prometheus text format.
+// Metrics are kept in memory of the current instance.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	for _, m := range allMetrics {
+		m.writeTo(&buf)
+	}
+	w.Header().Set("Content-Type", metricsContentType)
+	w.Write(buf.Bytes())
+}
+
+// instrumentHandler records latency of fn requests in handlerDuration
+// with handler label set to the pattern fn has been registered with.
+func instrumentHandler(pattern string, fn func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		var rw http.ResponseWriter = sw
+		if _, ok := w.(http.Flusher); ok {
+			rw = flushStatusWriter{sw}
+		}
+		fn(rw, r)
+		handlerDuration.observe(time.Since(start).Seconds(), pattern, strconv.Itoa(sw.code))
+	}
+}
+
+// statusWriter remembers response status code.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// flushStatusWriter is a statusWriter of a response which can be flushed,
+// so that streaming handlers, like serveScheduleStream, work when instrumented.
+type flushStatusWriter struct {
+	*statusWriter
+}
+
+func (w flushStatusWriter) Flush() {
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+// CloseNotify returns a channel which never receives a value
+// if the underlying response writer is not an http.CloseNotifier.
+func (w flushStatusWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return nil
+}
+
+// metricsCache is a cacheInterface which counts hits and misses of c.
+type metricsCache struct {
+	cacheInterface
+}
+
+func (mc metricsCache) get(c context.Context, key string) ([]byte, error) {
+	b, err := mc.cacheInterface.get(c, key)
+	switch err {
+	case nil:
+		cacheTotal.inc("hit")
+	case errCacheMiss:
+		cacheTotal.inc("miss")
+	default:
+		cacheTotal.inc("error")
+	}
+	return b, err
+}
+
+// pushResult returns the result of a push send for pushTotal.
+func pushResult(err error) string {
+	if err == nil {
+		return "success"
+	}
+	if pe, ok := err.(*pushError); ok {
+		switch {
+		case pe.remove:
+			return "remove"
+		case pe.retry:
+			return "retry"
+		}
+	}
+	return "error"
+}
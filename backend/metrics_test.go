@@ -0,0 +1,146 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestCounterVecWrite(t *testing.T) {
+	c := &counterVec{name: "test_total", help: "Test.", labels: []string{"result"}, values: make(map[string]float64)}
+	c.inc("ok")
+	c.inc("ok")
+	c.add(0.5, `a"b`)
+	var buf bytes.Buffer
+	c.writeTo(&buf)
+	want := `# HELP test_total Test.
+# TYPE test_total counter
+test_total{result="a\"b"} 0.5
+test_total{result="ok"} 2
+`
+	if v := buf.String(); v != want {
+		t.Errorf("writeTo:\n%s\nwant:\n%s", v, want)
+	}
+}
+
+func TestHistogramVecWrite(t *testing.T) {
+	h := &histogramVec{name: "test_seconds", help: "Test.", labels: []string{"handler"},
+		buckets: []float64{0.1, 1}, series: make(map[string]*histogram)}
+	h.observe(0.05, "/a")
+	h.observe(0.5, "/a")
+	h.observe(5, "/a")
+	var buf bytes.Buffer
+	h.writeTo(&buf)
+	want := `# HELP test_seconds Test.
+# TYPE test_seconds histogram
+test_seconds_bucket{handler="/a",le="0.1"} 1
+test_seconds_bucket{handler="/a",le="1"} 2
+test_seconds_bucket{handler="/a",le="+Inf"} 3
+test_seconds_sum{handler="/a"} 5.55
+test_seconds_count{handler="/a"} 3
+`
+	if v := buf.String(); v != want {
+		t.Errorf("writeTo:\n%s\nwant:\n%s", v, want)
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	const count = `ioweb_http_request_duration_seconds_count{handler="/test",code="418"}`
+	before := metricValue(scrapeMetrics(t), count)
+	fn := instrumentHandler("/test", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	r, _ := http.NewRequest("GET", "/test", nil)
+	fn(httptest.NewRecorder(), r)
+
+	body := scrapeMetrics(t)
+	for _, s := range []string{
+		"# TYPE ioweb_sync_total counter",
+		"# TYPE ioweb_push_total counter",
+	} {
+		if !strings.Contains(body, s) {
+			t.Errorf("%q is missing in:\n%s", s, body)
+		}
+	}
+	if v := metricValue(body, count) - before; v != 1 {
+		t.Errorf("%s increased by %v; want 1\n%s", count, v, body)
+	}
+}
+
+// scrapeMetrics returns the body of a serveMetrics response.
+func scrapeMetrics(t *testing.T) string {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/metrics", nil)
+	serveMetrics(w, r)
+	if v := w.Header().Get("content-type"); v != metricsContentType {
+		t.Errorf("content-type = %q; want %q", v, metricsContentType)
+	}
+	return w.Body.String()
+}
+
+// metricValue returns the value of series in metrics body, or 0 if it's missing.
+func metricValue(body, series string) float64 {
+	for _, l := range strings.Split(body, "\n") {
+		if !strings.HasPrefix(l, series+" ") {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimPrefix(l, series+" "), 64)
+		if err == nil {
+			return v
+		}
+	}
+	return 0
+}
+
+func TestMetricsCache(t *testing.T) {
+	c := context.Background()
+	mc := metricsCache{newMemoryCache(10)}
+	hit, miss := cacheTotal.value("hit"), cacheTotal.value("miss")
+	mc.set(c, "key", []byte("v"), 0)
+	mc.get(c, "key")
+	mc.get(c, "missing")
+	if v := cacheTotal.value("hit") - hit; v != 1 {
+		t.Errorf("hits = %v; want 1", v)
+	}
+	if v := cacheTotal.value("miss") - miss; v != 1 {
+		t.Errorf("misses = %v; want 1", v)
+	}
+}
+
+func TestPushResult(t *testing.T) {
+	tests := []struct {
+		err    error
+		result string
+	}{
+		{nil, "success"},
+		{&pushError{remove: true}, "remove"},
+		{&pushError{retry: true}, "retry"},
+		{&pushError{}, "error"},
+		{errors.New("boom"), "error"},
+	}
+	for _, test := range tests {
+		if v := pushResult(test.err); v != test.result {
+			t.Errorf("pushResult(%v) = %q; want %q", test.err, v, test.result)
+		}
+	}
+}
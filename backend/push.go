@@ -34,6 +34,17 @@ const (
 	updateStart   = "start"
 	updateSoon    = "soon"
 	updateSurvey  = "survey"
+	// also used in eventSpeaker.Update and eventVideo.Update
+	updateAdded   = "added"
+	updateRemoved = "removed"
+)
+
+const (
+	// eventSession.Changes items
+	changeTime     = "time"
+	changeRoom     = "room"
+	changeSpeakers = "speakers"
+	changeDetails  = "details" // title, description, tags and other fields
 )
 
 //  userPush is user notification configuration.
@@ -108,6 +119,8 @@ func mergeChanges(dst *dataChanges, src *dataChanges) {
 }
 
 // filterUserChanges reduces dc to a subset matching session IDs to bks.
+// Surveys are kept regardless of bks. Added sessions are kept only with
+// schedule.notifyAdded config set, since nobody could have bookmarked a new session yet.
 // It sorts bks with sort.Strings as a side effect.
 func filterUserChanges(d *dataChanges, bks []string) *dataChanges {
 	// Operate on a copy
@@ -117,10 +130,11 @@ func filterUserChanges(d *dataChanges, bks []string) *dataChanges {
 		changes.Sessions[k] = v
 	}
 
+	added := config().Schedule.NotifyAdded
 	sort.Strings(bks)
 	for id, s := range changes.Sessions {
-		if s.Update == updateSurvey {
-			// surveys don't have to match bookmarks
+		if s.Update == updateSurvey || (added && s.Update == updateAdded) {
+			// surveys and broadcast new sessions don't have to match bookmarks
 			continue
 		}
 		i := sort.SearchStrings(bks, id)
@@ -137,6 +151,7 @@ func filterUserChanges(d *dataChanges, bks []string) *dataChanges {
 // In a case where endpoint did not accept push request the return error
 // will be of type *pushError with RetryAfter >= 0.
 func notifySubscription(c context.Context, s string, msg *pushMessage) error {
+	cfg := config()
 	sub, err := webpush.SubscriptionFromJSON([]byte(s))
 	if err != nil {
 		// invalid subscription
@@ -144,8 +159,8 @@ func notifySubscription(c context.Context, s string, msg *pushMessage) error {
 	}
 
 	var auth string
-	if u := config.Google.GCM.Endpoint; u != "" && strings.HasPrefix(sub.Endpoint, u) {
-		auth = config.Google.GCM.Key
+	if u := cfg.Google.GCM.Endpoint; u != "" && strings.HasPrefix(sub.Endpoint, u) {
+		auth = cfg.Google.GCM.Key
 	}
 
 	n := ""
@@ -196,8 +211,31 @@ func userNotifications(c context.Context, dc *dataChanges, bks []string) []*noti
 
 	var n []*notification
 
-	if len(updates[updateDetails]) > 0 {
-		n = append(n, detailsNotification(updates[updateDetails]))
+	var moved, speakers, details []*eventSession
+	for _, s := range updates[updateDetails] {
+		switch {
+		case len(s.Changes) == 0:
+			// not classified, e.g. sent from debug push page
+			details = append(details, s)
+		default:
+			// a session may be listed in more than one notification;
+			// sessions with other changes only are not worth one
+			if hasChange(s, changeTime) || hasChange(s, changeRoom) {
+				moved = append(moved, s)
+			}
+			if hasChange(s, changeSpeakers) {
+				speakers = append(speakers, s)
+			}
+		}
+	}
+	if len(moved) > 0 {
+		n = append(n, movedNotification(moved))
+	}
+	if len(speakers) > 0 {
+		n = append(n, speakersNotification(speakers))
+	}
+	if len(details) > 0 {
+		n = append(n, detailsNotification(details))
 	}
 	if len(updates[updateSoon]) > 0 {
 		n = append(n, soonNotification())
@@ -211,10 +249,26 @@ func userNotifications(c context.Context, dc *dataChanges, bks []string) []*noti
 	if len(updates[updateSurvey]) > 0 {
 		n = append(n, surveyNotification())
 	}
+	if len(updates[updateRemoved]) > 0 {
+		n = append(n, removedNotification(updates[updateRemoved]))
+	}
+	if len(updates[updateAdded]) > 0 {
+		n = append(n, addedNotification(updates[updateAdded]))
+	}
 
 	return n
 }
 
+// hasChange reports whether kind is one of s.Changes.
+func hasChange(s *eventSession, kind string) bool {
+	for _, k := range s.Changes {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
 func formatSessionTitles(sessions []*eventSession) string {
 	titles := make([]string, len(sessions))
 	for i, s := range sessions {
@@ -235,6 +289,118 @@ func detailsNotification(sessions []*eventSession) *notification {
 	}
 }
 
+func movedNotification(sessions []*eventSession) *notification {
+	if len(sessions) == 1 {
+		s := sessions[0]
+		return &notification{
+			Title: "A session in My Schedule has moved",
+			Body:  fmt.Sprintf("%s moved %s", s.Title, formatMove(s)),
+			Tag:   "session-moved",
+			Data: struct {
+				URL string `json:"url,omitempty"`
+			}{fmt.Sprintf("schedule?sid=%s", s.ID)},
+		}
+	}
+	return &notification{
+		Title: "Some events in My Schedule have moved",
+		Body:  fmt.Sprintf("%s have been rescheduled", formatSessionTitles(sessions)),
+		Tag:   "session-moved",
+		Data: struct {
+			URL string `json:"url,omitempty"`
+		}{"schedule"},
+	}
+}
+
+// formatMove describes a time and room change of s, e.g.
+// "from 2:00 PM Stage 3 to 4:00 PM Stage 5". Unchanged parts are omitted.
+// The date is included only if the session moved to another day,
+// and the end time only if the session duration changed, e.g.
+// "from 2:00 PM - 3:00 PM to 2:00 PM - 3:30 PM".
+func formatMove(s *eventSession) string {
+	cfg := config()
+	var from, to []string
+	if p := s.Prev; p != nil && p.StartTime != nil {
+		layout := "3:04 PM"
+		prev := p.StartTime.In(cfg.Schedule.Location)
+		start := s.StartTime.In(cfg.Schedule.Location)
+		if prev.YearDay() != start.YearDay() {
+			layout = "Jan 2, 3:04 PM"
+		}
+		pt, st := prev.Format(layout), start.Format(layout)
+		if p.EndTime != nil && p.EndTime.Sub(*p.StartTime) != s.EndTime.Sub(s.StartTime) {
+			pt += " - " + p.EndTime.In(cfg.Schedule.Location).Format("3:04 PM")
+			st += " - " + s.EndTime.In(cfg.Schedule.Location).Format("3:04 PM")
+		}
+		from = append(from, pt)
+		to = append(to, st)
+	}
+	if p := s.Prev; p != nil && p.Room != "" {
+		from = append(from, p.Room)
+		to = append(to, s.Room)
+	}
+	return fmt.Sprintf("from %s to %s", strings.Join(from, " "), strings.Join(to, " "))
+}
+
+func speakersNotification(sessions []*eventSession) *notification {
+	if len(sessions) == 1 {
+		s := sessions[0]
+		return &notification{
+			Title: "Speakers of a session in My Schedule have changed",
+			Body:  fmt.Sprintf("%s has new speakers", s.Title),
+			Tag:   "session-speakers",
+			Data: struct {
+				URL string `json:"url,omitempty"`
+			}{fmt.Sprintf("schedule?sid=%s", s.ID)},
+		}
+	}
+	return &notification{
+		Title: "Speakers of some events in My Schedule have changed",
+		Body:  fmt.Sprintf("%s have new speakers", formatSessionTitles(sessions)),
+		Tag:   "session-speakers",
+		Data: struct {
+			URL string `json:"url,omitempty"`
+		}{"schedule"},
+	}
+}
+
+func removedNotification(sessions []*eventSession) *notification {
+	if len(sessions) == 1 {
+		return &notification{
+			Title: "A session in My Schedule was cancelled",
+			Body:  fmt.Sprintf("%s has been cancelled", sessions[0].Title),
+			Tag:   "session-removed",
+		}
+	}
+	return &notification{
+		Title: "Some events in My Schedule have been cancelled",
+		Body:  fmt.Sprintf("%s have been cancelled", formatSessionTitles(sessions)),
+		Tag:   "session-removed",
+	}
+}
+
+func addedNotification(sessions []*eventSession) *notification {
+	// a single new session links directly to its page, similar to videoNotification
+	if len(sessions) == 1 {
+		s := sessions[0]
+		return &notification{
+			Title: "New session: " + s.Title,
+			Body:  fmt.Sprintf("%s, %s", s.Start, s.Room),
+			Tag:   "session-added",
+			Data: struct {
+				URL string `json:"url,omitempty"`
+			}{fmt.Sprintf("schedule?sid=%s", s.ID)},
+		}
+	}
+	return &notification{
+		Title: "New sessions have been added to the schedule",
+		Body:  formatSessionTitles(sessions),
+		Tag:   "session-added",
+		Data: struct {
+			URL string `json:"url,omitempty"`
+		}{"schedule"},
+	}
+}
+
 func soonNotification() *notification {
 	return &notification{
 		Title: "Google I/O is starting soon",
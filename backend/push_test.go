@@ -0,0 +1,146 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestUserNotificationsAddedRemoved(t *testing.T) {
+	defer preserveConfig()()
+	dc := &dataChanges{eventData: eventData{Sessions: map[string]*eventSession{
+		"cancelled": {ID: "cancelled", Title: "Cancelled", Update: updateRemoved},
+		"other":     {ID: "other", Title: "Other", Update: updateRemoved},
+		"new":       {ID: "new", Title: "New", Start: "May 18, 2:00 PM", Room: "Stage 3", Update: updateAdded},
+	}}}
+
+	// new sessions are not broadcast by default
+	n := userNotifications(context.Background(), dc, []string{"cancelled"})
+	if len(n) != 1 || n[0].Tag != "session-removed" {
+		t.Fatalf("n = %+v; want only session-removed", n)
+	}
+	if v := n[0]; v.Body != "Cancelled has been cancelled" {
+		t.Errorf("removed body = %q", v.Body)
+	}
+
+	config().Schedule.NotifyAdded = true
+	n = userNotifications(context.Background(), dc, []string{"cancelled"})
+	tags := make(map[string]*notification)
+	for _, v := range n {
+		tags[v.Tag] = v
+	}
+	if len(n) != 2 || tags["session-removed"] == nil || tags["session-added"] == nil {
+		t.Fatalf("n = %+v; want session-removed and session-added", n)
+	}
+	v := tags["session-added"]
+	if v.Title != "New session: New" || v.Body != "May 18, 2:00 PM, Stage 3" {
+		t.Errorf("added = %q, %q", v.Title, v.Body)
+	}
+	if v.Data.URL != "schedule?sid=new" {
+		t.Errorf("added url = %q; want schedule?sid=new", v.Data.URL)
+	}
+
+	// removals of non-bookmarked sessions are not sent
+	n = userNotifications(context.Background(), dc, nil)
+	if len(n) != 1 || n[0].Tag != "session-added" {
+		t.Errorf("n = %+v; want only session-added", n)
+	}
+}
+
+func TestUserNotificationsMoved(t *testing.T) {
+	defer preserveConfig()()
+	config().Schedule.Location = time.UTC
+	start := time.Date(2016, 5, 18, 14, 0, 0, 0, time.UTC)
+	prevStart, prevEnd := start, start.Add(time.Hour)
+	dc := &dataChanges{eventData: eventData{Sessions: map[string]*eventSession{
+		"moved": {
+			ID: "moved", Title: "Moved", Room: "Stage 5",
+			StartTime: start.Add(2 * time.Hour), EndTime: start.Add(3 * time.Hour),
+			Update: updateDetails, Changes: []string{changeTime, changeRoom, changeDetails},
+			Prev: &sessionPrev{StartTime: &prevStart, EndTime: &prevEnd, Room: "Stage 3"},
+		},
+		"speakers": {
+			ID: "speakers", Title: "Speakers",
+			Update: updateDetails, Changes: []string{changeSpeakers},
+			Prev: &sessionPrev{Speakers: []string{"spk-1"}},
+		},
+		"typo": {
+			ID: "typo", Title: "Typo",
+			Update: updateDetails, Changes: []string{changeDetails},
+			Prev: &sessionPrev{Title: "Tpyo"},
+		},
+		"renamed": {
+			ID: "renamed", Title: "Renamed",
+			Update: updateDetails, Changes: []string{changeDetails, changeSpeakers},
+			Prev: &sessionPrev{Title: "Old", Speakers: []string{"spk-2"}},
+		},
+	}}}
+
+	n := userNotifications(context.Background(), dc, []string{"moved", "speakers", "typo", "renamed"})
+	if len(n) != 2 || n[0].Tag != "session-moved" || n[1].Tag != "session-speakers" {
+		t.Fatalf("n = %+v; want session-moved and session-speakers", n)
+	}
+	for _, title := range []string{"Speakers", "Renamed"} {
+		if !strings.Contains(n[1].Body, title) {
+			t.Errorf("n[1].Body = %q; want it to contain %q", n[1].Body, title)
+		}
+	}
+
+	// moved sessions with new speakers are listed in both notifications
+	dc.Sessions["moved"].Changes = []string{changeTime, changeSpeakers}
+	n = userNotifications(context.Background(), dc, []string{"moved"})
+	if len(n) != 2 || n[0].Tag != "session-moved" || n[1].Tag != "session-speakers" {
+		t.Fatalf("n = %+v; want session-moved and session-speakers", n)
+	}
+	dc.Sessions["moved"].Changes = []string{changeTime, changeRoom, changeDetails}
+	if v := "Moved moved from 2:00 PM Stage 3 to 4:00 PM Stage 5"; n[0].Body != v {
+		t.Errorf("n[0].Body = %q; want %q", n[0].Body, v)
+	}
+	if v := "schedule?sid=moved"; n[0].Data.URL != v {
+		t.Errorf("n[0].Data.URL = %q; want %q", n[0].Data.URL, v)
+	}
+
+	tests := []struct {
+		prev *sessionPrev
+		body string
+	}{
+		{&sessionPrev{Room: "Stage 3"}, "Moved moved from Stage 3 to Stage 5"},
+		{&sessionPrev{StartTime: &prevStart, EndTime: &prevEnd}, "Moved moved from 2:00 PM to 4:00 PM"},
+	}
+	for _, test := range tests {
+		s := *dc.Sessions["moved"]
+		s.Prev = test.prev
+		if v := movedNotification([]*eventSession{&s}).Body; v != test.body {
+			t.Errorf("Body = %q; want %q", v, test.body)
+		}
+	}
+	s := *dc.Sessions["moved"]
+	s.StartTime = s.StartTime.AddDate(0, 0, 1)
+	s.EndTime = s.EndTime.AddDate(0, 0, 1)
+	if v, want := movedNotification([]*eventSession{&s}).Body, "Moved moved from May 18, 2:00 PM Stage 3 to May 19, 4:00 PM Stage 5"; v != want {
+		t.Errorf("Body = %q; want %q", v, want)
+	}
+	// only the end time changed
+	s = *dc.Sessions["moved"]
+	s.StartTime, s.EndTime = start, start.Add(90*time.Minute)
+	s.Prev = &sessionPrev{StartTime: &prevStart, EndTime: &prevEnd}
+	if v, want := movedNotification([]*eventSession{&s}).Body, "Moved moved from 2:00 PM - 3:00 PM to 2:00 PM - 3:30 PM"; v != want {
+		t.Errorf("Body = %q; want %q", v, want)
+	}
+}
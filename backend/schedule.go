@@ -17,9 +17,10 @@ package backend
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
 	"net/http"
-	"net/url"
-	"path"
 	"reflect"
 	"regexp"
 	"sort"
@@ -45,6 +46,15 @@ const (
 	imageURLSizeMarkerLen = len(imageURLSizeMarker)
 
 	gcsReadOnlyScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+	// values of config.Schedule.OnChunkError
+	chunkErrorFail     = "fail"
+	chunkErrorKeepLast = "keepLast"
+
+	// default data files fetch settings of config.Schedule
+	defaultFetchConcurrency = 4
+	defaultFetchRetries     = 3
+	defaultFetchTimeout     = 30 * time.Second
 )
 
 var (
@@ -54,6 +64,8 @@ var (
 	surveySessionIDs = []string{keynoteID}
 	// reChannleID parses session description text.
 	reChannelID = regexp.MustCompile("(?i)channel\\s+(\\d)")
+	// fetchRetryDelay is the base delay of fetchBackoff.
+	fetchRetryDelay = 500 * time.Millisecond
 )
 
 type eventData struct {
@@ -61,10 +73,17 @@ type eventData struct {
 	Speakers map[string]*eventSpeaker `json:"speakers,omitempty"`
 	Videos   map[string]*eventVideo   `json:"video_library,omitempty"`
 	Tags     map[string]*eventTag     `json:"tags,omitempty"`
+	// Data files the event data was merged from, keyed by their URLs.
+	// Set on the versions stored by syncs only.
+	Chunks map[string]*eventDataChunk `json:"-"`
 	// not exposed
+	chunk    *eventDataChunk // the file a chunk was parsed from, set by slurpEventDataChunk
 	rooms    map[string]*eventRoom
 	modified time.Time
 	etag     string
+	changed  []string           // data files changed since the previous sync
+	issues   []*validationIssue // found by parseEventDataChunk
+	report   *validationReport  // created by fetchEventData
 }
 
 type eventSession struct {
@@ -94,6 +113,22 @@ type eventSession struct {
 
 	// Update is used only api/user/updates
 	Update string `json:"update,omitempty"`
+	// Changes and Prev are set only along with updateDetails.
+	// Changes lists kinds of the changes, most important first,
+	// and Prev the previous values of the changed fields.
+	Changes []string     `json:"changes,omitempty"`
+	Prev    *sessionPrev `json:"prev,omitempty"`
+}
+
+// sessionPrev holds previous values of eventSession fields.
+// Only fields of the changed kinds are set.
+type sessionPrev struct {
+	StartTime *time.Time `json:"startTimestamp,omitempty"`
+	EndTime   *time.Time `json:"endTimestamp,omitempty"`
+	Room      string     `json:"room,omitempty"`
+	Speakers  []string   `json:"speakers,omitempty"`
+	Title     string     `json:"title,omitempty"`
+	Desc      string     `json:"description,omitempty"`
 }
 
 func (s *eventSession) hasLiveChannel() bool {
@@ -119,6 +154,9 @@ type eventSpeaker struct {
 	Thumb   string `json:"thumbnailUrl,omitempty"`
 	Plusone string `json:"plusoneUrl,omitempty"`
 	Twitter string `json:"twitterUrl,omitempty"`
+
+	// Update is used only in dataChanges, for added and removed speakers
+	Update string `json:"update,omitempty"`
 }
 
 type eventVideo struct {
@@ -128,6 +166,9 @@ type eventVideo struct {
 	Topic    string `json:"topic,omitempty"`
 	Speakers string `json:"speakers,omitempty"`
 	Thumb    string `json:"thumbnailUrl,omitempty"`
+
+	// Update is used only in dataChanges, for added and removed videos
+	Update string `json:"update,omitempty"`
 }
 
 type eventRoom struct {
@@ -147,19 +188,26 @@ func isEmptyEventData(d *eventData) bool {
 	return d == nil || (len(d.Sessions) == 0 && len(d.Speakers) == 0 && len(d.Videos) == 0 && len(d.Tags) == 0)
 }
 
-// fetchEventData retrieves complete event data starting from manifest at url.
-// If the manifest has not changed since lastSync, both returned values are nil.
-func fetchEventData(c context.Context, urlStr string, lastSync time.Time) (*eventData, error) {
-	u, err := url.Parse(urlStr)
-	if err != nil {
-		return nil, err
-	}
-	hc, err := serviceAccountClient(c, gcsReadOnlyScope)
+// fetchEventData retrieves complete event data starting from manifest at url,
+// which is either an http(s) URL, a file:// URL or a local path. See newManifestSource.
+// If the manifest has not changed since the last stored version, both returned values are nil.
+//
+// Data files are fetched conditionally: unchanged ones are reused from Chunks of last,
+// so that nothing is considered synced until a version containing it is stored.
+// Names of the files which have changed are in the changed field of the returned value.
+// At most config.Schedule.FetchConcurrency files are fetched at a time, see fetchEventDataChunk.
+// Failed files are reported with chunkErrors, unless config.Schedule.OnChunkError
+// is chunkErrorKeepLast and the file is in Chunks of last.
+//
+// Mistakes found in the data files are listed in the report field of the returned value,
+// see validateEventData.
+func fetchEventData(c context.Context, urlStr string, last *eventData) (*eventData, error) {
+	src, manifest, err := newManifestSource(c, urlStr)
 	if err != nil {
 		return nil, fmt.Errorf("fetchEventData: %v", err)
 	}
 
-	files, lastMod, err := fetchEventManifest(c, hc, u.String(), lastSync)
+	files, lastMod, err := fetchEventManifest(c, src, manifest, last.modified)
 	if err != nil {
 		return nil, err
 	}
@@ -167,47 +215,74 @@ func fetchEventData(c context.Context, urlStr string, lastSync time.Time) (*even
 		return nil, nil
 	}
 
-	// base file URLs off manifest location
-	base := path.Dir(u.Path)
-
-	var mu sync.Mutex  // guards chunks and slurpErr
-	var slurpErr error // last slurp error, if any
-	chunks := make([]*eventData, 0, len(files))
+	var mu sync.Mutex // guards chunks, changed and errs
+	chunks := make(map[string]*eventData, len(files))
+	var changed []string
+	errs := make(chunkErrors)
 
-	// fetch all files in the manifest in parallel
+	// fetch all files in the manifest in parallel,
+	// relative to the manifest location
+	keepLast := config().Schedule.OnChunkError == chunkErrorKeepLast
+	sem := make(chan struct{}, fetchConcurrency())
 	var wg sync.WaitGroup
 	for _, f := range files {
-		u.Path = path.Join(base, f)
 		wg.Add(1)
-		go func(u string) {
+		go func(f string) {
 			defer wg.Done()
-			res, err := slurpEventDataChunk(c, hc, u)
+			sem <- struct{}{}
+			prev := last.Chunks[src.url(f)]
+			res, modified, err := fetchEventDataChunk(c, src, f, prev)
+			<-sem
+			if err != nil && keepLast && prev != nil {
+				if lres, lerr := parseEventDataChunk(prev.Body); lerr == nil {
+					errorf(c, "%s: %v; keeping the last good version", src.url(f), err)
+					syncChunksTotal.inc("kept")
+					lres.chunk = prev
+					res, modified, err = lres, false, nil
+				}
+			}
 			mu.Lock()
 			defer mu.Unlock()
 			if err != nil {
-				errorf(c, "slurpEventDataChunk(%q): %v", u, err)
-				slurpErr = err
+				errorf(c, "fetchEventDataChunk(%q): %v", src.url(f), err)
+				syncChunksTotal.inc("failed")
+				errs[f] = err
 				return
 			}
-			chunks = append(chunks, res)
-		}(u.String())
+			chunks[f] = res
+			if modified {
+				changed = append(changed, f)
+				syncChunksTotal.inc("changed")
+			} else {
+				syncChunksTotal.inc("unchanged")
+			}
+		}(f)
 	}
 
 	wg.Wait()
-	if slurpErr != nil {
-		return nil, slurpErr
+	if len(errs) > 0 {
+		return nil, errs
 	}
 
+	report := validateEventData(files, chunks)
+	report.Modified = lastMod
 	rooms := make(map[string]*eventRoom)
 	data := &eventData{
 		Tags:     make(map[string]*eventTag),
 		Speakers: make(map[string]*eventSpeaker),
 		Videos:   make(map[string]*eventVideo),
 		Sessions: make(map[string]*eventSession),
+		Chunks:   make(map[string]*eventDataChunk, len(files)),
 		modified: lastMod,
+		changed:  changed,
+		report:   report,
 	}
+	sort.Strings(data.changed)
 
-	for _, chunk := range chunks {
+	// merge in the manifest order, so that later files consistently override earlier ones
+	for _, f := range files {
+		chunk := chunks[f]
+		data.Chunks[src.url(f)] = chunk.chunk
 		for k, v := range chunk.rooms {
 			rooms[k] = v
 		}
@@ -216,7 +291,8 @@ func fetchEventData(c context.Context, urlStr string, lastSync time.Time) (*even
 		}
 	}
 
-	for _, chunk := range chunks {
+	for _, f := range files {
+		chunk := chunks[f]
 		for k, v := range chunk.Speakers {
 			data.Speakers[k] = v
 		}
@@ -246,39 +322,29 @@ func fetchEventData(c context.Context, urlStr string, lastSync time.Time) (*even
 	return data, nil
 }
 
-// fetchEventManifest retrieves a list of URLs containing event schedule data.
-// url should point to the manifest.json file.
+// fetchEventManifest retrieves a list of files containing event schedule data,
+// relative to the manifest location.
+// name is the manifest file name of src.
 // Returned Time is the timestamp of last modification.
 // If data hasn't changed since lastSync, both returned values are nil.
-func fetchEventManifest(c context.Context, hc *http.Client, url string, lastSync time.Time) ([]string, time.Time, error) {
-	logf(c, "fetching manifest from %s", url)
+func fetchEventManifest(c context.Context, src manifestSource, name string, lastSync time.Time) ([]string, time.Time, error) {
+	logf(c, "fetching manifest from %s", src.url(name))
 	mod := time.Now()
 
-	r, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, mod, err
-	}
-	r.Header.Set("if-modified-since", lastSync.UTC().Format(http.TimeFormat))
-	res, err := hc.Do(r)
-	if err != nil {
-		return nil, mod, err
-	}
-	defer res.Body.Close()
-	if res.StatusCode == http.StatusNotModified {
+	f, err := src.fetch(c, name, fetchCond{since: lastSync})
+	if err == errNotModified {
 		return nil, mod, nil
 	}
-	if res.StatusCode != http.StatusOK {
-		return nil, mod, fmt.Errorf("fetchEventManifest: %s", res.Status)
-	}
-	t, err := time.ParseInLocation(http.TimeFormat, res.Header.Get("last-modified"), time.UTC)
-	if err == nil {
-		mod = t
+	if err != nil {
+		return nil, mod, fmt.Errorf("fetchEventManifest: %v", err)
 	}
+	defer f.Close()
+	mod = f.modified
 
 	var data struct {
 		Files []string `json:"data_files"`
 	}
-	if err := json.NewDecoder(res.Body).Decode(&data); err != nil {
+	if err := json.NewDecoder(f).Decode(&data); err != nil {
 		return nil, mod, err
 	}
 
@@ -292,19 +358,130 @@ func fetchEventManifest(c context.Context, hc *http.Client, url string, lastSync
 	return files, mod, err
 }
 
-// slurpEventDataChunk retrieves a chunk of event data at url
-// any, all or none of the returned *eventData fields can be non-empty.
-func slurpEventDataChunk(c context.Context, hc *http.Client, url string) (*eventData, error) {
-	logf(c, "slurping %s", url)
-	res, err := hc.Get(url)
+// chunkErrors maps data file names to their fetch errors.
+type chunkErrors map[string]error
+
+func (e chunkErrors) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msg := make([]string, len(names))
+	for i, name := range names {
+		msg[i] = fmt.Sprintf("%s: %v", name, e[name])
+	}
+	return fmt.Sprintf("%d data files failed: %s", len(e), strings.Join(msg, "; "))
+}
+
+// fetchConcurrency returns the max number of data files fetched at a time.
+func fetchConcurrency() int {
+	if n := config().Schedule.FetchConcurrency; n > 0 {
+		return n
+	}
+	return defaultFetchConcurrency
+}
+
+// fetchEventDataChunk calls slurpEventDataChunk with a timeout of config.Schedule.FetchTimeout,
+// and retries transient errors with jittered exponential backoff
+// up to config.Schedule.FetchRetries times.
+func fetchEventDataChunk(c context.Context, src manifestSource, name string, prev *eventDataChunk) (*eventData, bool, error) {
+	cfg := config()
+	timeout := time.Duration(cfg.Schedule.FetchTimeout)
+	if timeout <= 0 {
+		timeout = defaultFetchTimeout
+	}
+	retries := cfg.Schedule.FetchRetries
+	if retries <= 0 {
+		retries = defaultFetchRetries
+	}
+	for n := 0; ; n++ {
+		tc, cancel := context.WithTimeout(c, timeout)
+		data, modified, err := slurpEventDataChunk(tc, src, name, prev)
+		cancel()
+		if err == nil || n >= retries || !isTransientError(err) {
+			return data, modified, err
+		}
+		d := fetchBackoff(n)
+		errorf(c, "%s: %v; retrying in %s", src.url(name), err, d)
+		select {
+		case <-time.After(d):
+		case <-c.Done():
+			return nil, false, err
+		}
+	}
+}
+
+// fetchBackoff returns a random delay before n-th retry of a data file fetch,
+// between half and full of fetchRetryDelay doubled n times.
+func fetchBackoff(n int) time.Duration {
+	d := fetchRetryDelay << uint(n)
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// isTransientError reports whether a data file fetch which failed with err
+// may succeed if retried: timeouts, network errors, 5xx and 429 responses.
+func isTransientError(err error) bool {
+	switch e := err.(type) {
+	case *statusError:
+		return e.code >= 500 || e.code == http.StatusTooManyRequests
+	case net.Error:
+		return true
+	}
+	return err == context.DeadlineExceeded
+}
+
+// eventDataChunk is a data file of a sync along with its validators,
+// stored in Chunks of the event data version it was merged into.
+type eventDataChunk struct {
+	ETag     string
+	Modified time.Time
+	Body     []byte
+}
+
+// slurpEventDataChunk retrieves a chunk of event data from file name of src.
+// The file is fetched only if it has changed since prev, the file of the last stored version,
+// as indicated by the returned bool. Otherwise, prev is reused.
+// The file is set as the chunk field of the returned value.
+func slurpEventDataChunk(c context.Context, src manifestSource, name string, prev *eventDataChunk) (*eventData, bool, error) {
+	u := src.url(name)
+	var cond fetchCond
+	if prev != nil {
+		cond = fetchCond{etag: prev.ETag, since: prev.Modified}
+	}
+
+	logf(c, "slurping %s", u)
+	f, err := src.fetch(c, name, cond)
+	if err == errNotModified {
+		logf(c, "%s: not modified; using the last stored copy", u)
+		data, err := parseEventDataChunk(prev.Body)
+		if data != nil {
+			data.chunk = prev
+		}
+		return data, false, err
+	}
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("fetchEventSchedule(%q): %v", url, err)
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, false, err
 	}
+	data, err := parseEventDataChunk(b)
+	if err != nil {
+		return nil, false, err
+	}
+	data.chunk = &eventDataChunk{ETag: f.etag, Modified: f.modified, Body: b}
+	return data, true, nil
+}
 
+// parseEventDataChunk decodes a chunk of event data from its JSON representation b.
+// any, all or none of the returned *eventData fields can be non-empty.
+// Items without an ID and sessions starting before config.Schedule.Start are dropped.
+// Dropped and duplicate items are recorded in the issues field of the result.
+func parseEventDataChunk(b []byte) (*eventData, error) {
+	cfg := config()
 	var body struct {
 		Sessions []*eventSession `json:"sessions"`
 		Rooms    []*eventRoom    `json:"rooms"`
@@ -312,30 +489,56 @@ func slurpEventDataChunk(c context.Context, hc *http.Client, url string) (*event
 		Videos   []*eventVideo   `json:"video_library"`
 		Speakers []*eventSpeaker `json:"speakers"`
 	}
-	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+	if err := json.Unmarshal(b, &body); err != nil {
 		return nil, err
 	}
 
+	// items which are dropped or defined more than once
+	var issues issueList
+
 	rooms := make(map[string]*eventRoom, len(body.Rooms))
 	for _, r := range body.Rooms {
+		if r.ID == "" {
+			issues.add(issueMissingID, "room", "", "room %q has no ID", r.Name)
+			continue
+		}
+		if _, ok := rooms[r.ID]; ok {
+			issues.add(issueDuplicateID, "room", r.ID, "defined more than once")
+		}
 		rooms[r.ID] = r
 	}
 
 	tags := make(map[string]*eventTag, len(body.Tags))
 	for _, t := range body.Tags {
+		if t.Tag == "" {
+			issues.add(issueMissingID, "tag", "", "tag %q has no ID", t.Name)
+			continue
+		}
+		if _, ok := tags[t.Tag]; ok {
+			issues.add(issueDuplicateID, "tag", t.Tag, "defined more than once")
+		}
 		tags[t.Tag] = t
 	}
 
 	sessions := make(map[string]*eventSession, len(body.Sessions))
 	for _, s := range body.Sessions {
-		if s.ID == "" || s.StartTime.Before(config.Schedule.Start) {
+		if s.ID == "" {
+			issues.add(issueMissingID, "session", "", "session %q has no ID; dropped", s.Title)
+			continue
+		}
+		if s.StartTime.Before(cfg.Schedule.Start) {
+			issues.add(issueBeforeStart, "session", s.ID, "starts at %s before the event start %s; dropped",
+				s.StartTime.Format(time.RFC3339), cfg.Schedule.Start.Format(time.RFC3339))
 			continue
 		}
+		if _, ok := sessions[s.ID]; ok {
+			issues.add(issueDuplicateID, "session", s.ID, "defined more than once")
+		}
 
-		tzstart := s.StartTime.In(config.Schedule.Location)
+		tzstart := s.StartTime.In(cfg.Schedule.Location)
 		s.Block = strings.Replace(tzstart.Format("304 PM"), "00 ", " ", 1)
 		s.Start = tzstart.Format("3:04 PM")
-		s.End = s.EndTime.In(config.Schedule.Location).Format("3:04 PM")
+		s.End = s.EndTime.In(cfg.Schedule.Location).Format("3:04 PM")
 		s.Duration = durationStr(s.EndTime.Sub(s.StartTime))
 		s.Day = tzstart.Day()
 
@@ -352,16 +555,24 @@ func slurpEventDataChunk(c context.Context, hc *http.Client, url string) (*event
 	videos := make(map[string]*eventVideo, len(body.Videos))
 	for _, v := range body.Videos {
 		if v.ID == "" {
+			issues.add(issueMissingID, "video", "", "video %q has no ID; dropped", v.Title)
 			continue
 		}
+		if _, ok := videos[v.ID]; ok {
+			issues.add(issueDuplicateID, "video", v.ID, "defined more than once")
+		}
 		videos[v.ID] = v
 	}
 
 	speakers := make(map[string]*eventSpeaker, len(body.Speakers))
 	for _, s := range body.Speakers {
 		if s.ID == "" {
+			issues.add(issueMissingID, "speaker", "", "speaker %q has no ID; dropped", s.Name)
 			continue
 		}
+		if _, ok := speakers[s.ID]; ok {
+			issues.add(issueDuplicateID, "speaker", s.ID, "defined more than once")
+		}
 		speakers[s.ID] = s
 	}
 
@@ -371,12 +582,16 @@ func slurpEventDataChunk(c context.Context, hc *http.Client, url string) (*event
 		Videos:   videos,
 		Tags:     tags,
 		rooms:    rooms,
+		issues:   issues,
 	}, nil
 }
 
-// diffEventData looks for changes in existing items of b comparing to a.
+// diffEventData looks for changes in items of b comparing to a.
 // It compares only Sessions, Speakers and Videos of eventData.
-// The result is a subset of b or nil if a is empty.
+// Items of b which are not in a are included with updateAdded,
+// and items of a which are not in b with updateRemoved. Added and removed items are copies,
+// so that setting their Update field doesn't affect either a or b.
+// The result is nil if a is empty.
 // Side effects: Update field of b.Session elements may be modified;
 func diffEventData(a, b *eventData) *dataChanges {
 	if isEmptyEventData(a) {
@@ -393,20 +608,60 @@ func diffEventData(a, b *eventData) *dataChanges {
 	for id, bs := range b.Sessions {
 		as, ok := a.Sessions[id]
 		if !ok {
+			s := *bs
+			s.Update = updateAdded
+			dc.Sessions[id] = &s
 			continue
 		}
 		if compareSessions(as, bs) {
 			dc.Sessions[id] = bs
 		}
 	}
+	for id, as := range a.Sessions {
+		if _, ok := b.Sessions[id]; !ok {
+			s := *as
+			s.Update = updateRemoved
+			dc.Sessions[id] = &s
+		}
+	}
+
 	for id, bs := range b.Speakers {
-		if as, ok := a.Speakers[id]; ok && !reflect.DeepEqual(as, bs) {
+		as, ok := a.Speakers[id]
+		if !ok {
+			s := *bs
+			s.Update = updateAdded
+			dc.Speakers[id] = &s
+			continue
+		}
+		if !reflect.DeepEqual(as, bs) {
 			dc.Speakers[id] = bs
 		}
 	}
-	for id, bs := range b.Videos {
-		if as, ok := a.Videos[id]; ok && !reflect.DeepEqual(as, bs) {
-			dc.Videos[id] = bs
+	for id, as := range a.Speakers {
+		if _, ok := b.Speakers[id]; !ok {
+			s := *as
+			s.Update = updateRemoved
+			dc.Speakers[id] = &s
+		}
+	}
+
+	for id, bv := range b.Videos {
+		av, ok := a.Videos[id]
+		if !ok {
+			v := *bv
+			v.Update = updateAdded
+			dc.Videos[id] = &v
+			continue
+		}
+		if !reflect.DeepEqual(av, bv) {
+			dc.Videos[id] = bv
+		}
+	}
+	for id, av := range a.Videos {
+		if _, ok := b.Videos[id]; !ok {
+			v := *av
+			v.Update = updateRemoved
+			dc.Videos[id] = &v
 		}
 	}
 	return dc
@@ -414,6 +669,7 @@ func diffEventData(a, b *eventData) *dataChanges {
 
 // compareSessions compares eventSession fields of a to those of b.
 // It returns true and modifies b.Update field if the two args have different field values.
+// Details updates are also classified with sessionChanges, setting b.Changes and b.Prev.
 //
 // While most of the fields are compared with reflect.DeepEqual,
 // IsLive and YouTube fields are treated separately. They are compared
@@ -424,10 +680,10 @@ func compareSessions(a, b *eventSession) bool {
 	// save originals
 	ob := *b
 	defer func() {
-		// restore all b's field from ob except .Update
-		up := b.Update
+		// restore all b's field from ob except .Update, .Changes and .Prev
+		up, changes, prev := b.Update, b.Changes, b.Prev
 		*b = ob
-		b.Update = up
+		b.Update, b.Changes, b.Prev = up, changes, prev
 	}()
 
 	// normalize slices
@@ -457,6 +713,7 @@ func compareSessions(a, b *eventSession) bool {
 	b.YouTube = a.YouTube
 	if !reflect.DeepEqual(a, b) {
 		b.Update = updateDetails
+		b.Changes, b.Prev = sessionChanges(a, b)
 		return true
 	}
 	// compare for 'video' updates, but only for past sessions
@@ -472,6 +729,44 @@ func compareSessions(a, b *eventSession) bool {
 	return false
 }
 
+// sessionChanges classifies differences between a and b, a different version of a,
+// into changeTime, changeRoom, changeSpeakers and changeDetails, in that order.
+// It returns the kinds along with the previous values of the changed fields.
+func sessionChanges(a, b *eventSession) ([]string, *sessionPrev) {
+	var kinds []string
+	prev := &sessionPrev{}
+	if !a.StartTime.Equal(b.StartTime) || !a.EndTime.Equal(b.EndTime) {
+		kinds = append(kinds, changeTime)
+		start, end := a.StartTime, a.EndTime
+		prev.StartTime, prev.EndTime = &start, &end
+	}
+	if a.Room != b.Room {
+		kinds = append(kinds, changeRoom)
+		prev.Room = a.Room
+	}
+	if !reflect.DeepEqual(a.Speakers, b.Speakers) {
+		kinds = append(kinds, changeSpeakers)
+		prev.Speakers = a.Speakers
+	}
+
+	// everything else, including fields derived from time and room, is a details change
+	x := *b
+	x.StartTime, x.EndTime, x.Room, x.Speakers = a.StartTime, a.EndTime, a.Room, a.Speakers
+	x.Day, x.Block, x.Start, x.End, x.Duration = a.Day, a.Block, a.Start, a.End, a.Duration
+	x.Filters = a.Filters
+	x.Update, x.Changes, x.Prev = a.Update, a.Changes, a.Prev
+	if len(kinds) == 0 || !reflect.DeepEqual(a, &x) {
+		kinds = append(kinds, changeDetails)
+		if a.Title != b.Title {
+			prev.Title = a.Title
+		}
+		if a.Desc != b.Desc {
+			prev.Desc = a.Desc
+		}
+	}
+	return kinds, prev
+}
+
 // upcomingSessions returns a subset of item copies which have their StartTime field
 // close to timeoutStart or timeoutSoon.
 // It also sets Update field of the returned elements to updateStart or updateSoon respectively.
@@ -540,6 +835,7 @@ func userSchedule(c context.Context, uid string) ([]string, error) {
 // for the current day or event first day if start date is in the future comparing to now.
 // Keynote element is always first, even if its youtubeUrl value is empty.
 func scheduleLiveIDs(c context.Context, now time.Time) ([]string, error) {
+	cfg := config()
 	d, err := getLatestEventData(c, nil)
 	if err != nil {
 		return nil, err
@@ -548,8 +844,8 @@ func scheduleLiveIDs(c context.Context, now time.Time) ([]string, error) {
 		return nil, nil
 	}
 
-	now = now.In(config.Schedule.Location)
-	start := config.Schedule.Start.In(config.Schedule.Location)
+	now = now.In(cfg.Schedule.Location)
+	start := cfg.Schedule.Start.In(cfg.Schedule.Location)
 	theday := start.YearDay()
 	if now.After(start) {
 		theday = now.YearDay()
@@ -557,7 +853,7 @@ func scheduleLiveIDs(c context.Context, now time.Time) ([]string, error) {
 
 	live := sortedChannelSessions(make([]*eventSession, 0, len(d.Sessions)/2))
 	for id, s := range d.Sessions {
-		sday := s.StartTime.In(config.Schedule.Location).YearDay()
+		sday := s.StartTime.In(cfg.Schedule.Location).YearDay()
 		if id == keynoteID || !s.hasLiveChannel() || sday != theday {
 			continue
 		}
@@ -97,6 +97,39 @@ func TestDiffEventData(t *testing.T) {
 	}
 }
 
+func TestDiffEventDataAddedRemoved(t *testing.T) {
+	t.Parallel()
+	a := &eventData{
+		Sessions: map[string]*eventSession{"kept": {ID: "kept"}, "cancelled": {ID: "cancelled"}},
+		Speakers: map[string]*eventSpeaker{"gone": {ID: "gone"}},
+		Videos:   map[string]*eventVideo{"v1": {ID: "v1"}},
+	}
+	b := &eventData{
+		Sessions: map[string]*eventSession{"kept": {ID: "kept"}, "new": {ID: "new"}},
+		Videos:   map[string]*eventVideo{"v1": {ID: "v1"}, "v2": {ID: "v2"}},
+	}
+	dc := diffEventData(a, b)
+
+	sessions := make(map[string]string)
+	for id, s := range dc.Sessions {
+		sessions[id] = s.Update
+	}
+	want := map[string]string{"cancelled": updateRemoved, "new": updateAdded}
+	if !reflect.DeepEqual(sessions, want) {
+		t.Errorf("sessions = %v; want %v", sessions, want)
+	}
+	if s := dc.Speakers["gone"]; len(dc.Speakers) != 1 || s == nil || s.Update != updateRemoved {
+		t.Errorf("dc.Speakers = %v; want gone removed", dc.Speakers)
+	}
+	if v := dc.Videos["v2"]; len(dc.Videos) != 1 || v == nil || v.Update != updateAdded {
+		t.Errorf("dc.Videos = %v; want v2 added", dc.Videos)
+	}
+	// items of a and b are not modified
+	if a.Sessions["cancelled"].Update != "" || b.Sessions["new"].Update != "" || a.Speakers["gone"].Update != "" {
+		t.Errorf("diffEventData modified its args")
+	}
+}
+
 func TestDiffEventDataVideo(t *testing.T) {
 	t.Parallel()
 	date := time.Now().Round(time.Second)
@@ -201,8 +234,8 @@ func TestScheduleLiveIDs(t *testing.T) {
 
 	now := time.Now().UTC()
 	tomorrow := now.Add(24 * time.Hour)
-	config.Schedule.Location = time.UTC
-	config.Schedule.Start = now
+	config().Schedule.Location = time.UTC
+	config().Schedule.Start = now
 
 	c := newContext(newTestRequest(t, "GET", "/", nil))
 	if err := storeEventData(c, &eventData{Sessions: map[string]*eventSession{
@@ -241,3 +274,70 @@ func TestScheduleLiveIDs(t *testing.T) {
 		}
 	}
 }
+
+func TestSessionChanges(t *testing.T) {
+	t.Parallel()
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Minute)
+	a := &eventSession{
+		ID:        "s",
+		Title:     "Title",
+		Desc:      "Desc",
+		Room:      "Stage 3",
+		Speakers:  []string{"spk-1"},
+		StartTime: start,
+		EndTime:   start.Add(time.Hour),
+		Start:     "2:00 PM",
+	}
+	tests := []struct {
+		edit    func(s *eventSession)
+		changes []string
+		prev    *sessionPrev
+	}{
+		{
+			func(s *eventSession) { s.Room = "Stage 5" },
+			[]string{changeRoom},
+			&sessionPrev{Room: "Stage 3"},
+		},
+		{
+			func(s *eventSession) {
+				s.StartTime, s.EndTime = start.Add(2*time.Hour), start.Add(3*time.Hour)
+				s.Start = "4:00 PM"
+				s.Room = "Stage 5"
+			},
+			[]string{changeTime, changeRoom},
+			&sessionPrev{StartTime: &a.StartTime, EndTime: &a.EndTime, Room: "Stage 3"},
+		},
+		{
+			func(s *eventSession) { s.Speakers = []string{"spk-2"} },
+			[]string{changeSpeakers},
+			&sessionPrev{Speakers: []string{"spk-1"}},
+		},
+		{
+			func(s *eventSession) { s.Title, s.Tags = "New title", []string{"TOPIC_WEB"} },
+			[]string{changeDetails},
+			&sessionPrev{Title: "Title"},
+		},
+		{
+			func(s *eventSession) { s.Speakers, s.Desc = nil, "New desc" },
+			[]string{changeSpeakers, changeDetails},
+			&sessionPrev{Speakers: []string{"spk-1"}, Desc: "Desc"},
+		},
+	}
+	for i, test := range tests {
+		b := *a
+		test.edit(&b)
+		if !compareSessions(a, &b) {
+			t.Errorf("%d: compareSessions = false; want true", i)
+			continue
+		}
+		if b.Update != updateDetails {
+			t.Errorf("%d: b.Update = %q; want %q", i, b.Update, updateDetails)
+		}
+		if !reflect.DeepEqual(b.Changes, test.changes) {
+			t.Errorf("%d: b.Changes = %v; want %v", i, b.Changes, test.changes)
+		}
+		if !reflect.DeepEqual(b.Prev, test.prev) {
+			t.Errorf("%d: b.Prev = %+v; want %+v", i, b.Prev, test.prev)
+		}
+	}
+}
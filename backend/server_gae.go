@@ -0,0 +1,62 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build appengine
+
+package backend
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+)
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+	if err := initConfig("server.config", ""); err != nil {
+		panic("initConfig: " + err.Error())
+	}
+	// TODO: remove cache and use memcache directly
+	cache = metricsCache{&gaeMemcache{}}
+	initCache()
+	store = &gaeDatastore{}
+	queue = &gaeTaskQueue{}
+
+	// allow access only by whitelisted people/domains if not empty
+	initWhitelist()
+	wrapHandler = checkWhitelist
+	rootHandleFn = serveTemplate
+	registerHandlers()
+}
+
+// newContext returns a context of the in-flight request r.
+func newContext(r *http.Request) context.Context {
+	return withRequestFields(appengine.NewContext(r), r)
+}
+
+// logf logs an info message with all log fields of c using appengine's context.
+func logf(c context.Context, format string, args ...interface{}) {
+	log.Infof(c, "%s", logEntry(c, levelInfo, fmt.Sprintf(format, args...)))
+}
+
+// errorf logs an error message with all log fields of c using appengine's context.
+func errorf(c context.Context, format string, args ...interface{}) {
+	log.Errorf(c, "%s", logEntry(c, levelError, fmt.Sprintf(format, args...)))
+}
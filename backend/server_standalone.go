@@ -0,0 +1,209 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !appengine
+
+package backend
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ListenAndServe initializes the backend from a server config file at configPath
+// and starts serving requests on config.Addr.
+// A non-empty addr takes precedence over the config file value.
+// It is the standalone server counterpart of the GAE init() in server_gae.go.
+//
+// On SIGTERM or SIGINT the server shuts down gracefully, saving unfinished work
+// next to config.DataFile, which is resumed on the next start.
+func ListenAndServe(configPath, addr string) error {
+	rand.Seed(time.Now().UnixNano())
+	if err := initConfig(configPath, addr); err != nil {
+		return err
+	}
+	cache = metricsCache{newMemoryCache(memoryCacheSize)}
+	initCache()
+	var err error
+	if store, err = newFileStore(config().DataFile); err != nil {
+		return err
+	}
+	qcfg, err := readQueueConfig(filepath.Join(filepath.Dir(configPath), "queue.yaml"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	tq, err := newLocalTaskQueue(qcfg, http.DefaultServeMux)
+	if err != nil {
+		return err
+	}
+	queue = tq
+	jobs, err := readCronConfig(filepath.Join(filepath.Dir(configPath), "cron.yaml.template"), config().Prefix)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	cron = newCronScheduler(jobs, http.DefaultServeMux)
+	pendingFile := pendingWorkFile(config().DataFile)
+	pw, err := loadPendingWork(pendingFile)
+	if err != nil {
+		return err
+	}
+
+	httpTransport = func(context.Context) http.RoundTripper {
+		return http.DefaultTransport
+	}
+	initWhitelist()
+	wrapHandler = func(h http.Handler) http.Handler {
+		return logHandler(checkWhitelist(h))
+	}
+	rootHandleFn = serveStaticOrTemplate
+	registerHandlers()
+	cron.start()
+	if len(pw.Tasks) > 0 || len(pw.Cron) > 0 {
+		logf(context.Background(), "resuming %d tasks and %d cron jobs from %s", len(pw.Tasks), len(pw.Cron), pendingFile)
+	}
+	tq.restore(pw.Tasks)
+	cron.restore(pw.Cron)
+	allowConfigReload = true
+	go watchConfig(configWatchInterval)
+
+	srv := &http.Server{Addr: config().Addr, Handler: stripGAEHeaders(http.DefaultServeMux)}
+	done := make(chan error, 1)
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+		logf(context.Background(), "%s received; shutting down", <-sig)
+		done <- shutdown(srv, tq, cron, pendingFile, shutdownTimeout)
+	}()
+
+	logf(context.Background(), "serving %s on %s%s", config().Env, config().Addr, config().Prefix)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return <-done
+}
+
+// configWatchInterval is how often watchConfig checks config files for changes.
+const configWatchInterval = 5 * time.Second
+
+// watchConfig reloads config on SIGHUP or when either server config file
+// or HTTP/2 preload manifest is modified. Files are checked every d.
+// It never returns.
+func watchConfig(d time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	files := []string{configFile, h2preloadPath(configFile)}
+	mtime := configModTime(files)
+	tick := time.NewTicker(d)
+	for {
+		select {
+		case <-hup:
+			logf(context.Background(), "watchConfig: SIGHUP received")
+		case <-tick.C:
+			t := configModTime(files)
+			if t.Equal(mtime) {
+				continue
+			}
+			mtime = t
+			logf(context.Background(), "watchConfig: config files modified")
+		}
+		reloadConfigLog(context.Background())
+	}
+}
+
+// configModTime returns the latest modification time of files.
+// Missing files are ignored.
+func configModTime(files []string) time.Time {
+	var t time.Time
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil && fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return t
+}
+
+// stripGAEHeaders removes X-AppEngine-* headers from incoming requests before handing them
+// over to h. Similar to GAE, such headers can only be set internally, e.g. by localTaskQueue.
+func stripGAEHeaders(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k := range r.Header {
+			if strings.HasPrefix(k, "X-Appengine-") {
+				delete(r.Header, k)
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// logHandler logs each in-flight request before handing it over to h.
+func logHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logf(newContext(r), "%s %s", r.Method, r.URL)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// serveStaticOrTemplate responds with a static file found under config.Dir,
+// similar to static handlers of app.yaml.
+// Requests without a file extension or those not matching a file on disk
+// are handled by serveTemplate.
+func serveStaticOrTemplate(w http.ResponseWriter, r *http.Request) {
+	p := path.Clean("/" + r.URL.Path)
+	if path.Ext(p) == "" || strings.HasPrefix(p, "/"+templatesDir+"/") {
+		serveTemplate(w, r)
+		return
+	}
+	f, err := http.Dir(config().Dir).Open(p)
+	if err != nil {
+		serveTemplate(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		serveTemplate(w, r)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
+
+// jsonLog writes structured log entries, one JSON object per line.
+var jsonLog = log.New(os.Stderr, "", 0)
+
+// newContext returns a context of the in-flight request r.
+func newContext(r *http.Request) context.Context {
+	return withRequestFields(context.Background(), r)
+}
+
+// logf logs an info message with all log fields of c using jsonLog.
+func logf(c context.Context, format string, args ...interface{}) {
+	jsonLog.Print(logEntry(c, levelInfo, fmt.Sprintf(format, args...), "time", time.Now().Format(time.RFC3339Nano)))
+}
+
+// errorf logs an error message with all log fields of c using jsonLog.
+func errorf(c context.Context, format string, args ...interface{}) {
+	jsonLog.Print(logEntry(c, levelError, fmt.Sprintf(format, args...), "time", time.Now().Format(time.RFC3339Nano)))
+}
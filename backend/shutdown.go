@@ -0,0 +1,114 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"encoding/gob"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// shutdownTimeout is how long shutdown waits for in-flight requests,
+	// cron jobs and due tasks to finish.
+	shutdownTimeout = 30 * time.Second
+	// shutdownGrace is how long handlers have to hand over their remaining work
+	// once shutdownTimeout has passed.
+	shutdownGrace = 2 * time.Second
+)
+
+// stopping is set to 1 when the server is about to exit.
+// Use isStopping to read its value.
+var stopping int32
+
+// isStopping reports whether the server is about to exit.
+// Long running handlers should check it periodically and hand over
+// remaining work to a new task instead of continuing.
+func isStopping() bool {
+	return atomic.LoadInt32(&stopping) == 1
+}
+
+// pendingWork is the unfinished work of a shutdown, resumed on the next start.
+type pendingWork struct {
+	Tasks []*pendingTask
+	Cron  []string // URLs of interrupted cron jobs
+}
+
+// pendingWorkFile returns the path where pendingWork is kept alongside the data file.
+// It returns an empty string if dataFile is empty.
+func pendingWorkFile(dataFile string) string {
+	if dataFile == "" {
+		return ""
+	}
+	return dataFile + ".pending"
+}
+
+// loadPendingWork reads pendingWork saved at path and removes the file,
+// so that the same work is never resumed twice.
+// It returns empty pendingWork if path is empty or the file does not exist.
+func loadPendingWork(path string) (*pendingWork, error) {
+	pw := &pendingWork{}
+	if path == "" {
+		return pw, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return pw, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := gob.NewDecoder(f).Decode(pw); err != nil {
+		return nil, fmt.Errorf("loadPendingWork(%q): %v", path, err)
+	}
+	return pw, os.Remove(path)
+}
+
+// shutdown stops srv from accepting new requests and cs from running new jobs,
+// then waits up to d for in-flight requests, cron jobs and due tasks of tq to finish.
+// After that, handlers have shutdownGrace to hand over their remaining work.
+// Tasks and cron jobs which are still unfinished are saved to path,
+// to be resumed by the next start.
+func shutdown(srv *http.Server, tq *localTaskQueue, cs *cronScheduler, path string, d time.Duration) error {
+	c, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	cs.stop()
+	// schedule streams never finish on their own
+	streams.close()
+	if err := srv.Shutdown(c); err != nil {
+		errorf(c, "shutdown: in-flight requests: %v", err)
+	}
+	cs.wait(c)
+	tq.drain(c)
+
+	atomic.StoreInt32(&stopping, 1)
+	gc, gcancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer gcancel()
+	pw := &pendingWork{Tasks: tq.stop(gc), Cron: cs.wait(gc)}
+	if len(pw.Tasks) == 0 && len(pw.Cron) == 0 {
+		return nil
+	}
+	if path == "" {
+		return fmt.Errorf("shutdown: no dataFile; dropped %d tasks and %d cron jobs", len(pw.Tasks), len(pw.Cron))
+	}
+	logf(c, "shutdown: saving %d tasks and %d cron jobs to %s", len(pw.Tasks), len(pw.Cron), path)
+	return writeGobFile(path, pw)
+}
@@ -0,0 +1,115 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestShutdownPendingWork(t *testing.T) {
+	defer atomic.StoreInt32(&stopping, 0)
+	dir, err := ioutil.TempDir("", "ioweb-shutdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pendingFile := pendingWorkFile(filepath.Join(dir, "data"))
+
+	release := make(chan struct{})
+	defer close(release)
+	var tq *localTaskQueue
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/task/push":
+			// a long running task which hands over the rest of its work
+			for !isStopping() {
+				time.Sleep(time.Millisecond)
+			}
+			tq.add(context.Background(), &task{Path: "/task/rest", Method: "POST"}, "")
+		case "/task/rest", "/cron/stuck":
+			<-release
+		}
+	})
+	if tq, err = newLocalTaskQueue(nil, h); err != nil {
+		t.Fatal(err)
+	}
+	job := &cronJob{URL: "/cron/stuck", every: time.Hour}
+	cs := newCronScheduler([]*cronJob{job}, h)
+	go cs.run(job)
+
+	for _, p := range []string{"/task/quick", "/task/push"} {
+		tq.add(context.Background(), &task{Path: p, Method: "POST"}, "")
+	}
+	tq.add(context.Background(), &task{Path: "/task/later", Method: "POST", Delay: time.Hour}, "")
+	if err := shutdown(&http.Server{}, tq, cs, pendingFile, 50*time.Millisecond); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	pw, err := loadPendingWork(pendingFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(pendingFile); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%q): %v; want not exist", pendingFile, err)
+	}
+	var paths []string
+	for _, pt := range pw.Tasks {
+		if pt.Queue != defaultQueueName {
+			t.Errorf("%s: pt.Queue = %q; want %q", pt.Path, pt.Queue, defaultQueueName)
+		}
+		paths = append(paths, pt.Path)
+	}
+	sort.Strings(paths)
+	if len(paths) != 2 || paths[0] != "/task/later" || paths[1] != "/task/rest" {
+		t.Errorf("pending tasks = %v; want [/task/later /task/rest]", paths)
+	}
+	if len(pw.Cron) != 1 || pw.Cron[0] != "/cron/stuck" {
+		t.Errorf("pw.Cron = %v; want [/cron/stuck]", pw.Cron)
+	}
+
+	// resume on the next start
+	resumed := make(chan string, 10)
+	h2 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resumed <- r.URL.Path
+	})
+	tq2, err := newLocalTaskQueue(nil, h2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tq2.restore(pw.Tasks)
+	cs2 := newCronScheduler([]*cronJob{{URL: "/cron/stuck", every: time.Hour}}, h2)
+	cs2.restore(pw.Cron)
+	got := map[string]bool{}
+	for len(got) < 2 {
+		select {
+		case p := <-resumed:
+			got[p] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("resumed = %v; want /task/rest and /cron/stuck", got)
+		}
+	}
+	if !got["/task/rest"] || !got["/cron/stuck"] {
+		t.Errorf("resumed = %v; want /task/rest and /cron/stuck", got)
+	}
+}
@@ -25,8 +25,6 @@ import (
 	"strings"
 	"time"
 
-	"google.golang.org/appengine/memcache"
-
 	"golang.org/x/net/context"
 )
 
@@ -42,12 +40,16 @@ type socEntry struct {
 }
 
 // socialEntries always picks twitter entries from cache,
-// using shared memcache key.
+// using shared cache key.
 //
-// It returns nil if memcache call resulted in an error.
+// It returns nil if cache call resulted in an error.
 func socialEntries(c context.Context) []*socEntry {
 	var entries []*socEntry
-	if _, err := memcache.JSON.Get(c, cachedSocialKey, &entries); err != nil {
+	b, err := cache.get(c, cachedSocialKey)
+	if err == nil {
+		err = json.Unmarshal(b, &entries)
+	}
+	if err != nil {
 		errorf(c, "socialEntries(%q): %v", cachedSocialKey, err)
 		return nil
 	}
@@ -55,16 +57,18 @@ func socialEntries(c context.Context) []*socEntry {
 }
 
 // refreshSocialEntries fetches social entries from the network
-// and updates cached copy on all memcache shards.
+// and updates cached copy on all cache shards.
 func refreshSocialEntries(c context.Context) error {
+	cfg := config()
 	client := twitterClient(c)
 	ch := make(chan *tweetEntry, 100)
-	done := make(chan struct{}, len(config.Twitter.Accounts))
-	for _, a := range config.Twitter.Accounts {
+	done := make(chan struct{}, len(cfg.Twitter.Accounts))
+	for _, a := range cfg.Twitter.Accounts {
 		go func(a string) {
 			ent, err := fetchTweets(client, a)
 			if err != nil {
 				errorf(c, "%s: %v", a, err)
+				twitterErrorsTotal.inc()
 			}
 			for _, e := range ent {
 				ch <- e
@@ -92,7 +96,7 @@ loop:
 			entries = append(entries, se)
 		case <-done:
 			count++
-			if count == len(config.Twitter.Accounts) {
+			if count == len(cfg.Twitter.Accounts) {
 				// all goroutines have exited
 				// no more tweets will be sent over ch
 				close(done)
@@ -123,22 +127,28 @@ loop:
 		n = len(entries)
 	}
 	entries = entries[:n]
-	items := make([]*memcache.Item, len(allCachedSocialKeys))
-	for i, k := range allCachedSocialKeys {
-		items[i] = &memcache.Item{Key: k, Object: entries}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	for _, k := range allCachedSocialKeys {
+		if err := cache.set(c, k, b, 0); err != nil {
+			return err
+		}
 	}
-	return memcache.JSON.SetMulti(c, items)
+	return nil
 }
 
 // fetchTweets retrieves tweet entries of the given account using User Timeline Twitter API.
 // It returns the tweets that match config.Twitter.Filter.
 func fetchTweets(client *http.Client, account string) ([]*tweetEntry, error) {
+	cfg := config()
 	params := url.Values{
 		"screen_name": {account},
 		"count":       {"200"},
 		"include_rts": {"false"},
 	}
-	url := config.Twitter.TimelineURL + "?" + params.Encode()
+	url := cfg.Twitter.TimelineURL + "?" + params.Encode()
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
@@ -160,7 +170,7 @@ func fetchTweets(client *http.Client, account string) ([]*tweetEntry, error) {
 	}
 	res := make([]*tweetEntry, 0, len(tweets))
 	for _, t := range tweets {
-		if includesWord(t.Text, config.Twitter.Filter) {
+		if includesWord(t.Text, cfg.Twitter.Filter) {
 			res = append(res, t)
 		}
 	}
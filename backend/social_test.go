@@ -15,12 +15,11 @@
 package backend
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
-
-	"google.golang.org/appengine/memcache"
 )
 
 func TestRefreshSocialEntries(t *testing.T) {
@@ -39,9 +38,9 @@ func TestRefreshSocialEntries(t *testing.T) {
 		}]`, id, text, name)))
 	}))
 	defer ts.Close()
-	config.Twitter.TimelineURL = ts.URL + "/"
-	config.Twitter.Accounts = []string{"a1", "a2"}
-	config.Twitter.Filter = "#io16"
+	config().Twitter.TimelineURL = ts.URL + "/"
+	config().Twitter.Accounts = []string{"a1", "a2"}
+	config().Twitter.Filter = "#io16"
 
 	r := newTestRequest(t, "GET", "/", nil)
 	ctx := newContext(r)
@@ -50,7 +49,11 @@ func TestRefreshSocialEntries(t *testing.T) {
 	}
 	for _, k := range allCachedSocialKeys {
 		var entries []*socEntry
-		if _, err := memcache.JSON.Get(ctx, k, &entries); err != nil {
+		b, err := cache.get(ctx, k)
+		if err == nil {
+			err = json.Unmarshal(b, &entries)
+		}
+		if err != nil {
 			t.Errorf("%s: %v", k, err)
 			continue
 		}
@@ -0,0 +1,911 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// blobPartSize is the max size of a []byte value stored in a single datastore entity
+// or memcache item, leaving room for other fields and keys within their 1Mb limit.
+const blobPartSize = 1000 << 10
+
+// maxBatchKeys is the max number of keys in a single datastore batch operation.
+const maxBatchKeys = 500
+
+// store is the instance used by the program,
+// initialized by the standalone server's main() or server_gae.
+var store eventStore
+
+// eventStore unifies different types of persistent storage,
+// e.g. fileStore and appengine/datastore.
+// It keeps versioned event data, the change log and "already notified" markers.
+type eventStore interface {
+	// runInTransaction runs f in a transaction.
+	// It calls f with a transaction context tc that f should use for all operations.
+	runInTransaction(c context.Context, f func(tc context.Context) error) error
+	// putEventData saves a new version of event data ent and sets its Etag.
+	putEventData(c context.Context, ent *eventDataCache) error
+	// latestEventData returns most recent version of event data
+	// by its Timestamp, or errNotFound if nothing has been stored yet.
+	latestEventData(c context.Context) (*eventDataCache, error)
+	// eventDataVersions returns at most limit versions of event data,
+	// most recent first by their Timestamp. Bytes of the returned values are not loaded.
+	eventDataVersions(c context.Context, limit int) ([]*eventDataCache, error)
+	// eventDataVersion returns the version of event data identified by etag,
+	// or errNotFound if there's no such version.
+	eventDataVersion(c context.Context, etag string) (*eventDataCache, error)
+	// eventDataTime returns Timestamp of the version of event data identified by etag
+	// without loading the data itself, or errNotFound if there's no such version.
+	eventDataTime(c context.Context, etag string) (time.Time, error)
+	// firstEventDataTime returns Timestamp of the oldest version of event data,
+	// or errNotFound if nothing has been stored yet.
+	firstEventDataTime(c context.Context) (time.Time, error)
+	// clearEventData deletes all versions of event data.
+	clearEventData(c context.Context) error
+	// putChanges appends ent to the change log.
+	putChanges(c context.Context, ent *changesEntity) error
+	// changesSince returns at most limit change log entries with Timestamp after t,
+	// in ascending Timestamp order.
+	changesSince(c context.Context, t time.Time, limit int) ([]*changesEntity, error)
+	// putNext saves "already notified" markers identified by keys.
+	putNext(c context.Context, keys []string) error
+	// hasNext reports whether each of the keys has been saved with putNext.
+	hasNext(c context.Context, keys []string) ([]bool, error)
+	// putReport replaces the event data validation report with ent.
+	putReport(c context.Context, ent *reportEntity) error
+	// latestReport returns the report saved with putReport,
+	// or errNotFound if nothing has been stored yet.
+	latestReport(c context.Context) (*reportEntity, error)
+	// putSequences replaces the session sequences with ent.
+	putSequences(c context.Context, ent *sequencesEntity) error
+	// latestSequences returns the session sequences saved with putSequences,
+	// or errNotFound if nothing has been stored yet.
+	latestSequences(c context.Context) (*sequencesEntity, error)
+}
+
+// changesEntity is a single item of the change log.
+type changesEntity struct {
+	Timestamp time.Time `datastore:"ts"`
+	Bytes     []byte    `datastore:"data"`
+	// Parts and Sum are set when Bytes don't fit into a single entity; see putBlob.
+	Parts int    `datastore:"parts,noindex"`
+	Sum   string `datastore:"sum,noindex"`
+}
+
+// reportEntity is the validation report of the last synced event data.
+type reportEntity struct {
+	Timestamp time.Time `datastore:"ts"`
+	Bytes     []byte    `datastore:"data"`
+}
+
+// sequencesEntity is the number of details updates of each session in the change log,
+// encoded in JSON.
+type sequencesEntity struct {
+	Timestamp time.Time `datastore:"ts"` // last counted change log entry
+	Bytes     []byte    `datastore:"data"`
+}
+
+// eventStore implementation using appengine/datastore.
+type gaeDatastore struct{}
+
+func (s *gaeDatastore) runInTransaction(c context.Context, f func(context.Context) error) error {
+	opts := &datastore.TransactionOptions{XG: true}
+	return datastore.RunInTransaction(c, f, opts)
+}
+
+func (s *gaeDatastore) putEventData(c context.Context, ent *eventDataCache) error {
+	key, err := newBlobKey(c, kindEventData, eventDataParent(c), ent.Bytes)
+	if err != nil {
+		return err
+	}
+	e := *ent
+	if e.Bytes, e.Parts, e.Sum, err = putBlob(c, key, ent.Bytes); err != nil {
+		return err
+	}
+	if key, err = datastore.Put(c, key, &e); err != nil {
+		return err
+	}
+	ent.Etag = hexKey(key)
+	return nil
+}
+
+func (s *gaeDatastore) latestEventData(c context.Context) (*eventDataCache, error) {
+	q := datastore.NewQuery(kindEventData).
+		Ancestor(eventDataParent(c)).
+		Order("-ts").
+		Limit(1)
+	var res []*eventDataCache
+	keys, err := q.GetAll(c, &res)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, errNotFound
+	}
+	ent := res[0]
+	if ent.Bytes, err = getBlob(c, keys[0], ent.Bytes, ent.Parts, ent.Sum); err != nil {
+		return nil, err
+	}
+	ent.Parts, ent.Sum = 0, ""
+	ent.Etag = hexKey(keys[0])
+	return ent, nil
+}
+
+func (s *gaeDatastore) eventDataVersions(c context.Context, limit int) ([]*eventDataCache, error) {
+	q := datastore.NewQuery(kindEventData).
+		Ancestor(eventDataParent(c)).
+		Order("-ts").
+		Limit(limit)
+	var res []*eventDataCache
+	keys, err := q.GetAll(c, &res)
+	if err != nil {
+		return nil, err
+	}
+	// blob parts are skipped; item counts are enough to list versions
+	for i, ent := range res {
+		ent.Bytes, ent.Parts, ent.Sum = nil, 0, ""
+		ent.Etag = hexKey(keys[i])
+	}
+	return res, nil
+}
+
+// eventDataVersion looks up the key of etag among all kindEventData keys,
+// since etags can't be converted back to keys.
+func (s *gaeDatastore) eventDataVersion(c context.Context, etag string) (*eventDataCache, error) {
+	q := datastore.NewQuery(kindEventData).
+		Ancestor(eventDataParent(c)).
+		KeysOnly()
+	keys, err := q.GetAll(c, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if hexKey(k) != etag {
+			continue
+		}
+		ent := &eventDataCache{}
+		if err := datastore.Get(c, k, ent); err != nil {
+			return nil, err
+		}
+		if ent.Bytes, err = getBlob(c, k, ent.Bytes, ent.Parts, ent.Sum); err != nil {
+			return nil, err
+		}
+		ent.Parts, ent.Sum = 0, ""
+		ent.Etag = etag
+		return ent, nil
+	}
+	return nil, errNotFound
+}
+
+// eventDataTime looks up the key of etag the same way eventDataVersion does
+// but uses a projection query, so that event data itself is not loaded.
+func (s *gaeDatastore) eventDataTime(c context.Context, etag string) (time.Time, error) {
+	q := datastore.NewQuery(kindEventData).
+		Ancestor(eventDataParent(c)).
+		Project("ts")
+	var res []*eventDataCache
+	keys, err := q.GetAll(c, &res)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for i, k := range keys {
+		if hexKey(k) == etag {
+			return res[i].Timestamp, nil
+		}
+	}
+	return time.Time{}, errNotFound
+}
+
+// firstEventDataTime uses a projection query, so that event data itself is not loaded.
+func (s *gaeDatastore) firstEventDataTime(c context.Context) (time.Time, error) {
+	q := datastore.NewQuery(kindEventData).
+		Ancestor(eventDataParent(c)).
+		Project("ts").
+		Order("ts").
+		Limit(1)
+	var res []*eventDataCache
+	if _, err := q.GetAll(c, &res); err != nil {
+		return time.Time{}, err
+	}
+	if len(res) == 0 {
+		return time.Time{}, errNotFound
+	}
+	return res[0].Timestamp, nil
+}
+
+// clearEventData deletes all kindEventData entities along with their blob parts.
+func (s *gaeDatastore) clearEventData(c context.Context) error {
+	q := datastore.NewQuery("").
+		Ancestor(eventDataParent(c)).
+		KeysOnly()
+	keys, err := q.GetAll(c, nil)
+	if err != nil {
+		return err
+	}
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > maxBatchKeys {
+			n = maxBatchKeys
+		}
+		if err := datastore.DeleteMulti(c, keys[:n]); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+func (s *gaeDatastore) putChanges(c context.Context, ent *changesEntity) error {
+	key, err := newBlobKey(c, kindChanges, changesParent(c), ent.Bytes)
+	if err != nil {
+		return err
+	}
+	e := *ent
+	if e.Bytes, e.Parts, e.Sum, err = putBlob(c, key, ent.Bytes); err != nil {
+		return err
+	}
+	_, err = datastore.Put(c, key, &e)
+	return err
+}
+
+func (s *gaeDatastore) changesSince(c context.Context, t time.Time, limit int) ([]*changesEntity, error) {
+	q := datastore.NewQuery(kindChanges).
+		Ancestor(changesParent(c)).
+		Filter("ts > ", t).
+		Order("ts").
+		Limit(limit)
+	var res []*changesEntity
+	keys, err := q.GetAll(c, &res)
+	if err != nil {
+		return nil, err
+	}
+	for i, ent := range res {
+		if ent.Bytes, err = getBlob(c, keys[i], ent.Bytes, ent.Parts, ent.Sum); err != nil {
+			return nil, err
+		}
+		ent.Parts, ent.Sum = 0, ""
+	}
+	return res, nil
+}
+
+func (s *gaeDatastore) putNext(c context.Context, keys []string) error {
+	zeros := make([]struct{}, len(keys))
+	_, err := datastore.PutMulti(c, s.nextKeys(c, keys), zeros)
+	return err
+}
+
+func (s *gaeDatastore) hasNext(c context.Context, keys []string) ([]bool, error) {
+	res := make([]bool, len(keys))
+	zeros := make([]struct{}, len(keys))
+	err := datastore.GetMulti(c, s.nextKeys(c, keys), zeros)
+	if err == nil {
+		for i := range res {
+			res[i] = true
+		}
+		return res, nil
+	}
+	merr, ok := err.(appengine.MultiError)
+	if !ok {
+		return nil, err
+	}
+	for i, e := range merr {
+		if e != nil && e != datastore.ErrNoSuchEntity {
+			return nil, e
+		}
+		res[i] = e == nil
+	}
+	return res, nil
+}
+
+func (s *gaeDatastore) putReport(c context.Context, ent *reportEntity) error {
+	_, err := datastore.Put(c, reportKey(c), ent)
+	return err
+}
+
+func (s *gaeDatastore) latestReport(c context.Context) (*reportEntity, error) {
+	ent := &reportEntity{}
+	err := datastore.Get(c, reportKey(c), ent)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, errNotFound
+	}
+	return ent, err
+}
+
+func (s *gaeDatastore) putSequences(c context.Context, ent *sequencesEntity) error {
+	_, err := datastore.Put(c, sequencesKey(c), ent)
+	return err
+}
+
+func (s *gaeDatastore) latestSequences(c context.Context) (*sequencesEntity, error) {
+	ent := &sequencesEntity{}
+	err := datastore.Get(c, sequencesKey(c), ent)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, errNotFound
+	}
+	return ent, err
+}
+
+// nextKeys converts string IDs into kindNext datastore keys.
+func (s *gaeDatastore) nextKeys(c context.Context, ids []string) []*datastore.Key {
+	pkey := nextSessionParent(c)
+	keys := make([]*datastore.Key, len(ids))
+	for i, id := range ids {
+		keys[i] = datastore.NewKey(c, kindNext, id, 0, pkey)
+	}
+	return keys
+}
+
+// blobPart is a part of a large []byte field of an entity,
+// stored as kindBlobPart child entity of the entity. See putBlob.
+type blobPart struct {
+	Bytes []byte `datastore:"data"`
+}
+
+// newBlobKey returns a new key of kind with the parent to store an entity with blob b.
+// The key is incomplete unless b needs to be split with putBlob,
+// in which case the key is allocated beforehand so that it can be the parts ancestor.
+func newBlobKey(c context.Context, kind string, parent *datastore.Key, b []byte) (*datastore.Key, error) {
+	if len(b) <= blobPartSize {
+		return datastore.NewIncompleteKey(c, kind, parent), nil
+	}
+	id, _, err := datastore.AllocateIDs(c, kind, parent, 1)
+	if err != nil {
+		return nil, err
+	}
+	return datastore.NewKey(c, kind, "", id, parent), nil
+}
+
+// putBlob splits b into kindBlobPart child entities of key if b is too large
+// to fit into the entity of key along with its other fields.
+// It returns the field values of the entity: b itself, the number of parts and the checksum.
+// If b is small enough, it is returned as is, with zero parts.
+//
+// The parts must be written before the entity of key, so that readers never see
+// the entity without its parts. The parts of a key are never modified once written.
+func putBlob(c context.Context, key *datastore.Key, b []byte) ([]byte, int, string, error) {
+	if len(b) <= blobPartSize {
+		return b, 0, "", nil
+	}
+	var keys []*datastore.Key
+	var parts []*blobPart
+	for i := 0; i < len(b); i += blobPartSize {
+		end := i + blobPartSize
+		if end > len(b) {
+			end = len(b)
+		}
+		keys = append(keys, datastore.NewKey(c, kindBlobPart, "", int64(len(keys)+1), key))
+		parts = append(parts, &blobPart{Bytes: b[i:end]})
+	}
+	if _, err := datastore.PutMulti(c, keys, parts); err != nil {
+		return nil, 0, "", err
+	}
+	return nil, len(parts), blobSum(b), nil
+}
+
+// getBlob returns a []byte field of the entity of key, stored with putBlob.
+// inline, n and sum are the values returned by putBlob.
+func getBlob(c context.Context, key *datastore.Key, inline []byte, n int, sum string) ([]byte, error) {
+	if n == 0 {
+		return inline, nil
+	}
+	keys := make([]*datastore.Key, n)
+	for i := range keys {
+		keys[i] = datastore.NewKey(c, kindBlobPart, "", int64(i+1), key)
+	}
+	parts := make([]blobPart, n)
+	if err := datastore.GetMulti(c, keys, parts); err != nil {
+		return nil, fmt.Errorf("getBlob(%s): %v", key, err)
+	}
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p.Bytes...)
+	}
+	if blobSum(b) != sum {
+		return nil, fmt.Errorf("getBlob(%s): checksum mismatch", key)
+	}
+	return b, nil
+}
+
+// eventDataParent returns a common ancestor for all kindEventData entities.
+func eventDataParent(c context.Context) *datastore.Key {
+	return datastore.NewKey(c, kindEventData, "root", 0, nil)
+}
+
+// changesParent returns a common ancestor for all kindChanges entities.
+func changesParent(c context.Context) *datastore.Key {
+	return datastore.NewKey(c, kindChanges, "root", 0, nil)
+}
+
+// nextSessionParent returns a common ancestor for all kindNext session entities.
+func nextSessionParent(c context.Context) *datastore.Key {
+	return datastore.NewKey(c, kindNext, "session", 0, nil)
+}
+
+// reportKey returns the key of the only kindReport entity.
+func reportKey(c context.Context) *datastore.Key {
+	return datastore.NewKey(c, kindReport, "latest", 0, nil)
+}
+
+// sequencesKey returns the key of the only kindSequences entity.
+func sequencesKey(c context.Context) *datastore.Key {
+	return datastore.NewKey(c, kindSequences, "latest", 0, nil)
+}
+
+// hexKey returns a representation of a key k in base 16.
+// Useful for etags.
+func hexKey(k *datastore.Key) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(k.String())))
+}
+
+// fileStoreTxKey is a context key which marks a fileStore transaction context.
+type fileStoreTxKey struct{}
+
+// fileStore is an eventStore implementation which keeps all data in memory
+// and saves it to disk after each write or committed transaction.
+// Data is kept in memory only if path is empty.
+//
+// The file at path is an index of all items w/o their Bytes.
+// Bytes of each event data version and change log entry are saved once,
+// to a separate file in path.d directory, so that the cost of a write
+// doesn't grow with the history. See fileStore.save.
+//
+// It is safe for concurrent use.
+type fileStore struct {
+	path string
+	txmu sync.Mutex // serializes transactions
+	mu   sync.Mutex // guards data, savedData and savedChanges
+	data fileStoreData
+
+	savedData    map[int64]bool // IDs of event data with Bytes saved to disk
+	savedChanges int            // number of leading data.Changes with Bytes saved to disk
+}
+
+// fileStoreData is the fileStore content; its index is saved to disk in gob format.
+type fileStoreData struct {
+	Seq       int64 // last used event data ID
+	EventData []*fileStoreEventData
+	Changes   []*changesEntity
+	Next      map[string]bool
+	Report    *reportEntity
+	Sequences *sequencesEntity
+}
+
+type fileStoreEventData struct {
+	ID        int64
+	Timestamp time.Time
+	Bytes     []byte
+	Sessions  int
+	Speakers  int
+	Videos    int
+	Tags      int
+}
+
+// entity converts d to the eventStore representation.
+func (d *fileStoreEventData) entity() *eventDataCache {
+	return &eventDataCache{
+		Etag:      fileStoreEtag(d.ID),
+		Timestamp: d.Timestamp,
+		Bytes:     d.Bytes,
+		Sessions:  d.Sessions,
+		Speakers:  d.Speakers,
+		Videos:    d.Videos,
+		Tags:      d.Tags,
+	}
+}
+
+// newFileStore creates a new fileStore and loads its data from path, if the file exists.
+// Items of a snapshot written in a single file, with their Bytes inline,
+// are moved to separate files on the next save.
+func newFileStore(path string) (*fileStore, error) {
+	s := &fileStore{path: path, savedData: make(map[int64]bool)}
+	s.data.Next = make(map[string]bool)
+	if path == "" {
+		return s, nil
+	}
+	perr := prefixedErr(fmt.Sprintf("newFileStore(%q)", path))
+	if err := readGobFile(path, &s.data); os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, perr(err)
+	}
+	if s.data.Next == nil {
+		s.data.Next = make(map[string]bool)
+	}
+	for _, d := range s.data.EventData {
+		if d.Bytes != nil {
+			continue
+		}
+		if err := readGobFile(s.eventDataPath(d.ID), &d.Bytes); err != nil {
+			return nil, perr(err)
+		}
+		s.savedData[d.ID] = true
+	}
+	for i, ent := range s.data.Changes {
+		if ent.Bytes != nil {
+			continue
+		}
+		if err := readGobFile(s.changesPath(i), &ent.Bytes); err != nil {
+			return nil, perr(err)
+		}
+		s.savedChanges = i + 1
+	}
+	return s, nil
+}
+
+// runInTransaction serializes transactions and restores all data
+// to the state prior to calling f if it returns an error.
+// Nested calls run f in the outer transaction.
+func (s *fileStore) runInTransaction(c context.Context, f func(context.Context) error) error {
+	if c.Value(fileStoreTxKey{}) != nil {
+		return f(c)
+	}
+	s.txmu.Lock()
+	defer s.txmu.Unlock()
+
+	s.mu.Lock()
+	orig := s.data.clone()
+	s.mu.Unlock()
+
+	if err := f(context.WithValue(c, fileStoreTxKey{}, true)); err != nil {
+		s.mu.Lock()
+		s.data = orig
+		s.mu.Unlock()
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save()
+}
+
+func (s *fileStore) putEventData(c context.Context, ent *eventDataCache) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Seq++
+	s.data.EventData = append(s.data.EventData, &fileStoreEventData{
+		ID:        s.data.Seq,
+		Timestamp: ent.Timestamp,
+		Bytes:     ent.Bytes,
+		Sessions:  ent.Sessions,
+		Speakers:  ent.Speakers,
+		Videos:    ent.Videos,
+		Tags:      ent.Tags,
+	})
+	ent.Etag = fileStoreEtag(s.data.Seq)
+	return s.commit(c)
+}
+
+func (s *fileStore) latestEventData(c context.Context) (*eventDataCache, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res *fileStoreEventData
+	for _, d := range s.data.EventData {
+		if res == nil || !d.Timestamp.Before(res.Timestamp) {
+			res = d
+		}
+	}
+	if res == nil {
+		return nil, errNotFound
+	}
+	return res.entity(), nil
+}
+
+func (s *fileStore) eventDataVersions(c context.Context, limit int) ([]*eventDataCache, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := append(latestEventDataList(nil), s.data.EventData...)
+	sort.Sort(list)
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	res := make([]*eventDataCache, len(list))
+	for i, d := range list {
+		res[i] = d.entity()
+		res[i].Bytes = nil
+	}
+	return res, nil
+}
+
+func (s *fileStore) eventDataVersion(c context.Context, etag string) (*eventDataCache, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range s.data.EventData {
+		if fileStoreEtag(d.ID) == etag {
+			return d.entity(), nil
+		}
+	}
+	return nil, errNotFound
+}
+
+func (s *fileStore) eventDataTime(c context.Context, etag string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range s.data.EventData {
+		if fileStoreEtag(d.ID) == etag {
+			return d.Timestamp, nil
+		}
+	}
+	return time.Time{}, errNotFound
+}
+
+func (s *fileStore) firstEventDataTime(c context.Context) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.data.EventData) == 0 {
+		return time.Time{}, errNotFound
+	}
+	t := s.data.EventData[0].Timestamp
+	for _, d := range s.data.EventData[1:] {
+		if d.Timestamp.Before(t) {
+			t = d.Timestamp
+		}
+	}
+	return t, nil
+}
+
+func (s *fileStore) clearEventData(c context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.EventData = nil
+	return s.commit(c)
+}
+
+func (s *fileStore) putChanges(c context.Context, ent *changesEntity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Changes = append(s.data.Changes, ent)
+	return s.commit(c)
+}
+
+func (s *fileStore) changesSince(c context.Context, t time.Time, limit int) ([]*changesEntity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []*changesEntity
+	for _, ent := range s.data.Changes {
+		if ent.Timestamp.After(t) {
+			res = append(res, ent)
+		}
+	}
+	sort.Stable(sortedChangesList(res))
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+func (s *fileStore) putNext(c context.Context, keys []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range keys {
+		s.data.Next[k] = true
+	}
+	return s.commit(c)
+}
+
+func (s *fileStore) hasNext(c context.Context, keys []string) ([]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]bool, len(keys))
+	for i, k := range keys {
+		res[i] = s.data.Next[k]
+	}
+	return res, nil
+}
+
+func (s *fileStore) putReport(c context.Context, ent *reportEntity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Report = ent
+	return s.commit(c)
+}
+
+func (s *fileStore) latestReport(c context.Context) (*reportEntity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Report == nil {
+		return nil, errNotFound
+	}
+	return s.data.Report, nil
+}
+
+func (s *fileStore) putSequences(c context.Context, ent *sequencesEntity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Sequences = ent
+	return s.commit(c)
+}
+
+func (s *fileStore) latestSequences(c context.Context) (*sequencesEntity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Sequences == nil {
+		return nil, errNotFound
+	}
+	return s.data.Sequences, nil
+}
+
+// commit saves data to disk unless c is a transaction context,
+// in which case data is saved when the transaction completes.
+// The caller must hold s.mu lock.
+func (s *fileStore) commit(c context.Context) error {
+	if c.Value(fileStoreTxKey{}) != nil {
+		return nil
+	}
+	return s.save()
+}
+
+// save writes Bytes of event data and changes which haven't been saved yet
+// to their own files, removes files of deleted event data and replaces the index at s.path.
+// All files are replaced atomically so that a crash won't leave them half-written.
+// The caller must hold s.mu lock.
+func (s *fileStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(s.path+".d", 0755); err != nil {
+		return err
+	}
+	index := s.data
+	index.EventData = make([]*fileStoreEventData, len(s.data.EventData))
+	ids := make(map[int64]bool, len(s.data.EventData))
+	for i, d := range s.data.EventData {
+		if !s.savedData[d.ID] {
+			if err := writeGobFile(s.eventDataPath(d.ID), d.Bytes); err != nil {
+				return err
+			}
+			s.savedData[d.ID] = true
+		}
+		ids[d.ID] = true
+		x := *d
+		x.Bytes = nil
+		index.EventData[i] = &x
+	}
+	for ; s.savedChanges < len(s.data.Changes); s.savedChanges++ {
+		if err := writeGobFile(s.changesPath(s.savedChanges), s.data.Changes[s.savedChanges].Bytes); err != nil {
+			return err
+		}
+	}
+	index.Changes = make([]*changesEntity, len(s.data.Changes))
+	for i, ent := range s.data.Changes {
+		x := *ent
+		x.Bytes = nil
+		index.Changes[i] = &x
+	}
+	if err := writeGobFile(s.path, &index); err != nil {
+		return err
+	}
+	// the index no longer refers to deleted event data
+	for id := range s.savedData {
+		if ids[id] {
+			continue
+		}
+		if err := os.Remove(s.eventDataPath(id)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		delete(s.savedData, id)
+	}
+	return nil
+}
+
+// eventDataPath returns the file path of Bytes of event data identified by id.
+func (s *fileStore) eventDataPath(id int64) string {
+	return filepath.Join(s.path+".d", fmt.Sprintf("eventdata-%d", id))
+}
+
+// changesPath returns the file path of Bytes of i-th change log entry.
+func (s *fileStore) changesPath(i int) string {
+	return filepath.Join(s.path+".d", fmt.Sprintf("changes-%d", i))
+}
+
+// readGobFile decodes a file at path written with writeGobFile into v.
+func readGobFile(path string, v interface{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return gob.NewDecoder(f).Decode(v)
+}
+
+// writeGobFile writes v to a file at path in gob format.
+// The file is replaced atomically so that a crash won't leave it half-written.
+func writeGobFile(path string, v interface{}) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(v); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// clone returns a copy of d which doesn't share slices or maps with the original.
+// Stored items are immutable and are not copied.
+func (d fileStoreData) clone() fileStoreData {
+	res := d
+	res.EventData = append([]*fileStoreEventData(nil), d.EventData...)
+	res.Changes = append([]*changesEntity(nil), d.Changes...)
+	res.Next = make(map[string]bool, len(d.Next))
+	for k, v := range d.Next {
+		res.Next[k] = v
+	}
+	return res
+}
+
+// blobSum returns the checksum of a blob split into parts.
+func blobSum(b []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(b))
+}
+
+// fileStoreEtag returns an etag of the event data identified by id.
+func fileStoreEtag(id int64) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(fmt.Sprintf("%s:%d", kindEventData, id))))
+}
+
+// sortedChangesList implements sort.Sort ordering items by Timestamp.
+type sortedChangesList []*changesEntity
+
+func (l sortedChangesList) Len() int {
+	return len(l)
+}
+
+func (l sortedChangesList) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+}
+
+func (l sortedChangesList) Less(i, j int) bool {
+	return l[i].Timestamp.Before(l[j].Timestamp)
+}
+
+// latestEventDataList implements sort.Sort ordering items by:
+//   - Timestamp, most recent first
+//   - ID, most recently added first, same as fileStore.latestEventData does
+type latestEventDataList []*fileStoreEventData
+
+func (l latestEventDataList) Len() int {
+	return len(l)
+}
+
+func (l latestEventDataList) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+}
+
+func (l latestEventDataList) Less(i, j int) bool {
+	if !l[i].Timestamp.Equal(l[j].Timestamp) {
+		return l[i].Timestamp.After(l[j].Timestamp)
+	}
+	return l[i].ID > l[j].ID
+}
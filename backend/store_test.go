@@ -0,0 +1,299 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestFileStoreEventData(t *testing.T) {
+	t.Parallel()
+	c := context.Background()
+	s, _ := newFileStore("")
+	if _, err := s.latestEventData(c); err != errNotFound {
+		t.Errorf("latestEventData() err = %v; want errNotFound", err)
+	}
+	if _, err := s.firstEventDataTime(c); err != errNotFound {
+		t.Errorf("firstEventDataTime() err = %v; want errNotFound", err)
+	}
+
+	now := time.Now()
+	one := &eventDataCache{Timestamp: now, Bytes: []byte("one"), Sessions: 1, Tags: 2}
+	two := &eventDataCache{Timestamp: now.Add(-time.Hour), Bytes: []byte("two")}
+	for _, ent := range []*eventDataCache{one, two} {
+		if err := s.putEventData(c, ent); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if one.Etag == "" || one.Etag == two.Etag {
+		t.Errorf("one.Etag = %q, two.Etag = %q; want distinct non-empty values", one.Etag, two.Etag)
+	}
+
+	res, err := s.latestEventData(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res, one) {
+		t.Errorf("latestEventData() = %+v; want %+v", res, one)
+	}
+	if ts, err := s.firstEventDataTime(c); err != nil || !ts.Equal(two.Timestamp) {
+		t.Errorf("firstEventDataTime() = %s, %v; want %s", ts, err, two.Timestamp)
+	}
+
+	// a copy of two made current again
+	three := &eventDataCache{Timestamp: now, Bytes: []byte("two")}
+	if err := s.putEventData(c, three); err != nil {
+		t.Fatal(err)
+	}
+	list, err := s.eventDataVersions(c, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Etag != three.Etag || list[1].Etag != one.Etag {
+		t.Errorf("eventDataVersions(2) = %+v; want three and one", list)
+	}
+	if v := list[len(list)-1]; v.Bytes != nil || v.Sessions != 1 || v.Tags != 2 {
+		t.Errorf("eventDataVersions(2) last = %+v; want counts of one w/o bytes", v)
+	}
+	if res, err := s.eventDataVersion(c, two.Etag); err != nil || !reflect.DeepEqual(res, two) {
+		t.Errorf("eventDataVersion(two) = %+v, %v; want %+v", res, err, two)
+	}
+	if _, err := s.eventDataVersion(c, "missing"); err != errNotFound {
+		t.Errorf("eventDataVersion(missing) err = %v; want errNotFound", err)
+	}
+	if ts, err := s.eventDataTime(c, two.Etag); err != nil || !ts.Equal(two.Timestamp) {
+		t.Errorf("eventDataTime(two) = %s, %v; want %s", ts, err, two.Timestamp)
+	}
+	if _, err := s.eventDataTime(c, "missing"); err != errNotFound {
+		t.Errorf("eventDataTime(missing) err = %v; want errNotFound", err)
+	}
+
+	if err := s.clearEventData(c); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.latestEventData(c); err != errNotFound {
+		t.Errorf("latestEventData() err = %v; want errNotFound", err)
+	}
+}
+
+func TestFileStoreChangesSince(t *testing.T) {
+	t.Parallel()
+	c := context.Background()
+	s, _ := newFileStore("")
+	now := time.Now()
+	for _, d := range []time.Duration{2, 0, 1, 3} {
+		ent := &changesEntity{Timestamp: now.Add(d * time.Hour)}
+		if err := s.putChanges(c, ent); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := s.changesSince(c, now, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 {
+		t.Fatalf("len(res) = %d; want 2", len(res))
+	}
+	for i, d := range []time.Duration{1, 2} {
+		if ts := now.Add(d * time.Hour); !res[i].Timestamp.Equal(ts) {
+			t.Errorf("res[%d].Timestamp = %s; want %s", i, res[i].Timestamp, ts)
+		}
+	}
+}
+
+func TestFileStoreNext(t *testing.T) {
+	t.Parallel()
+	c := context.Background()
+	s, _ := newFileStore("")
+	if err := s.putNext(c, []string{"one:start", "two:start"}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.hasNext(c, []string{"one:start", "one:soon", "two:start"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []bool{true, false, true}; !reflect.DeepEqual(res, want) {
+		t.Errorf("hasNext() = %v; want %v", res, want)
+	}
+}
+
+func TestFileStoreTransaction(t *testing.T) {
+	t.Parallel()
+	c := context.Background()
+	s, _ := newFileStore("")
+	err := s.runInTransaction(c, func(tc context.Context) error {
+		s.putNext(tc, []string{"rollback"})
+		// nested transactions run in the outer one
+		return s.runInTransaction(tc, func(tc context.Context) error {
+			s.putEventData(tc, &eventDataCache{Timestamp: time.Now()})
+			return errors.New("fail")
+		})
+	})
+	if err == nil {
+		t.Errorf("runInTransaction: want error")
+	}
+	if _, err := s.latestEventData(c); err != errNotFound {
+		t.Errorf("latestEventData() err = %v; want errNotFound", err)
+	}
+	if res, _ := s.hasNext(c, []string{"rollback"}); res[0] {
+		t.Errorf("hasNext(rollback) = true; want false")
+	}
+
+	err = s.runInTransaction(c, func(tc context.Context) error {
+		return s.putNext(tc, []string{"commit"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := s.hasNext(c, []string{"commit"}); !res[0] {
+		t.Errorf("hasNext(commit) = false; want true")
+	}
+}
+
+func TestFileStorePersist(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "data")
+
+	c := context.Background()
+	s, err := newFileStore(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ent := &eventDataCache{Timestamp: time.Now(), Bytes: []byte("data")}
+	if err := s.putEventData(c, ent); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.putNext(c, []string{"next"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.putReport(c, &reportEntity{Timestamp: time.Now(), Bytes: []byte("report")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.putSequences(c, &sequencesEntity{Timestamp: time.Now(), Bytes: []byte("seq")}); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = newFileStore(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.latestEventData(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Etag != ent.Etag || string(res.Bytes) != "data" {
+		t.Errorf("latestEventData() = %+v; want %+v", res, ent)
+	}
+	if found, _ := s.hasNext(c, []string{"next"}); !found[0] {
+		t.Errorf("hasNext(next) = false; want true")
+	}
+	if rep, err := s.latestReport(c); err != nil || string(rep.Bytes) != "report" {
+		t.Errorf("latestReport() = %+v, %v; want report", rep, err)
+	}
+	if seq, err := s.latestSequences(c); err != nil || string(seq.Bytes) != "seq" {
+		t.Errorf("latestSequences() = %+v, %v; want seq", seq, err)
+	}
+
+	// item bytes are kept out of the index
+	if err := s.putChanges(c, &changesEntity{Timestamp: time.Now(), Bytes: []byte("changes")}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"eventdata-1", "changes-0"} {
+		if _, err := os.Stat(filepath.Join(p+".d", name)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	var index fileStoreData
+	if err := readGobFile(p, &index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.EventData) != 1 || index.EventData[0].Bytes != nil || len(index.Changes) != 1 || index.Changes[0].Bytes != nil {
+		t.Errorf("index = %+v; want items w/o bytes", index)
+	}
+	if err := s.clearEventData(c); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(p+".d", "eventdata-1")); !os.IsNotExist(err) {
+		t.Errorf("eventdata-1 after clearEventData: %v; want not exist", err)
+	}
+
+	s, err = newFileStore(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.latestEventData(c); err != errNotFound {
+		t.Errorf("latestEventData() err = %v; want errNotFound", err)
+	}
+	list, err := s.changesSince(c, time.Time{}, 10)
+	if err != nil || len(list) != 1 || string(list[0].Bytes) != "changes" {
+		t.Errorf("changesSince() = %+v, %v; want changes", list, err)
+	}
+}
+
+func TestFileStoreSingleFile(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "data")
+	// a snapshot with inline bytes, as saved before items were split into files
+	old := &fileStoreData{
+		Seq:       1,
+		EventData: []*fileStoreEventData{{ID: 1, Timestamp: time.Now(), Bytes: []byte("data")}},
+		Changes:   []*changesEntity{{Timestamp: time.Now(), Bytes: []byte("changes")}},
+	}
+	if err := writeGobFile(p, old); err != nil {
+		t.Fatal(err)
+	}
+
+	c := context.Background()
+	s, err := newFileStore(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.putNext(c, []string{"next"}); err != nil {
+		t.Fatal(err)
+	}
+	s, err = newFileStore(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := s.latestEventData(c); err != nil || string(res.Bytes) != "data" {
+		t.Errorf("latestEventData() = %+v, %v; want data", res, err)
+	}
+	list, err := s.changesSince(c, time.Time{}, 10)
+	if err != nil || len(list) != 1 || string(list[0].Bytes) != "changes" {
+		t.Errorf("changesSince() = %+v, %v; want changes", list, err)
+	}
+	if _, err := os.Stat(filepath.Join(p+".d", "eventdata-1")); err != nil {
+		t.Errorf("eventdata-1: %v", err)
+	}
+}
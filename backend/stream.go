@@ -0,0 +1,230 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultStreamConns is the max number of open schedule streams per server instance
+	// when config.Schedule.StreamConns is zero.
+	defaultStreamConns = 500
+	// streamRetry is the reconnection delay suggested to stream clients.
+	streamRetry = 5 * time.Second
+)
+
+var (
+	// streamHeartbeat is how often serveScheduleStream sends a comment line
+	// to keep the connection open through proxies.
+	streamHeartbeat = 15 * time.Second
+	// streamPoll is how often serveScheduleStream looks for changes
+	// stored by other server instances, which don't wake up the streams of this one.
+	streamPoll = 10 * time.Second
+
+	// streams keeps track of open schedule streams.
+	streams = newStreamHub()
+)
+
+// streamHub counts open streams and wakes them up when changes are stored.
+// It is safe for concurrent use.
+type streamHub struct {
+	mu     sync.Mutex
+	n      int           // open streams
+	wake   chan struct{} // closed and replaced by notify
+	end    chan struct{} // closed by endAll and close
+	closed bool
+}
+
+func newStreamHub() *streamHub {
+	return &streamHub{wake: make(chan struct{}), end: make(chan struct{})}
+}
+
+// add registers a new stream unless there are already max streams open
+// or the hub has been closed. It reports whether the stream has been added.
+func (h *streamHub) add(max int) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed || h.n >= max {
+		return false
+	}
+	h.n++
+	return true
+}
+
+// remove unregisters a stream previously added with add.
+func (h *streamHub) remove() {
+	h.mu.Lock()
+	h.n--
+	h.mu.Unlock()
+}
+
+// woken returns a channel which is closed on the next notify call.
+func (h *streamHub) woken() <-chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.wake
+}
+
+// notify wakes up all open streams to check the change log.
+func (h *streamHub) notify() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	close(h.wake)
+	h.wake = make(chan struct{})
+}
+
+// ended returns a channel which is closed on the next endAll or close call.
+func (h *streamHub) ended() <-chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.end
+}
+
+// endAll makes all open streams end but, unlike close, keeps accepting new ones.
+// It is used when config is reloaded, so that clients reconnect with the new one.
+func (h *streamHub) endAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.closed {
+		close(h.end)
+		h.end = make(chan struct{})
+	}
+}
+
+// close makes all open streams end and rejects new ones.
+// Clients are expected to reconnect to another server instance.
+func (h *streamHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.closed {
+		h.closed = true
+		close(h.end)
+	}
+}
+
+// streamConns returns the max number of open schedule streams allowed by cfg.
+func streamConns(cfg *appConfig) int {
+	if n := cfg.Schedule.StreamConns; n > 0 {
+		return n
+	}
+	return defaultStreamConns
+}
+
+// serveScheduleStream responds with a stream of schedule changes in Server-Sent Events format.
+// Each event is a change log entry, as stored by syncEventData, handleClock and debugPush,
+// with the entry timestamp in nanoseconds as its ID.
+// Clients resuming with Last-Event-ID header receive all entries stored after that ID first;
+// otherwise the stream starts with changes stored after the request.
+//
+// A stream ends when the config is reloaded, since it keeps using the config
+// it has been opened with, and when the server shuts down.
+func serveScheduleStream(w http.ResponseWriter, r *http.Request) {
+	c := newContext(r)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(c, w, http.StatusNotImplemented, "streaming is not supported")
+		return
+	}
+	since := time.Now()
+	if id := r.Header.Get("last-event-id"); id != "" {
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			writeJSONError(c, w, http.StatusBadRequest, "invalid Last-Event-ID")
+			return
+		}
+		since = time.Unix(0, n)
+	}
+	hub := streams
+	// take the channel before the config, so that a reload in between ends the stream
+	end := hub.ended()
+	cfg := config()
+	if !hub.add(streamConns(cfg)) {
+		streamTotal.inc("rejected")
+		w.Header().Set("retry-after", strconv.Itoa(int(streamRetry/time.Second)))
+		writeJSONError(c, w, http.StatusServiceUnavailable, "too many streams")
+		return
+	}
+	defer hub.remove()
+	streamTotal.inc("open")
+
+	var gone <-chan bool
+	if cn, ok := w.(http.CloseNotifier); ok {
+		gone = cn.CloseNotify()
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// disable response buffering of nginx and alike
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry/time.Millisecond)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	poll := time.NewTicker(streamPoll)
+	defer poll.Stop()
+	for check := true; ; {
+		// take the channel before reading the change log,
+		// so that changes stored in the meantime aren't missed
+		woken := hub.woken()
+		if check {
+			n := 0
+			err := forEachChange(c, since, func(dc *dataChanges) error {
+				for _, s := range dc.Speakers {
+					s.Thumb = thumbURL(s.Thumb)
+				}
+				b, err := json.Marshal(dc)
+				if err != nil {
+					return err
+				}
+				if _, err := fmt.Fprintf(w, "id: %d\nevent: changes\ndata: %s\n\n", dc.Updated.UnixNano(), b); err != nil {
+					return err
+				}
+				since = dc.Updated
+				n++
+				return nil
+			})
+			if n > 0 {
+				flusher.Flush()
+			}
+			if err != nil {
+				errorf(c, "serveScheduleStream: %v", err)
+				return
+			}
+			check = false
+		}
+
+		select {
+		case <-woken:
+			check = true
+		case <-poll.C:
+			check = true
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-gone:
+			return
+		case <-end:
+			return
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// streamRecorder is an http.ResponseWriter of a streaming response
+// which can be read while the response is being written.
+type streamRecorder struct {
+	header http.Header
+	closed chan bool
+
+	mu   sync.Mutex
+	code int
+	body bytes.Buffer
+}
+
+func newStreamRecorder() *streamRecorder {
+	return &streamRecorder{header: make(http.Header), closed: make(chan bool, 1)}
+}
+
+func (w *streamRecorder) Header() http.Header {
+	return w.header
+}
+
+func (w *streamRecorder) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *streamRecorder) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.body.Write(b)
+}
+
+func (w *streamRecorder) Flush() {}
+
+func (w *streamRecorder) CloseNotify() <-chan bool {
+	return w.closed
+}
+
+func (w *streamRecorder) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.body.String()
+}
+
+// waitFor waits until the response contains s.
+func (w *streamRecorder) waitFor(t *testing.T, s string) {
+	for end := time.Now().Add(5 * time.Second); time.Now().Before(end); time.Sleep(5 * time.Millisecond) {
+		if strings.Contains(w.String(), s) {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %q in:\n%s", s, w)
+}
+
+func TestServeScheduleStream(t *testing.T) {
+	defer resetTestState(t)
+	defer preserveConfig()()
+	defer func(h *streamHub, hb, p time.Duration, n int) {
+		streams, streamHeartbeat, streamPoll, changesPageSize = h, hb, p, n
+	}(streams, streamHeartbeat, streamPoll, changesPageSize)
+	streams = newStreamHub()
+	streamHeartbeat = 10 * time.Millisecond
+	streamPoll = time.Hour
+	changesPageSize = 3
+	config().Schedule.StreamConns = 1
+	c := newContext(newTestRequest(t, "GET", "/", nil))
+
+	// "three" and "four" share a timestamp across pages of the change log
+	start := time.Now().Add(-time.Minute).Truncate(time.Second)
+	for _, item := range []struct {
+		id  string
+		sec int
+	}{{"zero", 0}, {"one", 1}, {"two", 2}, {"three", 3}, {"four", 3}} {
+		if err := storeChanges(c, &dataChanges{
+			Updated:   start.Add(time.Duration(item.sec) * time.Second),
+			eventData: eventData{Sessions: map[string]*eventSession{item.id: {ID: item.id}}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// resume after the first change
+	r := newTestRequest(t, "GET", "/api/v1/schedule/stream", nil)
+	r.Header.Set("last-event-id", fmt.Sprintf("%d", start.UnixNano()))
+	w := newStreamRecorder()
+	done := make(chan struct{})
+	go func() {
+		serveScheduleStream(w, r)
+		close(done)
+	}()
+	w.waitFor(t, fmt.Sprintf("id: %d\nevent: changes\n", start.Add(time.Second).UnixNano()))
+	w.waitFor(t, `"four"`)
+	w.waitFor(t, ": heartbeat\n\n")
+	if strings.Contains(w.String(), `"zero"`) {
+		t.Errorf("resumed stream contains the change of Last-Event-ID:\n%s", w)
+	}
+	if v := w.Header().Get("content-type"); v != "text/event-stream" {
+		t.Errorf("content-type = %q; want text/event-stream", v)
+	}
+
+	// connections are capped
+	w2 := httptest.NewRecorder()
+	serveScheduleStream(w2, newTestRequest(t, "GET", "/api/v1/schedule/stream", nil))
+	if w2.Code != http.StatusServiceUnavailable {
+		t.Errorf("w2.Code = %d; want %d", w2.Code, http.StatusServiceUnavailable)
+	}
+
+	// new changes are sent as they are stored
+	if err := storeChanges(c, &dataChanges{
+		Updated:   start.Add(4 * time.Second),
+		eventData: eventData{Sessions: map[string]*eventSession{"five": {ID: "five"}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	w.waitFor(t, `"five"`)
+
+	w.closed <- true
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream didn't end after the client has gone")
+	}
+	if !streams.add(1) {
+		t.Errorf("streams.add(1) = false after the stream has ended")
+	}
+}
+
+func TestScheduleStreamHandler(t *testing.T) {
+	defer resetTestState(t)
+	defer preserveConfig()()
+	defer func(h *streamHub) { streams = h }(streams)
+	streams = newStreamHub()
+
+	// same handler chain as the one registered by handle func
+	h := handler(instrumentHandler("/api/v1/schedule/stream", serveScheduleStream))
+	r := newTestRequest(t, "GET", config().Prefix+"/api/v1/schedule/stream", nil)
+	w := newStreamRecorder()
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(w, r)
+		close(done)
+	}()
+	w.waitFor(t, "retry: ")
+
+	// config reload ends open streams
+	streams.endAll()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream didn't end after endAll")
+	}
+	if w.code != http.StatusOK {
+		t.Errorf("w.code = %d; want %d", w.code, http.StatusOK)
+	}
+	if !streams.add(1) {
+		t.Errorf("streams.add(1) = false after endAll; want new streams to be accepted")
+	}
+}
@@ -25,8 +25,6 @@ import (
 	"time"
 
 	"golang.org/x/net/context"
-
-	"google.golang.org/appengine/log"
 )
 
 type epointPayload struct {
@@ -49,12 +47,13 @@ type sessionSurvey struct {
 }
 
 func (s *sessionSurvey) valid() bool {
+	cfg := config()
 	ok := func(v string) bool {
 		if v == "" {
 			return true
 		}
-		i := sort.SearchStrings(config.Survey.Answers, v)
-		return i < len(config.Survey.Answers) && config.Survey.Answers[i] == v
+		i := sort.SearchStrings(cfg.Survey.Answers, v)
+		return i < len(cfg.Survey.Answers) && cfg.Survey.Answers[i] == v
 	}
 	return ok(s.Overall) && ok(s.Relevance) && ok(s.Content) && ok(s.Speaker)
 }
@@ -87,43 +86,52 @@ func addSessionSurvey(ctx context.Context, tok, uid, sid string) error {
 
 // submitSessionSurvey sends a request to config.Survey.Endpoint with s data
 // according to https://api.eventpoint.com/2.3/Home/REST#evals docs.
-func submitSessionSurvey(c context.Context, sid string, s *sessionSurvey) error {
+func submitSessionSurvey(c context.Context, sid string, s *sessionSurvey) (err error) {
+	cfg := config()
 	// dev config doesn't normally have a valid endpoint
-	if config.Survey.Endpoint == "" {
+	if cfg.Survey.Endpoint == "" {
+		surveyTotal.inc("skipped")
 		return nil
 	}
+	defer func() {
+		if err != nil {
+			surveyTotal.inc("error")
+		} else {
+			surveyTotal.inc("success")
+		}
+	}()
 
 	perr := prefixedErr("submitSessionSurvey")
-	if v, ok := config.Survey.Smap[sid]; ok {
+	if v, ok := cfg.Survey.Smap[sid]; ok {
 		sid = v
 	}
 	p := &epointPayload{
-		SurveyID:   config.Survey.ID,
+		SurveyID:   cfg.Survey.ID,
 		ObjectID:   sid,
-		Registrant: config.Survey.Reg,
+		Registrant: cfg.Survey.Reg,
 		Responses:  make([]epointResponse, 0, 4),
 	}
 	if s.Overall != "" {
 		p.Responses = append(p.Responses, epointResponse{
-			Question: config.Survey.Q1,
+			Question: cfg.Survey.Q1,
 			Answer:   s.Overall,
 		})
 	}
 	if s.Relevance != "" {
 		p.Responses = append(p.Responses, epointResponse{
-			Question: config.Survey.Q2,
+			Question: cfg.Survey.Q2,
 			Answer:   s.Relevance,
 		})
 	}
 	if s.Content != "" {
 		p.Responses = append(p.Responses, epointResponse{
-			Question: config.Survey.Q3,
+			Question: cfg.Survey.Q3,
 			Answer:   s.Content,
 		})
 	}
 	if s.Speaker != "" {
 		p.Responses = append(p.Responses, epointResponse{
-			Question: config.Survey.Q4,
+			Question: cfg.Survey.Q4,
 			Answer:   s.Speaker,
 		})
 	}
@@ -133,14 +141,14 @@ func submitSessionSurvey(c context.Context, sid string, s *sessionSurvey) error
 	}
 	if !isProd() {
 		// log request body on staging for debugging
-		log.Debugf(c, "%s: %s", config.Survey.Endpoint, body)
+		logf(c, "%s: %s", cfg.Survey.Endpoint, body)
 	}
 
-	r, err := http.NewRequest("POST", config.Survey.Endpoint, bytes.NewReader(body))
+	r, err := http.NewRequest("POST", cfg.Survey.Endpoint, bytes.NewReader(body))
 	if err != nil {
 		return perr(err)
 	}
-	r.Header.Set("apikey", config.Survey.Key)
+	r.Header.Set("apikey", cfg.Survey.Key)
 	r.Header.Set("content-type", "application/json")
 	c, _ = context.WithTimeout(c, 30*time.Second)
 	res, err := httpClient(c).Do(r)
@@ -152,7 +160,7 @@ func submitSessionSurvey(c context.Context, sid string, s *sessionSurvey) error
 	rb, _ := ioutil.ReadAll(res.Body)
 	// log response on non-prod env for debugging
 	if !isProd() {
-		log.Debugf(c, string(rb))
+		logf(c, "%s", rb)
 	}
 	if res.StatusCode == http.StatusOK {
 		return nil
@@ -0,0 +1,633 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"gopkg.in/yaml.v2"
+
+	"google.golang.org/appengine/taskqueue"
+)
+
+const (
+	// defaultQueueName is the queue used when no queue name is specified.
+	defaultQueueName = "default"
+
+	// default queue settings, same as GAE defaults.
+	defaultQueueRate       = 5.0
+	defaultQueueBucketSize = 5
+	// defaultQueueConcurrency is used when queue.yaml doesn't limit max concurrent requests.
+	defaultQueueConcurrency = 10
+	// default backoff settings for failed tasks.
+	defaultMinBackoff   = 100 * time.Millisecond
+	defaultMaxBackoff   = time.Hour
+	defaultMaxDoublings = 16
+
+	// drainPollInterval is how often drain and stop check whether queues have finished.
+	drainPollInterval = 10 * time.Millisecond
+)
+
+// queue is the instance used by the program,
+// initialized by the standalone server's main() or server_gae.
+var queue taskQueue
+
+// taskQueue unifies different types of task queues,
+// e.g. localTaskQueue and appengine/taskqueue.
+type taskQueue interface {
+	// add enqueues task t onto the queue qname.
+	// Empty qname means the default queue.
+	add(c context.Context, t *task, qname string) error
+}
+
+// task is an HTTP request to be executed asynchronously.
+type task struct {
+	Path    string
+	Method  string
+	Header  http.Header
+	Payload []byte
+	// Delay is how long to wait before the first execution.
+	Delay time.Duration
+}
+
+// newPOSTTask creates a task which will POST form params to path.
+func newPOSTTask(path string, params url.Values) *task {
+	return &task{
+		Path:    path,
+		Method:  "POST",
+		Header:  http.Header{"Content-Type": {"application/x-www-form-urlencoded"}},
+		Payload: []byte(params.Encode()),
+	}
+}
+
+// taskQueue implementation using appengine/taskqueue.
+type gaeTaskQueue struct{}
+
+func (q *gaeTaskQueue) add(c context.Context, t *task, qname string) error {
+	gt := &taskqueue.Task{
+		Path:    t.Path,
+		Method:  t.Method,
+		Header:  t.Header,
+		Payload: t.Payload,
+		Delay:   t.Delay,
+	}
+	_, err := taskqueue.Add(c, gt, qname)
+	return err
+}
+
+// queueConfig is a queue definition of queue.yaml.
+type queueConfig struct {
+	Name          string `yaml:"name"`
+	Rate          string `yaml:"rate"`
+	BucketSize    int    `yaml:"bucket_size"`
+	MaxConcurrent int    `yaml:"max_concurrent_requests"`
+	Retry         struct {
+		Limit        int     `yaml:"task_retry_limit"`
+		MinBackoff   float64 `yaml:"min_backoff_seconds"`
+		MaxBackoff   float64 `yaml:"max_backoff_seconds"`
+		MaxDoublings int     `yaml:"max_doublings"`
+	} `yaml:"retry_parameters"`
+}
+
+// readQueueConfig parses queue definitions of queue.yaml file.
+func readQueueConfig(filename string) ([]*queueConfig, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var data struct {
+		Queue []*queueConfig `yaml:"queue"`
+	}
+	if err := yaml.Unmarshal(b, &data); err != nil {
+		return nil, fmt.Errorf("readQueueConfig(%q): %v", filename, err)
+	}
+	return data.Queue, nil
+}
+
+// parseQueueRate converts queue.yaml rate, e.g. "5/s" or "100/m", into tasks per second.
+func parseQueueRate(s string) (float64, error) {
+	i := strings.IndexRune(s, '/')
+	if i < 0 {
+		return 0, fmt.Errorf("parseQueueRate(%q): missing time unit", s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("parseQueueRate(%q): invalid number", s)
+	}
+	switch s[i+1:] {
+	case "s":
+		return n, nil
+	case "m":
+		return n / 60, nil
+	case "h":
+		return n / 3600, nil
+	case "d":
+		return n / 86400, nil
+	}
+	return 0, fmt.Errorf("parseQueueRate(%q): unknown time unit", s)
+}
+
+// localTaskQueue is a taskQueue implementation which executes tasks in-process
+// by calling handler h directly, similar to how GAE Task Queue would make HTTP requests.
+// Each named queue runs tasks on its own bounded pool of workers.
+type localTaskQueue struct {
+	queues map[string]*localQueue
+}
+
+// newLocalTaskQueue creates a new localTaskQueue with queues defined by cfg
+// and starts their workers. A default queue is created if cfg doesn't have one.
+func newLocalTaskQueue(cfg []*queueConfig, h http.Handler) (*localTaskQueue, error) {
+	tq := &localTaskQueue{queues: make(map[string]*localQueue)}
+	for _, qc := range cfg {
+		q, err := newLocalQueue(qc, h)
+		if err != nil {
+			return nil, err
+		}
+		tq.queues[q.name] = q
+	}
+	if _, ok := tq.queues[defaultQueueName]; !ok {
+		q, _ := newLocalQueue(&queueConfig{Name: defaultQueueName}, h)
+		tq.queues[defaultQueueName] = q
+	}
+	for _, q := range tq.queues {
+		q.start()
+	}
+	return tq, nil
+}
+
+func (tq *localTaskQueue) add(c context.Context, t *task, qname string) error {
+	if qname == "" {
+		qname = defaultQueueName
+	}
+	q, ok := tq.queues[qname]
+	if !ok {
+		return fmt.Errorf("localTaskQueue: unknown queue %q", qname)
+	}
+	q.add(t)
+	return nil
+}
+
+// drain waits until none of the queues has running tasks or tasks which are due,
+// or c is done. Queues keep dispatching tasks while draining,
+// including the ones added by running tasks.
+func (tq *localTaskQueue) drain(c context.Context) {
+	tq.wait(c, true)
+}
+
+// stop stops dispatching tasks and waits for the running ones to finish, or until c is done.
+// It returns all pending tasks and those which are still running.
+// Tasks added after stop returns are never executed.
+func (tq *localTaskQueue) stop(c context.Context) []*pendingTask {
+	for _, q := range tq.queues {
+		close(q.stopc)
+	}
+	tq.wait(c, false)
+	var res []*pendingTask
+	for _, q := range tq.queues {
+		res = append(res, q.unfinished()...)
+	}
+	return res
+}
+
+// wait waits until none of the queues has running tasks, or c is done.
+// If due is true, it also waits for pending tasks which are due.
+func (tq *localTaskQueue) wait(c context.Context, due bool) {
+	for {
+		idle := true
+		for _, q := range tq.queues {
+			r, d := q.state(time.Now())
+			idle = idle && r == 0 && (!due || d == 0)
+		}
+		if idle {
+			return
+		}
+		select {
+		case <-c.Done():
+			return
+		case <-time.After(drainPollInterval):
+		}
+	}
+}
+
+// restore schedules tasks saved by stop.
+// Tasks of queues which no longer exist are put onto the default queue.
+func (tq *localTaskQueue) restore(tasks []*pendingTask) {
+	for _, pt := range tasks {
+		q, ok := tq.queues[pt.Queue]
+		if !ok {
+			q = tq.queues[defaultQueueName]
+		}
+		q.schedule(&localTask{
+			task: &task{
+				Path:    pt.Path,
+				Method:  pt.Method,
+				Header:  pt.Header,
+				Payload: pt.Payload,
+			},
+			name:    pt.Name,
+			eta:     pt.ETA,
+			retries: pt.Retries,
+		})
+	}
+}
+
+// pendingTask is an unfinished task of localTaskQueue,
+// saved across server restarts.
+type pendingTask struct {
+	Queue   string
+	Name    string
+	Path    string
+	Method  string
+	Header  http.Header
+	Payload []byte
+	ETA     time.Time
+	Retries int
+}
+
+// localQueue is a single named queue of localTaskQueue.
+// A dispatcher goroutine hands over tasks which are due to workers,
+// at a rate limited by a token bucket.
+type localQueue struct {
+	name         string
+	rate         float64 // tasks per second
+	bucketSize   int
+	workers      int
+	retryLimit   int // zero means no limit
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	maxDoublings int
+	handler      http.Handler
+
+	mu      sync.Mutex // guards fields below
+	pending localTaskHeap
+	running map[*localTask]bool // dispatched tasks
+	seq     int64
+	tokens  float64
+	refill  time.Time // last tokens refill
+
+	wake  chan struct{} // signals dispatcher about new pending tasks
+	ready chan *localTask
+	stopc chan struct{} // closed to stop dispatching
+}
+
+// localTask is a task scheduled for execution at eta.
+type localTask struct {
+	*task
+	name    string
+	eta     time.Time
+	retries int
+}
+
+func newLocalQueue(qc *queueConfig, h http.Handler) (*localQueue, error) {
+	q := &localQueue{
+		name:         qc.Name,
+		rate:         defaultQueueRate,
+		bucketSize:   qc.BucketSize,
+		workers:      qc.MaxConcurrent,
+		retryLimit:   qc.Retry.Limit,
+		minBackoff:   time.Duration(qc.Retry.MinBackoff * float64(time.Second)),
+		maxBackoff:   time.Duration(qc.Retry.MaxBackoff * float64(time.Second)),
+		maxDoublings: qc.Retry.MaxDoublings,
+		handler:      h,
+		running:      make(map[*localTask]bool),
+		wake:         make(chan struct{}, 1),
+		ready:        make(chan *localTask),
+		stopc:        make(chan struct{}),
+	}
+	if q.name == "" {
+		return nil, fmt.Errorf("newLocalQueue: empty queue name")
+	}
+	if qc.Rate != "" {
+		r, err := parseQueueRate(qc.Rate)
+		if err != nil {
+			return nil, err
+		}
+		q.rate = r
+	}
+	if q.bucketSize <= 0 {
+		q.bucketSize = defaultQueueBucketSize
+	}
+	if q.workers <= 0 {
+		q.workers = defaultQueueConcurrency
+	}
+	if q.minBackoff <= 0 {
+		q.minBackoff = defaultMinBackoff
+	}
+	if q.maxBackoff <= 0 {
+		q.maxBackoff = defaultMaxBackoff
+	}
+	if q.maxDoublings <= 0 {
+		q.maxDoublings = defaultMaxDoublings
+	}
+	q.tokens = float64(q.bucketSize)
+	q.refill = time.Now()
+	return q, nil
+}
+
+// start spawns the dispatcher and the workers of q.
+func (q *localQueue) start() {
+	go q.dispatch()
+	for i := 0; i < q.workers; i++ {
+		go func() {
+			for t := range q.ready {
+				q.execute(t)
+			}
+		}()
+	}
+}
+
+// add schedules a new task t for execution after t.Delay.
+func (q *localQueue) add(t *task) {
+	q.mu.Lock()
+	q.seq++
+	lt := &localTask{
+		task: t,
+		name: fmt.Sprintf("%s-%d", q.name, q.seq),
+		eta:  time.Now().Add(t.Delay),
+	}
+	q.mu.Unlock()
+	q.schedule(lt)
+}
+
+// schedule puts t onto the pending tasks heap and wakes up the dispatcher.
+func (q *localQueue) schedule(t *localTask) {
+	q.mu.Lock()
+	heap.Push(&q.pending, t)
+	q.mu.Unlock()
+	q.wakeDispatcher()
+}
+
+// wakeDispatcher notifies the dispatcher about new pending tasks.
+func (q *localQueue) wakeDispatcher() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+		// dispatcher has been notified already
+	}
+}
+
+// state returns the number of running tasks and pending tasks which are due at now.
+func (q *localQueue) state(now time.Time) (running, due int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, t := range q.pending {
+		if !t.eta.After(now) {
+			due++
+		}
+	}
+	return len(q.running), due
+}
+
+// unfinished returns a copy of all pending and running tasks.
+func (q *localQueue) unfinished() []*pendingTask {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	res := make([]*pendingTask, 0, len(q.pending)+len(q.running))
+	add := func(t *localTask) {
+		res = append(res, &pendingTask{
+			Queue:   q.name,
+			Name:    t.name,
+			Path:    t.Path,
+			Method:  t.Method,
+			Header:  t.Header,
+			Payload: t.Payload,
+			ETA:     t.eta,
+			Retries: t.retries,
+		})
+	}
+	for t := range q.running {
+		add(t)
+	}
+	for _, t := range q.pending {
+		add(t)
+	}
+	return res
+}
+
+// dispatch waits for pending tasks to become due and hands them over to workers.
+// It returns when q.stopc is closed.
+func (q *localQueue) dispatch() {
+	for {
+		q.mu.Lock()
+		var wait time.Duration
+		n := len(q.pending)
+		if n > 0 {
+			wait = q.pending[0].eta.Sub(time.Now())
+		}
+		q.mu.Unlock()
+
+		switch {
+		case n == 0:
+			select {
+			case <-q.wake:
+			case <-q.stopc:
+				return
+			}
+			continue
+		case wait > 0:
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-q.wake:
+			case <-q.stopc:
+				timer.Stop()
+				return
+			}
+			timer.Stop()
+			continue
+		}
+
+		timer := time.NewTimer(q.takeToken())
+		select {
+		case <-timer.C:
+		case <-q.stopc:
+			timer.Stop()
+			return
+		}
+		q.mu.Lock()
+		t := heap.Pop(&q.pending).(*localTask)
+		q.running[t] = true
+		q.mu.Unlock()
+		select {
+		case q.ready <- t:
+		case <-q.stopc:
+			q.mu.Lock()
+			delete(q.running, t)
+			heap.Push(&q.pending, t)
+			q.mu.Unlock()
+			return
+		}
+	}
+}
+
+// takeToken takes a token from the bucket and returns how long
+// the caller has to wait before the token can be used.
+func (q *localQueue) takeToken() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	q.tokens += now.Sub(q.refill).Seconds() * q.rate
+	if max := float64(q.bucketSize); q.tokens > max {
+		q.tokens = max
+	}
+	q.refill = now
+	q.tokens--
+	if q.tokens >= 0 || q.rate <= 0 {
+		return 0
+	}
+	return time.Duration(-q.tokens / q.rate * float64(time.Second))
+}
+
+// execute runs task t by calling q.handler and re-schedules the task
+// with exponential backoff if the handler responds with a non-2xx status code.
+func (q *localQueue) execute(t *localTask) {
+	r, err := http.NewRequest(t.Method, t.Path, bytes.NewReader(t.Payload))
+	if err != nil {
+		errorf(context.Background(), "localQueue(%s): task %s: %v", q.name, t.name, err)
+		q.finish(t, false)
+		return
+	}
+	for k, v := range t.Header {
+		r.Header[k] = v
+	}
+	r.Host = config().Addr
+	r.RemoteAddr = "0.1.0.2:0"
+	r.Header.Set("X-AppEngine-QueueName", q.name)
+	r.Header.Set("X-AppEngine-TaskName", t.name)
+	r.Header.Set("X-AppEngine-TaskRetryCount", strconv.Itoa(t.retries))
+	r.Header.Set("X-AppEngine-TaskExecutionCount", strconv.Itoa(t.retries+1))
+
+	w := &taskResponse{header: make(http.Header)}
+	q.serveTask(w, r, t)
+	if w.code == 0 || (w.code >= 200 && w.code < 300) {
+		q.finish(t, false)
+		return
+	}
+
+	t.retries++
+	if q.retryLimit > 0 && t.retries > q.retryLimit {
+		errorf(context.Background(), "localQueue(%s): task %s %s failed %d times; giving up", q.name, t.name, t.Path, t.retries)
+		q.finish(t, false)
+		return
+	}
+	t.eta = time.Now().Add(q.backoff(t.retries))
+	q.finish(t, true)
+}
+
+// serveTask calls q.handler to run task t with request r.
+// A panic of the handler is logged and treated as a 500 response,
+// so that the task is retried instead of crashing the server.
+func (q *localQueue) serveTask(w *taskResponse, r *http.Request, t *localTask) {
+	defer func() {
+		if err := recover(); err != nil {
+			errorf(context.Background(), "localQueue(%s): task %s %s panic: %v\n%s", q.name, t.name, t.Path, err, debug.Stack())
+			w.code = http.StatusInternalServerError
+		}
+	}()
+	q.handler.ServeHTTP(w, r)
+}
+
+// finish removes executed task t from running tasks.
+// If retry is true, t is moved back onto the pending tasks heap
+// so that it is never missing from both.
+func (q *localQueue) finish(t *localTask, retry bool) {
+	q.mu.Lock()
+	delete(q.running, t)
+	if retry {
+		heap.Push(&q.pending, t)
+	}
+	q.mu.Unlock()
+	if retry {
+		q.wakeDispatcher()
+	}
+}
+
+// backoff returns the delay before n-th retry of a failed task.
+// The delay doubles with each retry, starting from q.minBackoff,
+// up to q.maxDoublings times and capped at q.maxBackoff.
+func (q *localQueue) backoff(n int) time.Duration {
+	k := n - 1
+	if k > q.maxDoublings {
+		k = q.maxDoublings
+	}
+	d := float64(q.minBackoff) * math.Pow(2, float64(k))
+	if d > float64(q.maxBackoff) {
+		return q.maxBackoff
+	}
+	return time.Duration(d)
+}
+
+// taskResponse is a minimal http.ResponseWriter which records response status code
+// and discards the body.
+type taskResponse struct {
+	header http.Header
+	code   int
+}
+
+func (w *taskResponse) Header() http.Header {
+	return w.header
+}
+
+func (w *taskResponse) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return len(b), nil
+}
+
+func (w *taskResponse) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+// localTaskHeap implements heap.Interface ordering tasks by eta.
+type localTaskHeap []*localTask
+
+func (h localTaskHeap) Len() int {
+	return len(h)
+}
+
+func (h localTaskHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h localTaskHeap) Less(i, j int) bool {
+	return h[i].eta.Before(h[j].eta)
+}
+
+func (h *localTaskHeap) Push(x interface{}) {
+	*h = append(*h, x.(*localTask))
+}
+
+func (h *localTaskHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	*h = old[:n-1]
+	return t
+}
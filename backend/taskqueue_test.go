@@ -0,0 +1,160 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestReadQueueConfig(t *testing.T) {
+	t.Parallel()
+	cfg, err := readQueueConfig("queue.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg) != 1 {
+		t.Fatalf("len(cfg) = %d; want 1", len(cfg))
+	}
+	q := cfg[0]
+	if q.Name != "default" || q.Rate != "500/s" || q.BucketSize != 100 || q.MaxConcurrent != 1000 {
+		t.Errorf("cfg[0] = %+v; want default, 500/s, 100, 1000", q)
+	}
+}
+
+func TestParseQueueRate(t *testing.T) {
+	t.Parallel()
+	table := []struct {
+		in  string
+		out float64
+	}{
+		{"5/s", 5},
+		{"120/m", 2},
+		{"7200/h", 2},
+		{"86400/d", 1},
+	}
+	for _, test := range table {
+		r, err := parseQueueRate(test.in)
+		if err != nil {
+			t.Errorf("parseQueueRate(%q): %v", test.in, err)
+		}
+		if r != test.out {
+			t.Errorf("parseQueueRate(%q) = %f; want %f", test.in, r, test.out)
+		}
+	}
+	for _, s := range []string{"", "5", "x/s", "5/w"} {
+		if _, err := parseQueueRate(s); err == nil {
+			t.Errorf("parseQueueRate(%q): want error", s)
+		}
+	}
+}
+
+func TestLocalTaskQueueRetry(t *testing.T) {
+	t.Parallel()
+	type call struct {
+		retry string
+		count string
+		name  string
+		value string
+	}
+	calls := make(chan call, 10)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/task/test" {
+			t.Errorf("r.URL.Path = %q; want /task/test", r.URL.Path)
+		}
+		n, err := taskRetryCount(r)
+		if err != nil {
+			t.Errorf("taskRetryCount: %v", err)
+		}
+		calls <- call{
+			retry: r.Header.Get("X-AppEngine-TaskRetryCount"),
+			count: r.Header.Get("X-AppEngine-TaskExecutionCount"),
+			name:  r.Header.Get("X-AppEngine-TaskName"),
+			value: r.FormValue("key"),
+		}
+		switch n {
+		case 0:
+			panic("test panic")
+		case 1:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	qc := &queueConfig{Name: "test", Rate: "100/s"}
+	qc.Retry.MinBackoff = 0.001
+	tq, err := newLocalTaskQueue([]*queueConfig{qc}, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := newPOSTTask("/task/test", url.Values{"key": {"value"}})
+	if err := tq.add(context.Background(), tk, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tq.add(context.Background(), tk, "unknown"); err == nil {
+		t.Errorf("add(unknown): want error")
+	}
+
+	want := []call{
+		{"0", "1", "test-1", "value"},
+		{"1", "2", "test-1", "value"},
+		{"2", "3", "test-1", "value"},
+	}
+	for i, w := range want {
+		select {
+		case c := <-calls:
+			if c != w {
+				t.Errorf("%d: call = %+v; want %+v", i, c, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d: task has not been executed", i)
+		}
+	}
+	select {
+	case c := <-calls:
+		t.Errorf("unexpected call %+v", c)
+	case <-time.After(100 * time.Millisecond):
+		// no more retries
+	}
+}
+
+func TestLocalQueueBackoff(t *testing.T) {
+	t.Parallel()
+	qc := &queueConfig{Name: "test"}
+	qc.Retry.MinBackoff = 1
+	qc.Retry.MaxBackoff = 10
+	qc.Retry.MaxDoublings = 2
+	q, err := newLocalQueue(qc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := []struct {
+		n   int
+		out time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{10, 4 * time.Second},
+	}
+	for _, test := range table {
+		if d := q.backoff(test.n); d != test.out {
+			t.Errorf("backoff(%d) = %s; want %s", test.n, d, test.out)
+		}
+	}
+}
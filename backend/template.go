@@ -113,6 +113,7 @@ type sitemapItem struct {
 // using either layout_full.html or layout_partial.html as the root template.
 // env is the app current environment: "dev", "stage" or "prod".
 func renderTemplate(c context.Context, name string, partial bool, data *templateData) ([]byte, error) {
+	cfg := config()
 	tpl, err := parseTemplate(name, partial)
 	if err != nil {
 		return nil, err
@@ -121,13 +122,13 @@ func renderTemplate(c context.Context, name string, partial bool, data *template
 		data = &templateData{}
 	}
 	if data.Env == "" {
-		data.Env = config.Env
+		data.Env = cfg.Env
 	}
-	data.ClientID = config.Google.Auth.Client
+	data.ClientID = cfg.Google.Auth.Client
 	data.Slug = name
-	data.Prefix = config.Prefix
-	data.StartDateStr = config.Schedule.Start.In(config.Schedule.Location).Format(time.RFC3339)
-	data.FirebaseShards = config.Firebase.Shards
+	data.Prefix = cfg.Prefix
+	data.StartDateStr = cfg.Schedule.Start.In(cfg.Schedule.Location).Format(time.RFC3339)
+	data.FirebaseShards = cfg.Firebase.Shards
 	if v, err := scheduleLiveIDs(c, time.Now()); err == nil {
 		data.LiveIDs = v
 	}
@@ -153,7 +154,8 @@ func renderTemplate(c context.Context, name string, partial bool, data *template
 
 // renderManifest renders app/templates/manifest.json app manifest.
 func renderManifest() ([]byte, error) {
-	t, err := text.ParseFiles(filepath.Join(config.Dir, templatesDir, "manifest.json"))
+	cfg := config()
+	t, err := text.ParseFiles(filepath.Join(cfg.Dir, templatesDir, "manifest.json"))
 	if err != nil {
 		return nil, err
 	}
@@ -162,7 +164,7 @@ func renderManifest() ([]byte, error) {
 		GCMSenderID string
 	}{
 		Name:        defaultTitle,
-		GCMSenderID: config.Google.GCM.Sender,
+		GCMSenderID: cfg.Google.GCM.Sender,
 	}
 	var b bytes.Buffer
 	if err := t.Execute(&b, data); err != nil {
@@ -174,6 +176,7 @@ func renderManifest() ([]byte, error) {
 // parseTemplate creates a template identified by name, using appropriate layout.
 // HTTP error layout is used for name arg prefixed with "error_", e.g. "error_404".
 func parseTemplate(name string, partial bool) (*html.Template, error) {
+	cfg := config()
 	var layout string
 	switch {
 	default:
@@ -197,10 +200,10 @@ func parseTemplate(name string, partial bool) (*html.Template, error) {
 
 	name += ".html"
 	tname := name
-	tfiles := []string{filepath.Join(config.Dir, templatesDir, name)}
+	tfiles := []string{filepath.Join(cfg.Dir, templatesDir, name)}
 	if layout != "" {
 		tname = layout
-		tfiles = append([]string{filepath.Join(config.Dir, templatesDir, layout)}, tfiles...)
+		tfiles = append([]string{filepath.Join(cfg.Dir, templatesDir, layout)}, tfiles...)
 	}
 
 	t, err := html.New(tname).Delims("{%", "%}").Funcs(tmplFunc).ParseFiles(tfiles...)
@@ -227,13 +230,14 @@ func pageTitle(t *html.Template) string {
 // returns "/myprefix/images/img.jpg".
 // If the first part starts with http(s)://, it is the returned value.
 func resourceURL(parts ...string) string {
+	cfg := config()
 	lp := strings.ToLower(parts[0])
 	if strings.HasPrefix(lp, "http://") || strings.HasPrefix(lp, "https://") {
 		return parts[0]
 	}
 	p := strings.Join(parts, "/")
-	if !strings.HasPrefix(p, config.Prefix) {
-		p = config.Prefix + "/" + p
+	if !strings.HasPrefix(p, cfg.Prefix) {
+		p = cfg.Prefix + "/" + p
 	}
 	return path.Clean(p)
 }
@@ -265,7 +269,7 @@ func getSitemap(c context.Context, baseURL *url.URL) (*sitemap, error) {
 	var items []*sitemapItem
 
 	// templated pages
-	root := filepath.Join(config.Dir, templatesDir)
+	root := filepath.Join(config().Dir, templatesDir)
 	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
 		if err != nil {
 			return err
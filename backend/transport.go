@@ -48,9 +48,10 @@ func oauth2Client(c context.Context, ts oauth2.TokenSource) *http.Client {
 
 // twitterClient creates a new HTTP client based on oauth2Client() and httpTransport().
 func twitterClient(c context.Context) *http.Client {
+	cfg := config()
 	cred := &twitterCredentials{
-		key:       config.Twitter.Key,
-		secret:    config.Twitter.Secret,
+		key:       cfg.Twitter.Key,
+		secret:    cfg.Twitter.Secret,
 		transport: httpTransport(c),
 		cache:     cache,
 	}
@@ -59,7 +60,7 @@ func twitterClient(c context.Context) *http.Client {
 
 // serviceAccountClient creates a new HTTP client using serviceCredentials() and oauth2Client().
 func serviceAccountClient(c context.Context, scopes ...string) (*http.Client, error) {
-	if config.Google.ServiceAccount.Key == "" {
+	if config().Google.ServiceAccount.Key == "" {
 		// useful for testing
 		errorf(c, "serviceAccountClient: no credentials provided; using standard httpClient")
 		return &http.Client{Transport: httpTransport(c)}, nil
@@ -78,7 +79,7 @@ type firebaseTransport struct {
 
 func (t firebaseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	q := req.URL.Query()
-	q.Add("auth", config.Firebase.Secret)
+	q.Add("auth", config().Firebase.Secret)
 	req.URL.RawQuery = q.Encode()
 	return t.base.RoundTrip(req)
 }
@@ -30,7 +30,7 @@ func TestFirebaseClient(t *testing.T) {
 		if v := r.URL.Query().Get("foo"); v != "bar" {
 			t.Errorf("foo = %q; want 'bar'", v)
 		}
-		if v := r.URL.Query().Get("auth"); v != config.Firebase.Secret {
+		if v := r.URL.Query().Get("auth"); v != config().Firebase.Secret {
 			// don't expose auth in build logs
 			t.Errorf("want auth query param to be config.Firebase.Secret")
 		}
@@ -0,0 +1,242 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// kinds of validationIssue
+const (
+	issueMissingID       = "missing_id"
+	issueBeforeStart     = "before_start"
+	issueDuplicateID     = "duplicate_id"
+	issueEndBeforeStart  = "end_before_start"
+	issueDanglingRoom    = "dangling_room"
+	issueDanglingSpeaker = "dangling_speaker"
+	issueDanglingTag     = "dangling_tag"
+	issueRoomOverlap     = "room_overlap"
+	issueBadThumbnail    = "bad_thumbnail"
+	issueOverride        = "override"
+)
+
+// warningIssues are kinds of validationIssue which are likely intended,
+// such as a later data file overriding an item of an earlier one.
+var warningIssues = map[string]bool{
+	issueOverride: true,
+}
+
+// reThumbSizes matches imageURLSizeMarker followed by available sizes
+// in the format thumbURL understands, e.g. "__w-200-400/".
+var reThumbSizes = regexp.MustCompile(`__w-\d+(-\d+)+/`)
+
+// validationReport lists mistakes in event data files found by validateEventData.
+type validationReport struct {
+	Created  time.Time          `json:"created"`
+	Modified time.Time          `json:"modified"` // manifest modification time
+	Files    []string           `json:"files"`
+	Issues   []*validationIssue `json:"issues"`
+}
+
+// validationIssue is a single mistake in an event data file.
+type validationIssue struct {
+	Kind string `json:"kind"`
+	File string `json:"file,omitempty"`
+	Item string `json:"item"` // session, speaker, video, room or tag
+	ID   string `json:"id,omitempty"`
+	Msg  string `json:"msg"`
+	// Warning is set for kinds listed in warningIssues.
+	Warning bool `json:"warning,omitempty"`
+}
+
+// count returns the number of issues in the report, not including warnings,
+// and the number of warnings.
+func (r *validationReport) count() (errors, warnings int) {
+	for _, is := range r.Issues {
+		if is.Warning {
+			warnings++
+		} else {
+			errors++
+		}
+	}
+	return errors, warnings
+}
+
+// issueList collects validation issues of a data file.
+type issueList []*validationIssue
+
+// add appends a new issue of kind about item id, described by format and args.
+func (l *issueList) add(kind, item, id, format string, args ...interface{}) {
+	*l = append(*l, &validationIssue{
+		Kind:    kind,
+		Item:    item,
+		ID:      id,
+		Msg:     fmt.Sprintf(format, args...),
+		Warning: warningIssues[kind],
+	})
+}
+
+// validateEventData looks for mistakes in chunks of event data, keyed by data file names,
+// which parseEventDataChunk can't detect on its own: items overriding those of earlier files,
+// references to undefined speakers, rooms and tags, sessions ending before they start,
+// overlapping sessions in the same room and malformed thumbnail URLs.
+// Issues found by parseEventDataChunk are included in the report as well.
+//
+// files is the order of data files in the manifest; later files override earlier ones.
+// validateEventData must be called before chunks are merged, while sessions still
+// refer to rooms by their IDs.
+func validateEventData(files []string, chunks map[string]*eventData) *validationReport {
+	report := &validationReport{Created: time.Now(), Files: files}
+	// defined items mapped to the last file defining them; keyed by [item, id]
+	defs := make(map[[2]string]string)
+	sessionFiles := make(map[string]string)
+	sessions := make(map[string]*eventSession)
+
+	issues := make(map[string]*issueList, len(files))
+	for _, f := range files {
+		chunk := chunks[f]
+		l := issueList(chunk.issues)
+		issues[f] = &l
+		define := func(item, id string) {
+			k := [2]string{item, id}
+			// duplicates within a file are reported by parseEventDataChunk
+			if prev, ok := defs[k]; ok {
+				l.add(issueOverride, item, id, "overrides the one defined in %s", prev)
+			}
+			defs[k] = f
+		}
+
+		for id := range chunk.rooms {
+			define("room", id)
+		}
+		for id := range chunk.Tags {
+			define("tag", id)
+		}
+		for id, s := range chunk.Speakers {
+			define("speaker", id)
+			if isBadThumbURL(s.Thumb) {
+				l.add(issueBadThumbnail, "speaker", id, "malformed thumbnail URL %q", s.Thumb)
+			}
+		}
+		for id, v := range chunk.Videos {
+			define("video", id)
+			if isBadThumbURL(v.Thumb) {
+				l.add(issueBadThumbnail, "video", id, "malformed thumbnail URL %q", v.Thumb)
+			}
+		}
+		for id, s := range chunk.Sessions {
+			define("session", id)
+			sessions[id] = s
+			sessionFiles[id] = f
+			if s.EndTime.Before(s.StartTime) {
+				l.add(issueEndBeforeStart, "session", id, "ends at %s before it starts at %s",
+					s.EndTime.Format(time.RFC3339), s.StartTime.Format(time.RFC3339))
+			}
+			if isBadThumbURL(s.Photo) {
+				l.add(issueBadThumbnail, "session", id, "malformed photo URL %q", s.Photo)
+			}
+		}
+	}
+
+	// references can point to items in any file
+	for _, f := range files {
+		l := issues[f]
+		for id, s := range chunks[f].Sessions {
+			if _, ok := defs[[2]string{"room", s.Room}]; s.Room != "" && !ok {
+				l.add(issueDanglingRoom, "session", id, "room %q is not defined", s.Room)
+			}
+			for _, sp := range s.Speakers {
+				if _, ok := defs[[2]string{"speaker", sp}]; !ok {
+					l.add(issueDanglingSpeaker, "session", id, "speaker %q is not defined", sp)
+				}
+			}
+			for _, t := range s.Tags {
+				if _, ok := defs[[2]string{"tag", t}]; !ok {
+					l.add(issueDanglingTag, "session", id, "tag %q is not defined", t)
+				}
+			}
+		}
+	}
+
+	// sessions overlapping in the same room; only the final version of a session counts
+	byRoom := make(map[string][]*eventSession)
+	for _, s := range sessions {
+		if s.Room != "" && !s.EndTime.Before(s.StartTime) {
+			byRoom[s.Room] = append(byRoom[s.Room], s)
+		}
+	}
+	for room, list := range byRoom {
+		sort.Sort(sortedSessionsList(list))
+		last := list[0] // the session which ends last so far
+		for _, s := range list[1:] {
+			if s.StartTime.Before(last.EndTime) {
+				issues[sessionFiles[s.ID]].add(issueRoomOverlap, "session", s.ID,
+					"overlaps with session %q in room %q", last.ID, room)
+			}
+			if s.EndTime.After(last.EndTime) {
+				last = s
+			}
+		}
+	}
+
+	for _, f := range files {
+		for _, is := range *issues[f] {
+			is.File = f
+			report.Issues = append(report.Issues, is)
+		}
+	}
+	sort.Sort(sortedIssuesList(report.Issues))
+	return report
+}
+
+// isBadThumbURL reports whether u contains imageURLSizeMarker
+// but is not in the format thumbURL understands.
+func isBadThumbURL(u string) bool {
+	return strings.Contains(u, imageURLSizeMarker) && !reThumbSizes.MatchString(u)
+}
+
+// sortedIssuesList implements sort.Sort ordering items by:
+//   - file
+//   - item and its ID
+//   - kind
+//   - message
+type sortedIssuesList []*validationIssue
+
+func (l sortedIssuesList) Len() int {
+	return len(l)
+}
+
+func (l sortedIssuesList) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+}
+
+func (l sortedIssuesList) Less(i, j int) bool {
+	a, b := l[i], l[j]
+	switch {
+	case a.File != b.File:
+		return a.File < b.File
+	case a.Item != b.Item:
+		return a.Item < b.Item
+	case a.ID != b.ID:
+		return a.ID < b.ID
+	case a.Kind != b.Kind:
+		return a.Kind < b.Kind
+	}
+	return a.Msg < b.Msg
+}
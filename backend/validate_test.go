@@ -0,0 +1,113 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValidateEventData(t *testing.T) {
+	defer preserveConfig()()
+	config().Schedule.Start = time.Date(2016, 5, 18, 0, 0, 0, 0, time.UTC)
+
+	files := map[string]string{
+		"meta.json": `{
+			"rooms": [{"id": "room-a", "name": "A"}, {"id": "room-a", "name": "A2"}, {"name": "No ID"}],
+			"tags": [{"tag": "TOPIC_WEB", "name": "Web"}]
+		}`,
+		"speakers.json": `{"speakers": [
+			{"id": "spk-1", "thumbnailUrl": "http://example.org/__w-200-400/1.jpg"},
+			{"id": "spk-2", "thumbnailUrl": "http://example.org/__w-200/2.jpg"},
+			{"name": "Anonymous"}
+		]}`,
+		"sessions.json": `{"sessions": [
+			{"id": "s1", "room": "room-a", "speakers": ["spk-1"], "tags": ["TOPIC_WEB"],
+			 "startTimestamp": "2016-05-18T10:00:00Z", "endTimestamp": "2016-05-18T11:00:00Z"},
+			{"id": "s2", "room": "room-a", "speakers": ["spk-3"], "tags": ["TOPIC_VR"],
+			 "startTimestamp": "2016-05-18T10:30:00Z", "endTimestamp": "2016-05-18T11:30:00Z"},
+			{"id": "s3", "room": "room-b",
+			 "startTimestamp": "2016-05-18T12:00:00Z", "endTimestamp": "2016-05-18T11:00:00Z"},
+			{"id": "old", "startTimestamp": "2015-05-28T10:00:00Z"},
+			{"title": "No ID", "startTimestamp": "2016-05-18T10:00:00Z"}
+		]}`,
+		"more.json": `{"speakers": [{"id": "spk-1"}]}`,
+	}
+	order := []string{"meta.json", "speakers.json", "sessions.json", "more.json"}
+	chunks := make(map[string]*eventData)
+	for name, b := range files {
+		d, err := parseEventDataChunk([]byte(b))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		chunks[name] = d
+	}
+
+	report := validateEventData(order, chunks)
+	type issue struct{ file, kind, item, id string }
+	want := []issue{
+		{"meta.json", issueMissingID, "room", ""},
+		{"meta.json", issueDuplicateID, "room", "room-a"},
+		{"more.json", issueOverride, "speaker", "spk-1"},
+		{"sessions.json", issueMissingID, "session", ""},
+		{"sessions.json", issueBeforeStart, "session", "old"},
+		{"sessions.json", issueDanglingSpeaker, "session", "s2"},
+		{"sessions.json", issueDanglingTag, "session", "s2"},
+		{"sessions.json", issueRoomOverlap, "session", "s2"},
+		{"sessions.json", issueDanglingRoom, "session", "s3"},
+		{"sessions.json", issueEndBeforeStart, "session", "s3"},
+		{"speakers.json", issueMissingID, "speaker", ""},
+		{"speakers.json", issueBadThumbnail, "speaker", "spk-2"},
+	}
+	got := make([]issue, len(report.Issues))
+	for i, is := range report.Issues {
+		got[i] = issue{is.File, is.Kind, is.Item, is.ID}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("report.Issues:\n%v\nwant:\n%v", got, want)
+	}
+	for _, is := range report.Issues {
+		if is.Warning != (is.Kind == issueOverride) {
+			t.Errorf("%s %s %q: Warning = %v", is.File, is.Kind, is.ID, is.Warning)
+		}
+	}
+	if n, w := report.count(); n != len(want)-1 || w != 1 {
+		t.Errorf("report.count() = %d, %d; want %d, 1", n, w, len(want)-1)
+	}
+	if !reflect.DeepEqual(report.Files, order) {
+		t.Errorf("report.Files = %v; want %v", report.Files, order)
+	}
+}
+
+func TestIsBadThumbURL(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		url string
+		bad bool
+	}{
+		{"", false},
+		{"http://example.org/img.jpg", false},
+		{"http://example.org/__w-200-400-600/img.jpg", false},
+		{"http://example.org/__w-200/img.jpg", true},
+		{"http://example.org/__w-200-400", true},
+		{"http://example.org/__w-small-large/img.jpg", true},
+	}
+	for _, test := range tests {
+		if v := isBadThumbURL(test.url); v != test.bad {
+			t.Errorf("isBadThumbURL(%q) = %v; want %v", test.url, v, test.bad)
+		}
+	}
+}
@@ -62,9 +62,12 @@ func wipeoutShard(c context.Context, shard string) error {
 	}
 
 	for range userData {
-		if err := <-ch; err != nil {
+		err := <-ch
+		if err != nil {
+			wipeoutTotal.inc("error")
 			return err
 		}
+		wipeoutTotal.inc("success")
 	}
 
 	return nil